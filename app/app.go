@@ -0,0 +1,419 @@
+// Package app builds the whole server from its settings: logger, repository, services, handlers and router.
+// An app keeps its state to itself, except for what its dependencies hold process-wide: the log level, the
+// otel tracer provider and propagator, and the types redacted from logs. Several apps can run in one process,
+// e.g. in tests, but they share those, set by the last app created or reloaded.
+package app
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-chi/chi"
+	"github.com/gugabfigueiredo/star-wars-api/cache"
+	"github.com/gugabfigueiredo/star-wars-api/env"
+	"github.com/gugabfigueiredo/star-wars-api/graph"
+	"github.com/gugabfigueiredo/star-wars-api/handler"
+	"github.com/gugabfigueiredo/star-wars-api/log"
+	"github.com/gugabfigueiredo/star-wars-api/metrics"
+	"github.com/gugabfigueiredo/star-wars-api/model"
+	"github.com/gugabfigueiredo/star-wars-api/repository"
+	"github.com/gugabfigueiredo/star-wars-api/service"
+	"github.com/gugabfigueiredo/star-wars-api/tracing"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Deps are the dependencies New builds from the settings when left nil. Tests pass stubs and fakes instead.
+type Deps struct {
+	Logger *log.Logger
+	// Repo, Leases, Database and Migrator default to a repository connected with the database settings
+	Repo     repository.IRepo
+	Leases   repository.ILeaseRepo
+	Database service.IPinger
+	Migrator repository.IMigrator
+	// Swapi is the planet source, before retries and the circuit breaker are added
+	Swapi service.ISwapi
+	// Related is the source of films and people, it defaults to Swapi when Swapi can read them
+	Related service.IRelated
+}
+
+type App struct {
+	Settings *env.Settings
+	Logger   *log.Logger
+	Metrics  *metrics.Metrics
+	Tracing  *tracing.Tracing
+
+	API    *service.APIService
+	Lease  *service.LeaseService
+	Swapi  *service.SwapiService
+	Health *service.HealthService
+	// Cache serves repeated planet reads, nil when cache.size is 0
+	Cache *cache.Repo
+
+	// Migrator sets up the database indexes, it is not run by Start
+	Migrator repository.IMigrator
+
+	Router http.Handler
+
+	server   *http.Server
+	listener net.Listener
+	serveErr chan error
+
+	// repo is the repository connected by New, disconnected on Shutdown
+	repo *repository.Repository
+
+	cancelJobs context.CancelFunc
+	jobs       map[string]<-chan struct{}
+
+	// reloading serializes reloads from SIGHUP and from the config file watch
+	reloading sync.Mutex
+}
+
+// New wires an app from settings, connecting to the database unless deps provides one.
+// Nothing runs until Start. When wiring fails, whatever New had already connected is released.
+func New(ctx context.Context, settings *env.Settings, deps Deps) (_ *App, err error) {
+	a := &App{
+		Settings: settings,
+		Logger:   deps.Logger,
+		Metrics:  metrics.New(),
+	}
+
+	name, _ := os.Hostname()
+	if a.Logger == nil {
+		a.Logger = log.New(settings.Log).C("host", name)
+	}
+	if err := settings.Resolve(); err != nil {
+		return nil, err
+	}
+
+	tracer, err := tracing.New(ctx, settings.Tracing)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure tracing: %s", err)
+	}
+	a.Tracing = tracer
+	defer func() {
+		if err != nil {
+			a.release(ctx)
+		}
+	}()
+
+	if deps.Repo == nil || deps.Leases == nil || deps.Database == nil || deps.Migrator == nil {
+		repo, err := repository.New(settings.Database, a.Logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize database connection: %s", err)
+		}
+		a.repo = repo
+		if deps.Repo == nil {
+			deps.Repo = repo
+		}
+		if deps.Leases == nil {
+			deps.Leases = repo
+		}
+		if deps.Database == nil {
+			deps.Database = repo
+		}
+		if deps.Migrator == nil {
+			deps.Migrator = repo
+		}
+	}
+	a.Migrator = deps.Migrator
+
+	if deps.Swapi == nil {
+		if deps.Swapi, err = service.NewUpstream(settings.Swapi); err != nil {
+			return nil, fmt.Errorf("failed to configure swapi upstream: %s", err)
+		}
+	}
+
+	mergePolicy, err := model.ParseMergePolicy(settings.Swapi.MergePolicy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure sync merge policy: %s", err)
+	}
+
+	// Services
+	helloService := &service.HelloService{
+		Logger: a.Logger,
+	}
+
+	a.Lease = &service.LeaseService{
+		ILeaseRepo: deps.Leases,
+		Config:     settings.Lease,
+		Logger:     a.Logger,
+	}
+
+	if deps.Related == nil {
+		deps.Related, _ = deps.Swapi.(service.IRelated)
+	}
+
+	a.Swapi = &service.SwapiService{
+		ISwapi: a.Metrics.Swapi(deps.Swapi),
+		Config: settings.Swapi,
+		Logger: a.Logger,
+	}
+	if deps.Related != nil {
+		a.Swapi.Related = a.Metrics.Related(deps.Related)
+	}
+
+	// the cache wraps the repository metrics, so they count only the reads that reach the database
+	repo := a.Metrics.Repo(deps.Repo)
+	if settings.Cache.Size > 0 {
+		a.Cache = cache.New(repo, settings.Cache, a.Metrics)
+		a.Metrics.WatchCache(a.Cache)
+		repo = a.Cache
+	}
+
+	a.API = &service.APIService{
+		IRepo:       tracing.Repo(repo),
+		SwapiClient: a.Swapi,
+		Leader:      a.Lease,
+		MergePolicy: mergePolicy,
+		Observer:    a.Metrics,
+		Logger:      a.Logger,
+	}
+
+	a.Metrics.WatchScheduler(a.API)
+	a.Metrics.WatchLease(a.Lease)
+	a.Metrics.WatchSwapi(a.Swapi)
+
+	a.Health = &service.HealthService{
+		Database:  deps.Database,
+		Sync:      a.API,
+		Swapi:     a.Swapi,
+		Config:    settings.Health,
+		StartedAt: time.Now(),
+	}
+
+	// Handlers
+	helloHandler := &handler.HelloHandler{
+		Service: helloService,
+		Logger:  a.Logger,
+	}
+
+	apiHandler := &handler.APIHandler{
+		IService: a.API,
+		Logger:   a.Logger,
+	}
+
+	swapiHandler := &handler.SwapiHandler{
+		SwapiService: a.Swapi,
+		Logger:       a.Logger,
+	}
+
+	healthHandler := &handler.HealthHandler{
+		Service: a.Health,
+		Logger:  a.Logger,
+	}
+
+	leaseHandler := &handler.LeaseHandler{
+		Service: a.Lease,
+		Logger:  a.Logger,
+	}
+
+	logHandler := &handler.LogHandler{
+		Logger: a.Logger,
+	}
+
+	schema, err := graph.New(a.API, a.Swapi, settings.GraphQL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse graphql schema: %s", err)
+	}
+	graphQLHandler := &handler.GraphQLHandler{
+		Schema: schema,
+		Logger: a.Logger,
+	}
+
+	// Create a route along /files that will serve contents from
+	// the ./data/ folder.
+	workDir, _ := os.Getwd()
+	docs := http.Dir(filepath.Join(workDir, "docs"))
+
+	r := chi.NewRouter()
+	r.Use(a.Tracing.Middleware)
+	r.Use(handler.RequestLogger(a.Logger, settings.HTTP))
+	r.Use(a.Metrics.Middleware)
+	r.Handle("/metrics", a.Metrics.Handler())
+	r.Route(fmt.Sprintf("/%s", settings.Server.Context), func(r chi.Router) {
+		r.Get("/docs", func(w http.ResponseWriter, r *http.Request) {
+			rctx := chi.RouteContext(r.Context())
+			pathPrefix := strings.TrimSuffix(rctx.RoutePattern(), "/*")
+			fs := http.StripPrefix(pathPrefix, http.FileServer(docs))
+			fs.ServeHTTP(w, r)
+		})
+
+		r.Get("/health", helloHandler.SayHello)
+		r.Get("/livez", healthHandler.Live)
+		r.Get("/readyz", healthHandler.Ready)
+		r.Get("/sync/lease", leaseHandler.GetLeaseStatus)
+		r.Get("/sync/upstream", swapiHandler.GetUpstreamHealth)
+
+		r.Get("/admin/log/level", logHandler.GetLevel)
+		r.With(handler.AdminOnly(settings.Admin)).Put("/admin/log/level", logHandler.SetLevel)
+
+		r.Group(func(r chi.Router) {
+			r.Use(handler.Timeout(settings.Server.RequestTimeout), handler.Compress(settings.HTTP.Encodings, settings.HTTP.CompressMinSize),
+				handler.MaxBodySize(settings.HTTP.MaxBodySize))
+			r.Get("/graphql", graphQLHandler.Serve)
+			r.Post("/graphql", graphQLHandler.Serve)
+		})
+
+		r.Route("/planets", func(r chi.Router) {
+			r.With(handler.Timeout(settings.Server.SyncRequestTimeout)).
+				Get("/update-movies", apiHandler.SetMovieRefs)
+
+			// bulk transfers and the listing stream in and out for longer than the server timeouts, they get their own
+			r.Group(func(r chi.Router) {
+				r.Use(handler.ConnDeadline(settings.Server.BulkRequestTimeout), handler.Timeout(settings.Server.BulkRequestTimeout))
+				r.Post("/import", apiHandler.ImportPlanets)
+				r.With(handler.Caching(settings.HTTP, settings.HTTP.Export)).
+					Get("/export", apiHandler.ExportPlanets)
+				r.With(handler.Caching(settings.HTTP, settings.HTTP.Planets)).
+					Get("/", apiHandler.FindAllPlanets)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(handler.Timeout(settings.Server.RequestTimeout))
+
+				r.With(handler.Caching(settings.HTTP, settings.HTTP.Planet)).
+					Get("/name/{name:[A-Za-z0-9_]+}", apiHandler.FindPlanetByName)
+				r.With(handler.Caching(settings.HTTP, settings.HTTP.Planet)).
+					Get("/id/{planetID:[0-9]+}", apiHandler.FindPlanetByID)
+
+				r.Group(func(r chi.Router) {
+					r.Use(handler.MaxBodySize(settings.HTTP.MaxBodySize))
+					r.Post("/create", apiHandler.CreatePlanets)
+					r.Post("/update", apiHandler.PlanetUpdate)
+					r.Post("/delete", apiHandler.RemovePlanets)
+				})
+			})
+		})
+	})
+	a.Router = r
+
+	a.server = &http.Server{
+		Handler:        a.Router,
+		ReadTimeout:    10 * time.Second,
+		WriteTimeout:   10 * time.Second,
+		MaxHeaderBytes: 1 << 20,
+		ConnContext:    handler.ConnContext,
+	}
+	return a, nil
+}
+
+// release disconnects the database and flushes traces of an app New failed to wire
+func (a *App) release(ctx context.Context) {
+	if a.repo != nil {
+		if err := a.repo.Disconnect(ctx); err != nil {
+			a.Logger.E("failed to disconnect from database", "err", err)
+		}
+	}
+	if err := a.Tracing.Shutdown(ctx); err != nil {
+		a.Logger.E("failed to flush traces", "err", err)
+	}
+}
+
+// Start listens on the server port, then serves requests and runs the background jobs until Shutdown:
+// the lease campaign, the scheduled sync, the sync on start and the config file watch.
+// Failures to serve after Start are sent to Err.
+func (a *App) Start(ctx context.Context) error {
+	listener, err := (&net.ListenConfig{}).Listen(ctx, "tcp", fmt.Sprintf(":%s", a.Settings.Server.Port))
+	if err != nil {
+		return err
+	}
+	a.listener = listener
+
+	// background jobs are cancelled as soon as shutdown starts
+	jobs, cancelJobs := context.WithCancel(context.Background())
+	a.cancelJobs = cancelJobs
+
+	// only the replica holding the sync lease runs the scheduled update
+	campaign := a.Lease.Campaign(jobs)
+
+	// update planet movie refs
+	schedule := a.API.SchedulePlanetUpdate(jobs, a.Settings.Server.UpdateRefsTimeout)
+
+	seed := make(chan struct{})
+	go func() {
+		defer close(seed)
+		if a.Settings.Server.SyncOnStart && a.Lease.IsLeader() {
+			if _, err := a.API.UpdatePlanetRefs(jobs); err != nil {
+				a.Logger.E("failed to seed planets on start", "err", err)
+			}
+		}
+	}()
+
+	a.jobs = map[string]<-chan struct{}{"schedule": schedule, "seed": seed, "lease": campaign}
+	if file := a.Settings.File(); file != "" && a.Settings.Server.ConfigPollInterval > 0 {
+		a.jobs["reload"] = a.watchConfig(jobs, file)
+	}
+
+	a.Logger.I("Starting server...", "addr", a.Addr())
+
+	a.serveErr = make(chan error, 1)
+	go func() {
+		if err := a.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			a.serveErr <- err
+		}
+		close(a.serveErr)
+	}()
+	return nil
+}
+
+// Addr is the address the app listens on once started, with the port picked when the setting is 0
+func (a *App) Addr() string {
+	if a.listener == nil {
+		return ""
+	}
+	return a.listener.Addr().String()
+}
+
+// Err receives the error that stopped the server from serving, it is closed once the server stops
+func (a *App) Err() <-chan error {
+	return a.serveErr
+}
+
+// Shutdown stops accepting requests, cancels a running sync and the other background jobs, waits for
+// in-flight requests and jobs to finish until ctx is done, then disconnects the database and flushes traces.
+// The first failure is returned, every one of them is logged.
+func (a *App) Shutdown(ctx context.Context) error {
+	var first error
+	fail := func(message string, err error) {
+		a.Logger.E(message, "err", err)
+		if first == nil {
+			first = err
+		}
+	}
+
+	if a.cancelJobs != nil {
+		a.cancelJobs()
+	}
+	if err := a.server.Shutdown(ctx); err != nil {
+		fail("failed to drain in-flight requests", err)
+		a.server.Close()
+	}
+
+	for name, done := range a.jobs {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			a.Logger.W("background job did not stop before shutdown deadline", "job", name)
+		}
+	}
+
+	if a.repo != nil {
+		if err := a.repo.Disconnect(ctx); err != nil {
+			fail("failed to disconnect from database", err)
+		}
+	}
+
+	if err := a.Tracing.Shutdown(ctx); err != nil {
+		fail("failed to flush traces", err)
+	}
+
+	if a.listener != nil {
+		a.Logger.I("Server stopped")
+	}
+	return first
+}
@@ -0,0 +1,131 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gugabfigueiredo/star-wars-api/env"
+	"github.com/gugabfigueiredo/star-wars-api/log"
+	"github.com/gugabfigueiredo/star-wars-api/model"
+	"github.com/gugabfigueiredo/star-wars-api/test"
+	"github.com/gugabfigueiredo/star-wars-api/test/fakeswapi"
+	"github.com/gugabfigueiredo/swapi"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newApp(t *testing.T, holder string, repo *test.Stub, leases *test.LeaseStub, args ...string) *App {
+	settings, _, err := env.Load(append([]string{
+		"-log.fileloggingenabled=false",
+		"-server.port", "0",
+		"-lease.holder", holder,
+	}, args...))
+	assert.NoError(t, err)
+
+	a, err := New(context.Background(), settings, Deps{
+		Logger:   log.New(&log.Config{Context: "sw-api-test"}),
+		Repo:     repo,
+		Leases:   leases,
+		Database: repo,
+		Migrator: repo,
+	})
+	assert.NoError(t, err)
+	return a
+}
+
+func TestApp_Replicas(t *testing.T) {
+
+	upstream := fakeswapi.New([]swapi.Planet{{Name: "Tatooine"}, {Name: "Hoth"}})
+	defer upstream.Close()
+
+	// two replicas in one process, sharing the lease table but not their planets
+	leases := &test.LeaseStub{}
+	repos := []*test.Stub{
+		{Planets: []*model.Planet{{Name: "Alderaan"}}},
+		{Planets: []*model.Planet{{Name: "Dagobah"}}},
+	}
+	apps := []*App{
+		newApp(t, "replica-0", repos[0], leases, "-server.synconstart", "-swapi.baseurl", upstream.URL),
+		newApp(t, "replica-1", repos[1], leases, "-server.synconstart", "-swapi.baseurl", upstream.URL),
+	}
+
+	for i, a := range apps {
+		assert.NoError(t, a.Start(context.Background()))
+
+		resp, err := http.Get("http://" + a.Addr() + "/sw-api/planets/")
+		assert.NoError(t, err)
+		var planets []model.Planet
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&planets))
+		resp.Body.Close()
+		assert.Equal(t, repos[i].Planets[0].Name, planets[0].Name)
+
+		resp, err = http.Get("http://" + a.Addr() + "/metrics")
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, a := range apps {
+		assert.NoError(t, a.Shutdown(ctx))
+	}
+
+	// only the lease holder synced on start
+	assert.Len(t, repos[0].CalledWith["planets"], 2)
+	assert.Nil(t, repos[1].CalledWith)
+
+	_, err := http.Get("http://" + apps[0].Addr() + "/sw-api/livez")
+	assert.Error(t, err)
+}
+
+func TestApp_GraphQLFilms(t *testing.T) {
+
+	upstream := fakeswapi.New(nil, fakeswapi.Films(swapi.Film{Title: "A New Hope", URL: "https://swapi.dev/api/films/1/"}))
+	defer upstream.Close()
+
+	repo := &test.Stub{Planets: []*model.Planet{{Name: "Tatooine", Films: []string{"https://swapi.dev/api/films/1/"}}}}
+	a := newApp(t, "replica-0", repo, &test.LeaseStub{}, "-swapi.baseurl", upstream.URL)
+	assert.NoError(t, a.Start(context.Background()))
+	defer a.Shutdown(context.Background())
+
+	query := url.Values{"query": {`{ planets(first: 1) { planets { name films { title } } } }`}}
+	resp, err := http.Get("http://" + a.Addr() + "/sw-api/graphql?" + query.Encode())
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"data":{"planets":{"planets":[{"name":"Tatooine","films":[{"title":"A New Hope"}]}]}}}`, string(body))
+}
+
+func TestApp_Reload(t *testing.T) {
+
+	file, err := ioutil.TempFile(t.TempDir(), "config-*.yaml")
+	assert.NoError(t, err)
+	_, err = file.WriteString("log:\n  level: warn\nswapi:\n  mergepolicy: local-wins\n")
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+	defer log.SetLevel(log.Level())
+
+	a := newApp(t, "replica-0", &test.Stub{}, &test.LeaseStub{}, "-config", file.Name())
+	assert.NoError(t, ioutil.WriteFile(file.Name(), []byte("log:\n  level: debug\nswapi:\n  mergepolicy: fill-empty\n"), 0644))
+
+	// SIGHUP and the config file watch reload concurrently
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := a.Reload()
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, "debug", a.Settings.Log.Level)
+	assert.Equal(t, model.FillEmpty, a.API.MergePolicy)
+}
@@ -0,0 +1,61 @@
+package app
+
+import (
+	"context"
+	"github.com/gugabfigueiredo/star-wars-api/env"
+	"github.com/gugabfigueiredo/star-wars-api/log"
+	"github.com/gugabfigueiredo/star-wars-api/model"
+)
+
+// Reload reloads the settings and applies the ones that are safe to change to the running services.
+// Changes to the others are logged and wait for a restart. Reloads run one at a time.
+func (a *App) Reload() ([]env.Change, error) {
+	a.reloading.Lock()
+	defer a.reloading.Unlock()
+
+	changes, err := a.Settings.Reload()
+	if err != nil {
+		a.Logger.E("rejected configuration reload, keeping current settings", "err", err)
+		return nil, err
+	}
+	a.Logger.I("configuration reloaded", "changes", len(changes))
+
+	for _, change := range changes {
+		if !change.Live {
+			a.Logger.W("setting only changes on restart, keeping current value",
+				"setting", change.Name, "current", change.From, "requested", change.To)
+			continue
+		}
+		switch change.Name {
+		case "log.level":
+			log.SetLevel(a.Settings.Log.Level)
+		case "server.updaterefstimeout":
+			a.API.Reschedule(a.Settings.Server.UpdateRefsTimeout)
+		case "swapi.mergepolicy":
+			// validated by the reload
+			policy, _ := model.ParseMergePolicy(a.Settings.Swapi.MergePolicy)
+			a.API.SetMergePolicy(policy)
+		}
+		a.Logger.I("setting changed", "setting", change.Name, "from", change.From, "to", change.To)
+	}
+	return changes, nil
+}
+
+// watchConfig reloads the settings whenever the config file changes, until ctx is done
+func (a *App) watchConfig(ctx context.Context, file string) <-chan struct{} {
+	changed := env.WatchFile(ctx, file, a.Settings.Server.ConfigPollInterval)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-changed:
+				a.Reload()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return done
+}
@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"container/list"
+	"time"
+)
+
+// entry is a cached read, an answer that the planet does not exist included
+type entry struct {
+	key     string
+	value   interface{}
+	err     error
+	expires time.Time
+}
+
+// lru keeps up to size entries, evicting the least recently used first. It is not safe for concurrent use.
+type lru struct {
+	size  int
+	items map[string]*list.Element
+	order *list.List
+}
+
+func newLRU(size int) *lru {
+	return &lru{size: size, items: map[string]*list.Element{}, order: list.New()}
+}
+
+// get is the entry of key, unless it expired by now
+func (c *lru) get(key string, now time.Time) (*entry, bool) {
+	element, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	e := element.Value.(*entry)
+	if !now.Before(e.expires) {
+		c.remove(element)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return e, true
+}
+
+// add stores e, replacing the entry of its key, and evicts the least recently used entries over size
+func (c *lru) add(e *entry) {
+	if element, ok := c.items[e.key]; ok {
+		element.Value = e
+		c.order.MoveToFront(element)
+		return
+	}
+	c.items[e.key] = c.order.PushFront(e)
+
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *lru) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.items, element.Value.(*entry).key)
+}
+
+func (c *lru) purge() {
+	c.items = map[string]*list.Element{}
+	c.order.Init()
+}
+
+func (c *lru) len() int {
+	return c.order.Len()
+}
@@ -0,0 +1,224 @@
+// Package cache serves repeated planet reads from memory, in front of any repository.IRepo
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gugabfigueiredo/star-wars-api/model"
+	"github.com/gugabfigueiredo/star-wars-api/repository"
+	"github.com/gugabfigueiredo/swapi"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/sync/singleflight"
+	"sync"
+	"time"
+)
+
+// Config - Configuration of the in-process planet cache
+type Config struct {
+	// Size is how many reads are kept, the least recently used are evicted first. 0 disables the cache.
+	Size int `default:"1000"`
+	// TTL bounds how long a read is served from memory. Writes through this replica invalidate the cache at once,
+	// writes and syncs of other replicas show once it expires.
+	TTL time.Duration `default:"30s"`
+}
+
+const (
+	// ResultHit is a read served from memory
+	ResultHit = "hit"
+	// ResultMiss is a read loaded from the repository
+	ResultMiss = "miss"
+	// ResultShared is a read that waited for the same read of another caller instead of loading it again
+	ResultShared = "shared"
+)
+
+// IObserver is told how every cached read was served, e.g. to record metrics
+type IObserver interface {
+	ObserveCache(method, result string)
+}
+
+// Repo is an IRepo keeping planets found by name or id, and when planets last changed, in an LRU.
+// Concurrent misses of the same read share one repository call. Every write invalidates the whole cache,
+// listings and streams are not cached and always read the repository.
+type Repo struct {
+	repository.IRepo
+	observer IObserver
+	ttl      time.Duration
+	now      func() time.Time
+
+	mu    sync.Mutex
+	lru   *lru
+	group singleflight.Group
+	// generation changes on every invalidation, reads loaded before it are not stored
+	generation uint64
+}
+
+// New decorates repo with a cache sized by config, observer may be nil
+func New(repo repository.IRepo, config *Config, observer IObserver) *Repo {
+	return &Repo{
+		IRepo:    repo,
+		observer: observer,
+		ttl:      config.TTL,
+		now:      time.Now,
+		lru:      newLRU(config.Size),
+	}
+}
+
+// Len is how many reads are cached
+func (r *Repo) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lru.len()
+}
+
+// Invalidate drops every cached read, e.g. after planets were changed around this repository
+func (r *Repo) Invalidate() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.generation++
+	r.lru.purge()
+}
+
+func (r *Repo) observe(method, result string) {
+	if r.observer != nil {
+		r.observer.ObserveCache(method, result)
+	}
+}
+
+// cacheable reports whether the outcome of a read is an answer worth keeping rather than a failure
+func cacheable(err error) bool {
+	return err == nil || err == mongo.ErrNoDocuments
+}
+
+// read serves key from memory or loads it, sharing the load with concurrent readers of the same key
+func (r *Repo) read(ctx context.Context, method, key string, load func(context.Context) (interface{}, error)) (interface{}, error) {
+	r.mu.Lock()
+	if e, ok := r.lru.get(key, r.now()); ok {
+		r.mu.Unlock()
+		r.observe(method, ResultHit)
+		return e.value, e.err
+	}
+	generation := r.generation
+	r.mu.Unlock()
+
+	loaded := false
+	value, err, _ := r.group.Do(fmt.Sprintf("%d/%s", generation, key), func() (interface{}, error) {
+		loaded = true
+		value, err := load(ctx)
+		if cacheable(err) {
+			r.mu.Lock()
+			if r.generation == generation {
+				r.lru.add(&entry{key: key, value: value, err: err, expires: r.now().Add(r.ttl)})
+			}
+			r.mu.Unlock()
+		}
+		return value, err
+	})
+	if loaded {
+		r.observe(method, ResultMiss)
+		return value, err
+	}
+
+	r.observe(method, ResultShared)
+	// the caller that loaded it gave up, which says nothing about this one
+	if (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) && ctx.Err() == nil {
+		return load(ctx)
+	}
+	return value, err
+}
+
+// planetKey is the cache key of a filter on a single name or id, false for filters that are not cached
+func planetKey(filter interface{}) (string, bool) {
+	m, ok := filter.(bson.M)
+	if !ok || len(m) != 1 {
+		return "", false
+	}
+	for field, value := range m {
+		if field != "name" && field != "_id" {
+			return "", false
+		}
+		switch value.(type) {
+		case string, primitive.ObjectID:
+			return fmt.Sprintf("planet/%s/%v", field, value), true
+		}
+	}
+	return "", false
+}
+
+// copyPlanet keeps callers from changing the cached planet
+func copyPlanet(planet *model.Planet) model.Planet {
+	copied := *planet
+	if planet.Sources != nil {
+		copied.Sources = make(map[string]string, len(planet.Sources))
+		for field, source := range planet.Sources {
+			copied.Sources[field] = source
+		}
+	}
+	if planet.UpdatedAt != nil {
+		updatedAt := *planet.UpdatedAt
+		copied.UpdatedAt = &updatedAt
+	}
+	copied.Films = append([]string(nil), planet.Films...)
+	copied.Residents = append([]string(nil), planet.Residents...)
+	return copied
+}
+
+func (r *Repo) GetPlanet(ctx context.Context, filter interface{}, planet *model.Planet) error {
+	key, ok := planetKey(filter)
+	if !ok {
+		return r.IRepo.GetPlanet(ctx, filter, planet)
+	}
+
+	value, err := r.read(ctx, "GetPlanet", key, func(ctx context.Context) (interface{}, error) {
+		var found model.Planet
+		if err := r.IRepo.GetPlanet(ctx, filter, &found); err != nil {
+			return nil, err
+		}
+		return &found, nil
+	})
+	if err != nil {
+		return err
+	}
+	*planet = copyPlanet(value.(*model.Planet))
+	return nil
+}
+
+func (r *Repo) LastModified(ctx context.Context) (time.Time, error) {
+	value, err := r.read(ctx, "LastModified", "lastModified", func(ctx context.Context) (interface{}, error) {
+		return r.IRepo.LastModified(ctx)
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return value.(time.Time), nil
+}
+
+// writes invalidate the cache even when they fail, part of them may have been applied
+
+func (r *Repo) InsertPlanets(ctx context.Context, planets []model.Planet) (*mongo.InsertManyResult, error) {
+	defer r.Invalidate()
+	return r.IRepo.InsertPlanets(ctx, planets)
+}
+
+func (r *Repo) UpdatePlanets(ctx context.Context, planets []model.Planet) (*mongo.BulkWriteResult, error) {
+	defer r.Invalidate()
+	return r.IRepo.UpdatePlanets(ctx, planets)
+}
+
+func (r *Repo) UpsertPlanets(ctx context.Context, planets []model.Planet) (*mongo.BulkWriteResult, error) {
+	defer r.Invalidate()
+	return r.IRepo.UpsertPlanets(ctx, planets)
+}
+
+// UpdateMovieRefs is the write of a swapi sync
+func (r *Repo) UpdateMovieRefs(ctx context.Context, planets []swapi.Planet, policy model.MergePolicy) (*mongo.BulkWriteResult, []model.FieldConflict, error) {
+	defer r.Invalidate()
+	return r.IRepo.UpdateMovieRefs(ctx, planets, policy)
+}
+
+func (r *Repo) DeletePlanets(ctx context.Context, planets []model.Planet) (*mongo.DeleteResult, error) {
+	defer r.Invalidate()
+	return r.IRepo.DeletePlanets(ctx, planets)
+}
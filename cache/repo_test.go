@@ -0,0 +1,235 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"github.com/gugabfigueiredo/star-wars-api/model"
+	"github.com/gugabfigueiredo/star-wars-api/test"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingRepo counts the reads that reach it, and holds them until release is closed when it is set
+type countingRepo struct {
+	*test.Stub
+	reads   int32
+	release chan struct{}
+}
+
+func (r *countingRepo) GetPlanet(ctx context.Context, filter interface{}, planet *model.Planet) error {
+	atomic.AddInt32(&r.reads, 1)
+	if r.release != nil {
+		select {
+		case <-r.release:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return r.Stub.GetPlanet(ctx, filter, planet)
+}
+
+func (r *countingRepo) LastModified(ctx context.Context) (time.Time, error) {
+	atomic.AddInt32(&r.reads, 1)
+	return r.Stub.LastModified(ctx)
+}
+
+type observed map[string]int
+
+func (o observed) ObserveCache(method, result string) {
+	o[method+" "+result]++
+}
+
+func TestRepo_GetPlanet(t *testing.T) {
+	ctx := context.Background()
+	updatedAt := time.Date(2021, 9, 1, 10, 0, 0, 0, time.UTC)
+	hoth := &model.Planet{Name: "Hoth", Climate: "frozen", Refs: 1, UpdatedAt: &updatedAt, Films: []string{"films/2"}}
+
+	t.Run("served from memory until it expires", func(t *testing.T) {
+		backend := &countingRepo{Stub: &test.Stub{Planet: hoth}}
+		observer := observed{}
+		repo := New(backend, &Config{Size: 10, TTL: time.Minute}, observer)
+		now := time.Now()
+		repo.now = func() time.Time { return now }
+
+		for i := 0; i < 3; i++ {
+			var planet model.Planet
+			assert.NoError(t, repo.GetPlanet(ctx, bson.M{"name": "Hoth"}, &planet))
+			assert.Equal(t, "frozen", planet.Climate)
+			assert.Equal(t, updatedAt, *planet.UpdatedAt)
+		}
+		assert.Equal(t, int32(1), backend.reads)
+		assert.Equal(t, observed{"GetPlanet miss": 1, "GetPlanet hit": 2}, observer)
+
+		now = now.Add(time.Minute)
+		var planet model.Planet
+		assert.NoError(t, repo.GetPlanet(ctx, bson.M{"name": "Hoth"}, &planet))
+		assert.Equal(t, int32(2), backend.reads)
+	})
+
+	t.Run("callers cannot change the cached planet", func(t *testing.T) {
+		repo := New(&countingRepo{Stub: &test.Stub{Planet: hoth}}, &Config{Size: 10, TTL: time.Minute}, nil)
+
+		var planet model.Planet
+		assert.NoError(t, repo.GetPlanet(ctx, bson.M{"name": "Hoth"}, &planet))
+		planet.Climate = "tropical"
+		*planet.UpdatedAt = time.Time{}
+		planet.Films[0] = "films/1"
+
+		var again model.Planet
+		assert.NoError(t, repo.GetPlanet(ctx, bson.M{"name": "Hoth"}, &again))
+		assert.Equal(t, "frozen", again.Climate)
+		assert.Equal(t, updatedAt, *again.UpdatedAt)
+		assert.Equal(t, []string{"films/2"}, again.Films)
+	})
+
+	t.Run("planets not found are cached, failures are not", func(t *testing.T) {
+		backend := &countingRepo{Stub: &test.Stub{Error: mongo.ErrNoDocuments}}
+		repo := New(backend, &Config{Size: 10, TTL: time.Minute}, nil)
+
+		var planet model.Planet
+		assert.Equal(t, mongo.ErrNoDocuments, repo.GetPlanet(ctx, bson.M{"_id": "1234"}, &planet))
+		assert.Equal(t, mongo.ErrNoDocuments, repo.GetPlanet(ctx, bson.M{"_id": "1234"}, &planet))
+		assert.Equal(t, int32(1), backend.reads)
+
+		backend.Error = errors.New("connection reset")
+		assert.Error(t, repo.GetPlanet(ctx, bson.M{"name": "Naboo"}, &planet))
+		assert.Error(t, repo.GetPlanet(ctx, bson.M{"name": "Naboo"}, &planet))
+		assert.Equal(t, int32(3), backend.reads)
+	})
+
+	t.Run("other filters are not cached", func(t *testing.T) {
+		backend := &countingRepo{Stub: &test.Stub{Planet: hoth}}
+		repo := New(backend, &Config{Size: 10, TTL: time.Minute}, nil)
+
+		var planet model.Planet
+		filter := bson.M{"name": "Hoth", "weather": "frozen"}
+		assert.NoError(t, repo.GetPlanet(ctx, filter, &planet))
+		assert.NoError(t, repo.GetPlanet(ctx, filter, &planet))
+		assert.Equal(t, int32(2), backend.reads)
+		assert.Equal(t, 0, repo.Len())
+	})
+
+	t.Run("least recently used are evicted", func(t *testing.T) {
+		backend := &countingRepo{Stub: &test.Stub{Planet: hoth}}
+		repo := New(backend, &Config{Size: 2, TTL: time.Minute}, nil)
+
+		var planet model.Planet
+		for _, name := range []string{"Hoth", "Naboo", "Hoth", "Endor", "Hoth", "Naboo"} {
+			assert.NoError(t, repo.GetPlanet(ctx, bson.M{"name": name}, &planet))
+		}
+		// Naboo is evicted by Endor, Hoth stays as it was read again
+		assert.Equal(t, int32(4), backend.reads)
+		assert.Equal(t, 2, repo.Len())
+	})
+}
+
+func TestRepo_Invalidate(t *testing.T) {
+	ctx := context.Background()
+	stub := &test.Stub{Planet: &model.Planet{Name: "Hoth"}, Modified: time.Date(2021, 9, 1, 10, 0, 0, 0, time.UTC)}
+	backend := &countingRepo{Stub: stub}
+	repo := New(backend, &Config{Size: 10, TTL: time.Minute}, nil)
+
+	writes := map[string]func(){
+		"insert": func() { repo.InsertPlanets(ctx, nil) },
+		"update": func() { repo.UpdatePlanets(ctx, nil) },
+		"upsert": func() { repo.UpsertPlanets(ctx, nil) },
+		"sync":   func() { repo.UpdateMovieRefs(ctx, nil, model.UpstreamWins) },
+		"delete": func() { repo.DeletePlanets(ctx, nil) },
+	}
+	for name, write := range writes {
+		t.Run(name, func(t *testing.T) {
+			var planet model.Planet
+			assert.NoError(t, repo.GetPlanet(ctx, bson.M{"name": "Hoth"}, &planet))
+			_, err := repo.LastModified(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, 2, repo.Len())
+
+			write()
+			assert.Equal(t, 0, repo.Len())
+		})
+	}
+}
+
+func TestRepo_SharedMisses(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("concurrent misses load once", func(t *testing.T) {
+		backend := &countingRepo{Stub: &test.Stub{Planet: &model.Planet{Name: "Hoth"}}, release: make(chan struct{})}
+		repo := New(backend, &Config{Size: 10, TTL: time.Minute}, nil)
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 5)
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				var planet model.Planet
+				errs <- repo.GetPlanet(ctx, bson.M{"name": "Hoth"}, &planet)
+			}()
+		}
+		// let every reader reach the shared load before it returns
+		for atomic.LoadInt32(&backend.reads) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		time.Sleep(20 * time.Millisecond)
+		close(backend.release)
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, int32(1), backend.reads)
+	})
+
+	t.Run("a load that overlaps a write is not kept", func(t *testing.T) {
+		backend := &countingRepo{Stub: &test.Stub{Planet: &model.Planet{Name: "Hoth"}}, release: make(chan struct{})}
+		repo := New(backend, &Config{Size: 10, TTL: time.Minute}, nil)
+
+		done := make(chan error)
+		go func() {
+			var planet model.Planet
+			done <- repo.GetPlanet(ctx, bson.M{"name": "Hoth"}, &planet)
+		}()
+		for atomic.LoadInt32(&backend.reads) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		repo.UpdatePlanets(ctx, nil)
+		close(backend.release)
+
+		assert.NoError(t, <-done)
+		assert.Equal(t, 0, repo.Len())
+	})
+
+	t.Run("a cancelled load is retried by the callers still waiting", func(t *testing.T) {
+		backend := &countingRepo{Stub: &test.Stub{Planet: &model.Planet{Name: "Hoth"}}, release: make(chan struct{})}
+		repo := New(backend, &Config{Size: 10, TTL: time.Minute}, nil)
+
+		leaderCtx, cancel := context.WithCancel(ctx)
+		leader := make(chan error)
+		go func() {
+			var planet model.Planet
+			leader <- repo.GetPlanet(leaderCtx, bson.M{"name": "Hoth"}, &planet)
+		}()
+		for atomic.LoadInt32(&backend.reads) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		follower := make(chan error)
+		go func() {
+			var planet model.Planet
+			follower <- repo.GetPlanet(ctx, bson.M{"name": "Hoth"}, &planet)
+		}()
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+		assert.Equal(t, context.Canceled, <-leader)
+
+		close(backend.release)
+		assert.NoError(t, <-follower)
+	})
+}
@@ -0,0 +1,351 @@
+// Package cli runs the sw-api command line: settings flags first, then a command with its own arguments
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/gugabfigueiredo/star-wars-api/app"
+	"github.com/gugabfigueiredo/star-wars-api/env"
+	"github.com/gugabfigueiredo/star-wars-api/model"
+	"github.com/gugabfigueiredo/star-wars-api/service"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+const usage = `usage: sw-api [settings flags] [command]
+
+commands:
+  serve                        serve the api, the default command
+  sync                         run one swapi sync, print its report and exit
+  import [-format f] <file>    import the planets of a json, ndjson or csv file, - reads stdin.
+                               -upsert updates the planets that exist already
+  export [-format f] <file>    write all planets to a json, ndjson or csv file, - writes stdout
+  migrate                      create the database indexes
+  planets get <name>           print a planet
+  planets list [-format f]     print all planets, as json unless another format is given
+  planets delete <name>...     delete planets by name
+  config print                 print the effective settings, secrets masked
+
+The format of a file is taken from its extension, .json, .ndjson, .jsonl or .csv, unless -format is given.
+Run sw-api -h to list the settings flags.
+`
+
+// Exit codes of Run
+const (
+	ExitOK      = 0
+	ExitFailure = 1
+	ExitUsage   = 2
+)
+
+type CLI struct {
+	// Deps are passed to the app of every command, see app.Deps
+	Deps app.Deps
+
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// errUsage is returned by commands called with the wrong arguments, after their usage is printed
+var errUsage = errors.New("usage")
+
+// Run runs the command in args until it is done or ctx is, and returns the process exit code
+func (c *CLI) Run(ctx context.Context, args []string) int {
+
+	settings, args, err := env.Load(args)
+	if err == flag.ErrHelp {
+		fmt.Fprint(c.Stderr, "\n"+usage)
+		return ExitOK
+	}
+	if len(args) == 2 && args[0] == "config" && args[1] == "print" {
+		fmt.Fprint(c.Stdout, settings.YAML())
+	}
+	if err != nil {
+		fmt.Fprintf(c.Stderr, "invalid configuration:\n%s\n", err)
+		return ExitFailure
+	}
+
+	command := "serve"
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	run := map[string]func(context.Context, *app.App, []string) error{
+		"sync":    c.sync,
+		"import":  c.importPlanets,
+		"export":  c.exportPlanets,
+		"migrate": c.migrate,
+		"planets": c.planets,
+	}[command]
+
+	switch {
+	case command == "serve" && len(args) == 0:
+		return c.serve(ctx, settings)
+	case command == "config" && len(args) == 1 && args[0] == "print":
+		return ExitOK
+	case run == nil:
+		fmt.Fprintf(c.Stderr, "unknown command %q\n\n%s", strings.Join(append([]string{command}, args...), " "), usage)
+		return ExitUsage
+	}
+
+	a, err := app.New(ctx, settings, c.Deps)
+	if err != nil {
+		fmt.Fprintln(c.Stderr, err)
+		return ExitFailure
+	}
+	defer func() {
+		shutdown, cancel := context.WithTimeout(context.Background(), settings.Server.ShutdownTimeout)
+		defer cancel()
+		a.Shutdown(shutdown)
+	}()
+
+	switch err := run(ctx, a, args); {
+	case err == errUsage:
+		return ExitUsage
+	case err != nil:
+		fmt.Fprintf(c.Stderr, "%s failed: %s\n", command, err)
+		return ExitFailure
+	}
+	return ExitOK
+}
+
+// serve runs the api until an interrupt or SIGTERM, and reloads the settings on SIGHUP
+func (c *CLI) serve(ctx context.Context, settings *env.Settings) int {
+
+	server, err := app.New(ctx, settings, c.Deps)
+	if err != nil {
+		fmt.Fprintln(c.Stderr, err)
+		return ExitFailure
+	}
+	Logger := server.Logger
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := server.Start(ctx); err != nil {
+		Logger.E("failed to listen", "err", err, "port", settings.Server.Port)
+		return ExitFailure
+	}
+
+	// apply live settings on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var exitErr error
+	for running := true; running; {
+		select {
+		case <-hup:
+			server.Reload()
+		case <-ctx.Done():
+			Logger.I("Shutting down server...", "timeout", settings.Server.ShutdownTimeout)
+			running = false
+		case exitErr = <-server.Err():
+			Logger.E("listen and serve died", "err", exitErr)
+			running = false
+		}
+	}
+
+	shutdown, cancel := context.WithTimeout(context.Background(), settings.Server.ShutdownTimeout)
+	defer cancel()
+	server.Shutdown(shutdown)
+
+	if exitErr != nil {
+		Logger.E("server stopped after failure", "err", exitErr)
+		return ExitFailure
+	}
+	return ExitOK
+}
+
+// sync runs a single swapi sync, e.g. from a cron job. It does not take the sync lease.
+func (c *CLI) sync(ctx context.Context, a *app.App, args []string) error {
+	if len(args) > 0 {
+		return c.usage("sync")
+	}
+	report, err := a.API.UpdatePlanetRefs(ctx)
+	if err != nil {
+		return err
+	}
+	return c.printJSON(report)
+}
+
+// importPlanets streams a file into the database in batches like the api import, and prints the import report.
+// Rows that cannot be decoded or written are listed in the report, the others are imported.
+func (c *CLI) importPlanets(ctx context.Context, a *app.App, args []string) error {
+	flags, format := c.formatFlags("import")
+	upsert := flags.Bool("upsert", false, "update the planets that exist already, by name, and insert the others")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return c.usage("import [-format json|ndjson|csv] [-upsert] <file>")
+	}
+	path := flags.Arg(0)
+
+	f, err := fileFormat(*format, path)
+	if err != nil {
+		return err
+	}
+
+	in := c.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		in = file
+	}
+
+	decoder, err := model.NewPlanetDecoder(in, f)
+	if err != nil {
+		return err
+	}
+	report, err := service.ImportPlanets(ctx, a.API, decoder, service.ImportOptions{Upsert: *upsert})
+	if printErr := c.printJSON(report); err == nil {
+		err = printErr
+	}
+	return err
+}
+
+func (c *CLI) exportPlanets(ctx context.Context, a *app.App, args []string) (err error) {
+	flags, format := c.formatFlags("export")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return c.usage("export [-format json|ndjson|csv] <file>")
+	}
+	path := flags.Arg(0)
+
+	f, err := fileFormat(*format, path)
+	if err != nil {
+		return err
+	}
+	if path == "-" {
+		return c.streamPlanets(ctx, a, c.Stdout, f)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	encoder, _ := model.NewPlanetEncoder(file, f)
+	count, err := service.ExportPlanets(ctx, a.API, encoder)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.Stdout, "exported %d planets to %s\n", count, path)
+	return nil
+}
+
+// streamPlanets writes every planet to w as it is read from the database, in a format checked by the caller
+func (c *CLI) streamPlanets(ctx context.Context, a *app.App, w io.Writer, format model.Format) error {
+	encoder, err := model.NewPlanetEncoder(w, format)
+	if err != nil {
+		return err
+	}
+	_, err = service.ExportPlanets(ctx, a.API, encoder)
+	return err
+}
+
+func (c *CLI) migrate(ctx context.Context, a *app.App, args []string) error {
+	if len(args) > 0 {
+		return c.usage("migrate")
+	}
+	if err := a.Migrator.Migrate(ctx); err != nil {
+		return err
+	}
+	fmt.Fprintln(c.Stdout, "database indexes are up to date")
+	return nil
+}
+
+func (c *CLI) planets(ctx context.Context, a *app.App, args []string) error {
+	if len(args) == 0 {
+		return c.usage("planets get|list|delete")
+	}
+
+	switch command, args := args[0], args[1:]; command {
+	case "get":
+		if len(args) != 1 {
+			return c.usage("planets get <name>")
+		}
+		var planet model.Planet
+		err := a.API.GetPlanet(ctx, bson.M{"name": args[0]}, &planet)
+		if err == mongo.ErrNoDocuments {
+			return fmt.Errorf("planet %q not found", args[0])
+		}
+		if err != nil {
+			return err
+		}
+		return c.printJSON(planet)
+
+	case "list":
+		flags, format := c.formatFlags("planets list")
+		if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+			return c.usage("planets list [-format json|ndjson|csv]")
+		}
+		f := model.FormatJSON
+		if *format != "" {
+			var err error
+			if f, err = model.ParseFormat(*format); err != nil {
+				return err
+			}
+		}
+		return c.streamPlanets(ctx, a, c.Stdout, f)
+
+	case "delete":
+		if len(args) == 0 {
+			return c.usage("planets delete <name>...")
+		}
+		planets := make([]model.Planet, len(args))
+		for i, name := range args {
+			planets[i].Name = name
+		}
+		res, err := a.API.DeletePlanets(ctx, planets)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.Stdout, "deleted %d planets\n", res.DeletedCount)
+		return nil
+
+	default:
+		return c.usage("planets get|list|delete")
+	}
+}
+
+func (c *CLI) usage(command string) error {
+	fmt.Fprintf(c.Stderr, "usage: sw-api [settings flags] %s\n", command)
+	return errUsage
+}
+
+func (c *CLI) printJSON(v interface{}) error {
+	encoder := json.NewEncoder(c.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func (c *CLI) formatFlags(command string) (*flag.FlagSet, *string) {
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	flags.SetOutput(c.Stderr)
+	format := flags.String("format", "", "json, ndjson or csv, taken from the file extension when not given")
+	return flags, format
+}
+
+// fileFormat is format when given, the format of the file extension otherwise. Stdin and stdout are json by default.
+func fileFormat(format, path string) (model.Format, error) {
+	switch {
+	case format != "":
+		return model.ParseFormat(format)
+	case path == "-":
+		return model.FormatJSON, nil
+	default:
+		return model.FormatOf(path)
+	}
+}
@@ -0,0 +1,160 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/gugabfigueiredo/star-wars-api/app"
+	"github.com/gugabfigueiredo/star-wars-api/log"
+	"github.com/gugabfigueiredo/star-wars-api/model"
+	"github.com/gugabfigueiredo/star-wars-api/test"
+	"github.com/gugabfigueiredo/star-wars-api/test/fakeswapi"
+	"github.com/gugabfigueiredo/swapi"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// run runs args against stub, returning the exit code, stdout and stderr
+func run(stub *test.Stub, stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	c := &CLI{
+		Deps: app.Deps{
+			Logger:   log.New(&log.Config{Context: "sw-api-test"}),
+			Repo:     stub,
+			Leases:   &test.LeaseStub{},
+			Database: stub,
+			Migrator: stub,
+		},
+		Stdin:  strings.NewReader(stdin),
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	code := c.Run(context.Background(), append([]string{"-log.fileloggingenabled=false"}, args...))
+	return code, stdout.String(), stderr.String()
+}
+
+func TestCLI_Import(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "sw-api-cli")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "planets.csv")
+	assert.NoError(t, ioutil.WriteFile(file, []byte("name,climate,refs\nHoth,frozen,1\nNaboo,temperate,4\n"), 0644))
+
+	stub := &test.Stub{InsertResult: mongo.InsertManyResult{InsertedIDs: []interface{}{1, 2}}}
+	code, stdout, _ := run(stub, "", "import", file)
+	assert.Equal(t, ExitOK, code)
+	var report model.ImportReport
+	assert.NoError(t, json.Unmarshal([]byte(stdout), &report))
+	assert.Equal(t, model.ImportReport{Format: model.FormatCSV, Rows: 2, Inserted: 2, Errors: []model.RowError{}}, report)
+	assert.Equal(t, []model.Planet{{Name: "Hoth", Climate: "frozen", Refs: 1}, {Name: "Naboo", Climate: "temperate", Refs: 4}},
+		stub.CalledWith["planets"])
+
+	// a bad row is reported and skipped, the others are imported
+	stub = &test.Stub{InsertResult: mongo.InsertManyResult{InsertedIDs: []interface{}{1}}}
+	code, stdout, _ = run(stub, `{"Name":"Endor"}`+"\n"+`{"Name":"Hoth","Refs":"many"}`+"\n", "import", "-format", "ndjson", "-")
+	assert.Equal(t, ExitOK, code)
+	report = model.ImportReport{}
+	assert.NoError(t, json.Unmarshal([]byte(stdout), &report))
+	assert.Equal(t, 2, report.Rows)
+	assert.EqualValues(t, 1, report.Inserted)
+	assert.Equal(t, 1, report.Failed)
+	assert.Equal(t, 2, report.Errors[0].Row)
+	assert.Equal(t, []model.Planet{{Name: "Endor"}}, stub.CalledWith["planets"])
+
+	stub = &test.Stub{UpdateResult: mongo.BulkWriteResult{MatchedCount: 1}}
+	code, stdout, _ = run(stub, `[{"Name":"Hoth","Climate":"frozen"}]`, "import", "-upsert", "-")
+	assert.Equal(t, ExitOK, code)
+	assert.Contains(t, stdout, `"upsert": true`)
+
+	code, _, stderr := run(&test.Stub{}, "", "import", path.Join(dir, "planets.xml"))
+	assert.Equal(t, ExitFailure, code)
+	assert.Contains(t, stderr, `import failed: unknown planet format "xml"`)
+}
+
+func TestCLI_Export(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "sw-api-cli")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	stub := &test.Stub{Planets: []*model.Planet{{Name: "Hoth", Refs: 1}, {Name: "Naboo", Refs: 4}}}
+	file := path.Join(dir, "planets.ndjson")
+	code, stdout, _ := run(stub, "", "export", file)
+	assert.Equal(t, ExitOK, code)
+	assert.Equal(t, "exported 2 planets to "+file+"\n", stdout)
+
+	data, err := ioutil.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(data), "\n"))
+
+	code, stdout, _ = run(stub, "", "planets", "list", "-format", "csv")
+	assert.Equal(t, ExitOK, code)
+	assert.Equal(t, "id,name,climate,terrain,refs\n,Hoth,,,1\n,Naboo,,,4\n", stdout)
+}
+
+func TestCLI_Planets(t *testing.T) {
+
+	stub := &test.Stub{Planet: &model.Planet{Name: "Hoth", Climate: "frozen"}}
+	code, stdout, _ := run(stub, "", "planets", "get", "Hoth")
+	assert.Equal(t, ExitOK, code)
+	assert.Equal(t, bson.M{"name": "Hoth"}, stub.CalledWith["filter"])
+	var planet model.Planet
+	assert.NoError(t, json.Unmarshal([]byte(stdout), &planet))
+	assert.Equal(t, "frozen", planet.Climate)
+
+	code, _, stderr := run(&test.Stub{Error: mongo.ErrNoDocuments}, "", "planets", "get", "Alderaan")
+	assert.Equal(t, ExitFailure, code)
+	assert.Contains(t, stderr, `planet "Alderaan" not found`)
+
+	stub = &test.Stub{DeleteResult: mongo.DeleteResult{DeletedCount: 2}}
+	code, stdout, _ = run(stub, "", "planets", "delete", "Hoth", "Naboo")
+	assert.Equal(t, ExitOK, code)
+	assert.Equal(t, "deleted 2 planets\n", stdout)
+	assert.Equal(t, []model.Planet{{Name: "Hoth"}, {Name: "Naboo"}}, stub.CalledWith["planets"])
+
+	code, _, stderr = run(stub, "", "planets", "rename", "Hoth")
+	assert.Equal(t, ExitUsage, code)
+	assert.Contains(t, stderr, "usage: sw-api [settings flags] planets get|list|delete")
+}
+
+func TestCLI_SyncAndMigrate(t *testing.T) {
+
+	upstream := fakeswapi.New([]swapi.Planet{{Name: "Tatooine"}, {Name: "Hoth"}})
+	defer upstream.Close()
+
+	stub := &test.Stub{}
+	code, stdout, _ := run(stub, "", "-swapi.baseurl", upstream.URL, "sync")
+	assert.Equal(t, ExitOK, code)
+	var report model.SyncReport
+	assert.NoError(t, json.Unmarshal([]byte(stdout), &report))
+	assert.Equal(t, 2, report.Planets)
+
+	stub = &test.Stub{}
+	code, stdout, _ = run(stub, "", "migrate")
+	assert.Equal(t, ExitOK, code)
+	assert.Equal(t, true, stub.CalledWith["migrate"])
+	assert.Equal(t, "database indexes are up to date\n", stdout)
+}
+
+func TestCLI_Usage(t *testing.T) {
+
+	code, _, stderr := run(&test.Stub{}, "", "launch", "deathstar")
+	assert.Equal(t, ExitUsage, code)
+	assert.Contains(t, stderr, `unknown command "launch deathstar"`)
+
+	code, stdout, _ := run(&test.Stub{}, "", "config", "print")
+	assert.Equal(t, ExitOK, code)
+	assert.Contains(t, stdout, "fileloggingenabled: false")
+
+	code, _, stderr = run(&test.Stub{}, "", "-server.port", "none", "sync")
+	assert.Equal(t, ExitFailure, code)
+	assert.Contains(t, stderr, "invalid configuration")
+}
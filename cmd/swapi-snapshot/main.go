@@ -0,0 +1,67 @@
+// Command swapi-snapshot exports the planets currently served by swapi to a snapshot file,
+// which the server can then use as its planet source with SWAPI_SWAPI_SOURCE=snapshot
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/gugabfigueiredo/star-wars-api/env"
+	"github.com/gugabfigueiredo/star-wars-api/log"
+	"github.com/gugabfigueiredo/star-wars-api/service"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+func main() {
+
+	// settings come from SWAPI_CONFIG_FILE and env vars, flags are this command's own
+	settings, _, err := env.Load(nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%s\n", err)
+		os.Exit(1)
+	}
+
+	out := flag.String("out", settings.Swapi.SnapshotFile, "snapshot file to write")
+	flag.Parse()
+
+	settings.Log.FileLoggingEnabled = false
+	Logger := log.New(settings.Log)
+
+	client := &service.SwapiService{
+		ISwapi: service.NewSwapiClient(settings.Swapi),
+		Config: settings.Swapi,
+		Logger: Logger,
+	}
+
+	if err := writeSnapshot(*out, client); err != nil {
+		Logger.F("failed to export swapi snapshot", "err", err, "file", *out)
+	}
+
+	Logger.I("exported swapi snapshot", "file", *out)
+}
+
+// writeSnapshot exports the snapshot to a temporary file next to path, which only replaces path once complete:
+// a failed export leaves the previous snapshot in place
+func writeSnapshot(path string, client service.ISwapi) error {
+	file, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	// nothing is left to remove once the file is renamed
+	defer os.Remove(file.Name())
+
+	if err := service.ExportSnapshot(context.Background(), file, client); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Chmod(0644); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
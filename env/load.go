@@ -0,0 +1,365 @@
+package env
+
+import (
+	"flag"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/gugabfigueiredo/star-wars-api/log"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	envPrefix = "SWAPI"
+	// ConfigFileEnv points to the config file when the -config flag is not given
+	ConfigFileEnv = envPrefix + "_CONFIG_FILE"
+)
+
+// Errors lists every invalid setting, so they can all be fixed at once
+type Errors []error
+
+func (e Errors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// field is a single setting, e.g. server.port
+type field struct {
+	name   string // dotted flag and config file name, e.g. database.password_file
+	env    string // e.g. SWAPI_DATABASE_PASSWORD_FILE
+	def    string
+	hasDef bool
+	secret bool
+	uri    bool // a secret connection uri, printed with only its password masked
+	reload bool
+	value  reflect.Value
+}
+
+// fields lists the settings of s in declaration order, allocating its config sections
+func (s *Settings) fields() []*field {
+	var fields []*field
+	var walk func(v reflect.Value, names, envs []string)
+	walk = func(v reflect.Value, names, envs []string) {
+		for i := 0; i < v.NumField(); i++ {
+			sf := v.Type().Field(i)
+			fv := v.Field(i)
+			if sf.PkgPath != "" {
+				continue
+			}
+
+			key := sf.Name
+			if tag := sf.Tag.Get("envconfig"); tag != "" {
+				key = tag
+			}
+			fieldNames := append(append([]string{}, names...), strings.ToLower(key))
+			fieldEnvs := append(append([]string{}, envs...), strings.ToUpper(key))
+
+			if fv.Kind() == reflect.Ptr && fv.Type().Elem().Kind() == reflect.Struct {
+				if fv.IsNil() {
+					fv.Set(reflect.New(fv.Type().Elem()))
+				}
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct && fv.Type() != reflect.TypeOf(time.Time{}) {
+				walk(fv, fieldNames, fieldEnvs)
+				continue
+			}
+
+			def, hasDef := sf.Tag.Lookup("default")
+			fields = append(fields, &field{
+				name:   strings.Join(fieldNames, "."),
+				env:    strings.Join(fieldEnvs, "_"),
+				def:    def,
+				hasDef: hasDef,
+				secret: sf.Tag.Get("secret") == "true" || sf.Tag.Get("secret") == "uri",
+				uri:    sf.Tag.Get("secret") == "uri",
+				reload: sf.Tag.Get("reload") == "true",
+				value:  fv,
+			})
+		}
+	}
+	walk(reflect.ValueOf(s).Elem(), nil, []string{envPrefix})
+	return fields
+}
+
+// normalize lets config files spell keys as updateRefsTimeout, update_refs_timeout or update-refs-timeout
+func normalize(name string) string {
+	return strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(name))
+}
+
+// Load builds Settings from the default tags, the config file, env vars and flags, each layer overriding the
+// one before, then validates the result. It returns the arguments left after the flags, e.g. a subcommand.
+// Settings are returned even when some values are invalid, all of them are reported in the returned Errors.
+func Load(args []string) (*Settings, []string, error) {
+	return load(args, os.LookupEnv)
+}
+
+func load(args []string, lookupEnv func(string) (string, bool)) (*Settings, []string, error) {
+	s := &Settings{}
+	fields := s.fields()
+
+	var errs Errors
+	set := func(f *field, value, source string) {
+		if err := parse(f.value, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid value %q from %s: %s", f.name, value, source, err))
+		}
+	}
+
+	// flags are parsed first to find the config file, and applied last
+	flags := flag.NewFlagSet("sw-api", flag.ContinueOnError)
+	configFile := flags.String("config", "", "yaml or toml config file, also read from "+ConfigFileEnv)
+	flagValues := map[*field]string{}
+	for _, f := range fields {
+		usage := "env " + f.env
+		if f.hasDef {
+			usage += fmt.Sprintf(" (default %q)", f.def)
+		}
+		flags.Var(&fieldFlag{field: f, values: flagValues}, f.name, usage)
+	}
+	if err := flags.Parse(args); err != nil {
+		return s, nil, err
+	}
+	s.args = args[:len(args)-flags.NArg()]
+
+	for _, f := range fields {
+		if f.hasDef {
+			set(f, f.def, "default")
+		}
+	}
+
+	if *configFile == "" {
+		*configFile, _ = lookupEnv(ConfigFileEnv)
+	}
+	s.file = *configFile
+	if *configFile != "" {
+		values, err := readFile(*configFile)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		known := map[string]bool{}
+		for _, f := range fields {
+			key := normalize(f.name)
+			known[key] = true
+			if value, ok := values[key]; ok {
+				set(f, value, *configFile)
+			}
+		}
+		for key := range values {
+			if !known[key] {
+				errs = append(errs, fmt.Errorf("%s: unknown setting %s", *configFile, key))
+			}
+		}
+	}
+
+	for _, f := range fields {
+		if value, ok := lookupEnv(f.env); ok {
+			set(f, value, f.env)
+		}
+	}
+
+	for _, f := range fields {
+		if value, ok := flagValues[f]; ok {
+			set(f, value, "flag -"+f.name)
+		}
+	}
+
+	errs = append(errs, s.validate()...)
+	if len(errs) > 0 {
+		return s, flags.Args(), errs
+	}
+	return s, flags.Args(), nil
+}
+
+// fieldFlag records a flag value, to be parsed once the layers before it are applied
+type fieldFlag struct {
+	field  *field
+	values map[*field]string
+}
+
+func (f *fieldFlag) String() string {
+	if f == nil || f.values == nil {
+		return ""
+	}
+	return f.values[f.field]
+}
+
+func (f *fieldFlag) Set(value string) error {
+	f.values[f.field] = value
+	return nil
+}
+
+// IsBoolFlag lets boolean settings be turned on with a bare -server.synconstart
+func (f *fieldFlag) IsBoolFlag() bool {
+	return f.field.value.Kind() == reflect.Bool
+}
+
+// readFile flattens a yaml or toml config file into normalized dotted keys
+func readFile(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %s", err)
+	}
+
+	var tree map[string]interface{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &tree)
+	case ".toml":
+		err = toml.Unmarshal(data, &tree)
+	default:
+		return nil, fmt.Errorf("config file %s: unknown format %q, use .yaml, .yml or .toml", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %s", path, err)
+	}
+
+	values := map[string]string{}
+	var flatten func(prefix string, node interface{})
+	flatten = func(prefix string, node interface{}) {
+		switch v := node.(type) {
+		case map[string]interface{}:
+			for key, child := range v {
+				flatten(prefix+normalize(key)+".", child)
+			}
+		case map[interface{}]interface{}:
+			for key, child := range v {
+				flatten(prefix+normalize(fmt.Sprint(key))+".", child)
+			}
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			values[strings.TrimSuffix(prefix, ".")] = strings.Join(items, ",")
+		default:
+			values[strings.TrimSuffix(prefix, ".")] = fmt.Sprint(v)
+		}
+	}
+	flatten("", tree)
+	return values, nil
+}
+
+// parse sets v from its text form, the same for defaults, files, env vars and flags
+func parse(v reflect.Value, value string) error {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", v.Type())
+		}
+		items := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// format is the text form of a setting, as parse reads it back
+func format(f *field) string {
+	switch v := f.value.Interface().(type) {
+	case []string:
+		return strings.Join(v, ",")
+	case time.Duration:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// printable is the value of a setting in a printed config file, with secrets masked
+func printable(f *field) interface{} {
+	if f.secret {
+		return mask(f, format(f))
+	}
+	switch f.value.Interface().(type) {
+	case bool, int, int64, uint32, float64:
+		return f.value.Interface()
+	}
+	return format(f)
+}
+
+// mask hides the secret value of f, keeping the parts of a connection uri that are safe to show.
+// Other secrets are hidden whole, a password can parse as a uri too.
+func mask(f *field, value string) string {
+	if value == "" {
+		return value
+	}
+	if f.uri {
+		if uri, err := url.Parse(value); err == nil && uri.Scheme != "" {
+			return uri.Redacted()
+		}
+	}
+	return log.Redacted
+}
+
+// YAML prints the settings as a config file Load can read, secrets masked
+func (s *Settings) YAML() string {
+	sections := yaml.MapSlice{}
+	index := map[string]int{}
+	for _, f := range s.fields() {
+		path := strings.SplitN(f.name, ".", 2)
+		if len(path) == 1 {
+			sections = append(sections, yaml.MapItem{Key: f.name, Value: printable(f)})
+			continue
+		}
+		i, ok := index[path[0]]
+		if !ok {
+			i = len(sections)
+			index[path[0]] = i
+			sections = append(sections, yaml.MapItem{Key: path[0], Value: yaml.MapSlice{}})
+		}
+		sections[i].Value = append(sections[i].Value.(yaml.MapSlice), yaml.MapItem{Key: path[1], Value: printable(f)})
+	}
+	data, _ := yaml.Marshal(sections)
+	return string(data)
+}
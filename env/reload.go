@@ -0,0 +1,82 @@
+package env
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// Change is a setting whose value differs in a reloaded configuration
+type Change struct {
+	Name string
+	From string
+	To   string
+	// Live changes are applied to the settings, the others need a restart and are left as they were
+	Live bool
+}
+
+// Reload loads the configuration again from the flags s was loaded with, the env and the config file,
+// and compares it with s. Settings tagged reload:"true" are updated in place, other changes are only reported.
+// A configuration that does not load or validate changes nothing.
+func (s *Settings) Reload() ([]Change, error) {
+	loaded, _, err := load(s.args, os.LookupEnv)
+	if err != nil {
+		return nil, err
+	}
+	// the running settings were resolved, so are the loaded ones before they are compared
+	if err := loaded.Resolve(); err != nil {
+		return nil, err
+	}
+	return s.apply(loaded), nil
+}
+
+func (s *Settings) apply(loaded *Settings) []Change {
+	var changes []Change
+	current, next := s.fields(), loaded.fields()
+	for i, f := range current {
+		from, to := format(f), format(next[i])
+		if from == to {
+			continue
+		}
+		change := Change{Name: f.name, From: from, To: to, Live: f.reload}
+		if f.secret {
+			change.From, change.To = mask(f, from), mask(f, to)
+		}
+		if f.reload {
+			f.value.Set(next[i].value)
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// WatchFile signals on the returned channel whenever the file at path is modified, checking every interval
+// until ctx is done
+func WatchFile(ctx context.Context, path string, interval time.Duration) <-chan struct{} {
+	changed := make(chan struct{}, 1)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		last, _ := os.Stat(path)
+		for {
+			select {
+			case <-ticker.C:
+				info, err := os.Stat(path)
+				if err != nil {
+					continue
+				}
+				if last == nil || !info.ModTime().Equal(last.ModTime()) || info.Size() != last.Size() {
+					select {
+					case changed <- struct{}{}:
+					default:
+					}
+				}
+				last = info
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return changed
+}
@@ -2,27 +2,85 @@ package env
 
 import (
 	"encoding/json"
+	"fmt"
+	"github.com/gugabfigueiredo/star-wars-api/cache"
+	"github.com/gugabfigueiredo/star-wars-api/graph"
+	"github.com/gugabfigueiredo/star-wars-api/handler"
 	"github.com/gugabfigueiredo/star-wars-api/log"
 	"github.com/gugabfigueiredo/star-wars-api/repository"
+	"github.com/gugabfigueiredo/star-wars-api/service"
+	"github.com/gugabfigueiredo/star-wars-api/tracing"
+	"os"
 	"time"
 )
 
-type settings struct {
+// Settings configure the whole server, see Load
+type Settings struct {
 	Log *log.Config
 
 	Server struct {
+		// Port to listen on, 0 picks a free one
 		Port              string        `default:"8080"`
 		Context           string        `default:"sw-api"`
-		UpdateRefsTimeout time.Duration `default:"4h"`
+		// UpdateRefsTimeout is the interval of the scheduled sync, reloaded live
+		UpdateRefsTimeout time.Duration `default:"4h" reload:"true"`
+		SyncOnStart       bool          `default:"false"`
+		ShutdownTimeout   time.Duration `default:"30s"`
+		// RequestTimeout bounds the database calls of planet routes
+		RequestTimeout time.Duration `default:"5s"`
+		// SyncRequestTimeout bounds a sync triggered by request, kept under the server write timeout
+		SyncRequestTimeout time.Duration `default:"9s"`
+		// BulkRequestTimeout bounds imports, exports and the planet listing, which stream for longer than the
+		// server read and write timeouts allow other requests
+		BulkRequestTimeout time.Duration `default:"10m"`
+		// ConfigPollInterval reloads the config file when it changes, checked this often. 0 reloads on SIGHUP only.
+		ConfigPollInterval time.Duration `default:"0"`
 	}
 
 	Database *repository.Config
+
+	Lease *service.LeaseConfig
+
+	Swapi *service.SwapiConfig
+
+	Health *service.HealthConfig
+
+	Tracing *tracing.Config
+
+	HTTP *handler.HTTPConfig
+
+	Cache *cache.Config
+
+	GraphQL *graph.Config
+
+	Admin *handler.AdminConfig
+
+	// file is the config file the settings were loaded from, if any, and args the flags they were loaded with
+	file string
+	args []string
 }
 
+// Resolve completes the settings the way the server runs with them: secrets are read from their *_FILE
+// settings and lease.holder defaults to the host name
+func (s *Settings) Resolve() error {
+	if err := s.Database.LoadSecrets(); err != nil {
+		return fmt.Errorf("failed to read database secrets: %s", err)
+	}
+	if err := s.Admin.LoadSecrets(); err != nil {
+		return fmt.Errorf("failed to read admin token: %s", err)
+	}
+	if s.Lease.Holder == "" {
+		s.Lease.Holder, _ = os.Hostname()
+	}
+	return nil
+}
 
-var Settings settings
+// File is the config file the settings were loaded from, empty if there was none
+func (s *Settings) File() string {
+	return s.file
+}
 
-func (s settings) String() string {
+func (s Settings) String() string {
 	bytes, _ := json.Marshal(s)
 	return string(bytes)
 }
\ No newline at end of file
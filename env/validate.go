@@ -0,0 +1,89 @@
+package env
+
+import (
+	"fmt"
+	"github.com/gugabfigueiredo/star-wars-api/handler"
+	"github.com/gugabfigueiredo/star-wars-api/log"
+	"github.com/gugabfigueiredo/star-wars-api/model"
+	"github.com/gugabfigueiredo/star-wars-api/service"
+	"github.com/gugabfigueiredo/star-wars-api/tracing"
+	"strconv"
+	"time"
+)
+
+// validate checks values that parse but cannot work, every problem is reported
+func (s *Settings) validate() Errors {
+	var errs Errors
+	check := func(ok bool, name, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %s", name, fmt.Sprintf(format, args...)))
+		}
+	}
+	positive := func(name string, d time.Duration) {
+		check(d > 0, name, "must be positive, got %s", d)
+	}
+
+	port, err := strconv.Atoi(s.Server.Port)
+	check(err == nil && port >= 0 && port <= 65535, "server.port", "%q is not a port between 0 and 65535", s.Server.Port)
+	positive("server.updaterefstimeout", s.Server.UpdateRefsTimeout)
+	positive("server.shutdowntimeout", s.Server.ShutdownTimeout)
+	positive("server.requesttimeout", s.Server.RequestTimeout)
+	positive("server.syncrequesttimeout", s.Server.SyncRequestTimeout)
+	positive("server.bulkrequesttimeout", s.Server.BulkRequestTimeout)
+
+	if err := log.CheckLevel(s.Log.Level); err != nil {
+		check(false, "log.level", "%s", err)
+	}
+	if s.Log.FileLoggingEnabled {
+		if err := s.Log.CheckFile(); err != nil {
+			check(false, "log.directory", "cannot write log file: %s", err)
+		}
+	}
+	check(s.Log.MaxValueSize >= 0, "log.maxvaluesize", "must not be negative, got %d", s.Log.MaxValueSize)
+	check(s.Log.SampleBurst == 0 || s.Log.SamplePeriod > 0, "log.sampleperiod", "must be positive when sampling, got %s", s.Log.SamplePeriod)
+
+	positive("database.connecttimeout", s.Database.ConnectTimeout)
+
+	positive("lease.ttl", s.Lease.TTL)
+	positive("lease.renewinterval", s.Lease.RenewInterval)
+	check(s.Lease.RenewInterval < s.Lease.TTL, "lease.renewinterval", "%s must be shorter than lease.ttl %s", s.Lease.RenewInterval, s.Lease.TTL)
+
+	check(s.Swapi.Source == service.SourceLive || s.Swapi.Source == service.SourceSnapshot,
+		"swapi.source", "must be %s or %s, got %q", service.SourceLive, service.SourceSnapshot, s.Swapi.Source)
+	if _, err := model.ParseMergePolicy(s.Swapi.MergePolicy); err != nil {
+		check(false, "swapi.mergepolicy", "%s", err)
+	}
+	positive("swapi.timeout", s.Swapi.Timeout)
+	check(s.Swapi.MaxRetries >= 0, "swapi.maxretries", "must not be negative, got %d", s.Swapi.MaxRetries)
+	positive("swapi.backoffbase", s.Swapi.BackoffBase)
+	check(s.Swapi.BackoffMax >= s.Swapi.BackoffBase, "swapi.backoffmax", "%s must not be shorter than swapi.backoffbase %s", s.Swapi.BackoffMax, s.Swapi.BackoffBase)
+	check(s.Swapi.FailureThreshold > 0, "swapi.failurethreshold", "must be positive, got %d", s.Swapi.FailureThreshold)
+	positive("swapi.opentimeout", s.Swapi.OpenTimeout)
+
+	positive("health.pingtimeout", s.Health.PingTimeout)
+	positive("health.syncmaxage", s.Health.SyncMaxAge)
+
+	switch s.Tracing.Exporter {
+	case tracing.ExporterNone, tracing.ExporterOTLP, tracing.ExporterStdout:
+	default:
+		check(false, "tracing.exporter", "must be %s, %s or %s, got %q", tracing.ExporterNone, tracing.ExporterOTLP, tracing.ExporterStdout, s.Tracing.Exporter)
+	}
+	if err := handler.CheckEncodings(s.HTTP.Encodings); err != nil {
+		check(false, "http.encodings", "%s", err)
+	}
+	check(s.HTTP.MaxBodySize >= 0, "http.maxbodysize", "must not be negative, got %d", s.HTTP.MaxBodySize)
+	if _, err := handler.ParseProxies(s.HTTP.TrustedProxies); err != nil {
+		check(false, "http.trustedproxies", "%s", err)
+	}
+	check(s.HTTP.CompressMinSize >= 0, "http.compressminsize", "must not be negative, got %d", s.HTTP.CompressMinSize)
+
+	check(s.Cache.Size >= 0, "cache.size", "must not be negative, got %d", s.Cache.Size)
+	positive("cache.ttl", s.Cache.TTL)
+
+	check(s.GraphQL.MaxDepth > 0, "graphql.maxdepth", "must be positive, got %d", s.GraphQL.MaxDepth)
+	check(s.GraphQL.MaxPageSize > 0, "graphql.maxpagesize", "must be positive, got %d", s.GraphQL.MaxPageSize)
+
+	check(s.Tracing.SampleRatio >= 0 && s.Tracing.SampleRatio <= 1, "tracing.sampleratio", "must be between 0 and 1, got %v", s.Tracing.SampleRatio)
+
+	return errs
+}
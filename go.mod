@@ -3,19 +3,31 @@ module github.com/gugabfigueiredo/star-wars-api
 go 1.16
 
 require (
-	github.com/BurntSushi/toml v0.4.1 // indirect
+	github.com/BurntSushi/toml v0.4.1
+	github.com/andybalholm/brotli v1.0.3
+	github.com/fxamacker/cbor/v2 v2.3.0
 	github.com/go-chi/chi v1.5.4
 	github.com/go-stack/stack v1.8.1 // indirect
 	github.com/golang/snappy v0.0.4 // indirect
+	github.com/graph-gophers/graphql-go v1.2.0
 	github.com/gugabfigueiredo/swapi v0.0.0-20210830100932-75288fa49b4c
-	github.com/kelseyhightower/envconfig v1.4.0
-	github.com/klauspost/compress v1.13.5 // indirect
+	github.com/klauspost/compress v1.13.5
+	github.com/prometheus/client_golang v1.11.0
 	github.com/rs/zerolog v1.24.0
 	github.com/stretchr/testify v1.7.0
+	github.com/vmihailenco/msgpack/v5 v5.3.4
 	github.com/youmark/pkcs8 v0.0.0-20201027041543-1326539a0a0a // indirect
 	go.mongodb.org/mongo-driver v1.7.2
+	go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo v0.25.0
+	go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp v0.25.0
+	go.opentelemetry.io/otel v1.0.1
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.0.1
+	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.0.1
+	go.opentelemetry.io/otel/sdk v1.0.1
+	go.opentelemetry.io/otel/trace v1.0.1
 	golang.org/x/crypto v0.0.0-20210817164053-32db794688a5 // indirect
+	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
 	golang.org/x/text v0.3.7 // indirect
 	gopkg.in/natefinch/lumberjack.v2 v2.0.0
-	gopkg.in/yaml.v2 v2.4.0 // indirect
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,85 @@
+// Package graph serves planets, and the swapi films and people they refer to, as a GraphQL schema.
+// Queries read through the repository, mutations write through the api service, and the related entities of
+// every level of a query are loaded in one batch per request.
+package graph
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"github.com/graph-gophers/graphql-go"
+	"github.com/gugabfigueiredo/star-wars-api/service"
+	"github.com/gugabfigueiredo/swapi"
+)
+
+//go:embed schema.graphql
+var schema string
+
+// Config - Configuration of the /graphql endpoint
+type Config struct {
+	// MaxDepth is how deeply queries can nest fields, each film or person related to a planet is a level
+	MaxDepth int `default:"8"`
+	// MaxPageSize is the most planets a page of the planets query can ask for
+	MaxPageSize int `default:"100"`
+}
+
+// ErrReadOnly is returned for mutations of requests that can only read, like http GET requests
+var ErrReadOnly = errors.New("mutations are not allowed in read-only requests")
+
+// Request is a GraphQL request, in the shape of the json body clients send
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+	// ReadOnly requests fail their mutations with ErrReadOnly
+	ReadOnly bool `json:"-"`
+}
+
+// Schema runs requests against the planets of api, with films and people from related
+type Schema struct {
+	schema  *graphql.Schema
+	api     service.IService
+	related service.IRelated
+}
+
+// New parses the schema, related can be nil when there is no source of films and people
+func New(api service.IService, related service.IRelated, config *Config) (*Schema, error) {
+	if related == nil {
+		related = noRelated{}
+	}
+	parsed, err := graphql.ParseSchema(schema, &resolver{api: api, config: config},
+		graphql.UseStringDescriptions(),
+		graphql.MaxDepth(config.MaxDepth),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &Schema{schema: parsed, api: api, related: related}, nil
+}
+
+// Exec runs request with loaders of its own
+func (s *Schema) Exec(ctx context.Context, request Request) *graphql.Response {
+	ctx = withLoaders(ctx, newLoaders(s.api, s.related))
+	if request.ReadOnly {
+		ctx = context.WithValue(ctx, readOnlyKey{}, true)
+	}
+	return s.schema.Exec(ctx, request.Query, request.OperationName, request.Variables)
+}
+
+type readOnlyKey struct{}
+
+func readOnly(ctx context.Context) bool {
+	enabled, _ := ctx.Value(readOnlyKey{}).(bool)
+	return enabled
+}
+
+// noRelated finds no films and no people
+type noRelated struct{}
+
+func (noRelated) Films(context.Context, []string) (map[string]swapi.Film, error) {
+	return nil, nil
+}
+
+func (noRelated) People(context.Context, []string) (map[string]swapi.Person, error) {
+	return nil, nil
+}
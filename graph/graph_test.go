@@ -0,0 +1,249 @@
+package graph
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gugabfigueiredo/star-wars-api/model"
+	"github.com/gugabfigueiredo/star-wars-api/test"
+	"github.com/gugabfigueiredo/swapi"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"sync"
+	"testing"
+)
+
+// countingStub records the keys of every batch that reaches the repository and swapi
+type countingStub struct {
+	*test.Stub
+
+	mu      sync.Mutex
+	planets [][]string
+	films   [][]string
+	people  [][]string
+}
+
+func (s *countingStub) FindPlanets(ctx context.Context, query model.PlanetQuery) ([]*model.Planet, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if query.URLs != nil {
+		s.planets = append(s.planets, query.URLs)
+	}
+	return s.Stub.FindPlanets(ctx, query)
+}
+
+func (s *countingStub) Films(ctx context.Context, urls []string) (map[string]swapi.Film, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.films = append(s.films, urls)
+	return s.Stub.Films(ctx, urls)
+}
+
+func (s *countingStub) People(ctx context.Context, urls []string) (map[string]swapi.Person, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.people = append(s.people, urls)
+	return s.Stub.People(ctx, urls)
+}
+
+func newStub() *countingStub {
+	tatooine := &model.Planet{Name: "Tatooine", Climate: "arid", URL: "planets/1",
+		Films: []string{"films/1", "films/3"}, Residents: []string{"people/1"}}
+	alderaan := &model.Planet{Name: "Alderaan", Climate: "temperate", URL: "planets/2",
+		Films: []string{"films/1", "films/6"}}
+	hoth := &model.Planet{Name: "Hoth", Climate: "frozen", URL: "planets/4", Films: []string{"films/2"}}
+
+	return &countingStub{Stub: &test.Stub{
+		Planet:  tatooine,
+		Planets: []*model.Planet{tatooine, alderaan, hoth},
+		SwapiFilms: map[string]swapi.Film{
+			"films/1": {Title: "A New Hope", EpisodeID: 4, URL: "films/1", PlanetURLs: []string{"planets/1", "planets/2"}},
+			"films/2": {Title: "The Empire Strikes Back", EpisodeID: 5, URL: "films/2", PlanetURLs: []string{"planets/4"}},
+			"films/3": {Title: "Return of the Jedi", EpisodeID: 6, URL: "films/3", PlanetURLs: []string{"planets/1", "planets/9"}},
+		},
+		SwapiPeople: map[string]swapi.Person{
+			"people/1": {Name: "Luke Skywalker", URL: "people/1", Homeworld: "planets/1", FilmURLs: []string{"films/1", "films/2"}},
+		},
+	}}
+}
+
+func exec(t *testing.T, stub *countingStub, request Request) map[string]interface{} {
+	schema, err := New(stub, stub, &Config{MaxDepth: 8, MaxPageSize: 2})
+	assert.NoError(t, err)
+
+	res := schema.Exec(context.Background(), request)
+	data, err := json.Marshal(res)
+	assert.NoError(t, err)
+	var decoded map[string]interface{}
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	return decoded
+}
+
+func TestSchema_Planet(t *testing.T) {
+
+	stub := newStub()
+	res := exec(t, stub, Request{Query: `{
+		planet(name: "Tatooine") {
+			name
+			films { title planets { name } }
+			residents { name homeworld { name } films { episodeId } }
+		}
+	}`})
+
+	assert.Nil(t, res["errors"])
+	assert.Equal(t, map[string]interface{}{"planet": map[string]interface{}{
+		"name": "Tatooine",
+		"films": []interface{}{
+			map[string]interface{}{"title": "A New Hope", "planets": []interface{}{
+				map[string]interface{}{"name": "Tatooine"}, map[string]interface{}{"name": "Alderaan"},
+			}},
+			// planets not stored are left out
+			map[string]interface{}{"title": "Return of the Jedi", "planets": []interface{}{
+				map[string]interface{}{"name": "Tatooine"},
+			}},
+		},
+		"residents": []interface{}{
+			map[string]interface{}{"name": "Luke Skywalker", "homeworld": map[string]interface{}{"name": "Tatooine"},
+				"films": []interface{}{map[string]interface{}{"episodeId": float64(4)}, map[string]interface{}{"episodeId": float64(5)}}},
+		},
+	}}, res["data"])
+
+	// films and residents resolve concurrently, each of them costs at most one batch per kind of entity,
+	// whatever the number of films or residents
+	assert.Len(t, stub.people, 1)
+	assert.True(t, len(stub.films) <= 2)
+	assert.True(t, len(stub.planets) <= 2)
+}
+
+func TestSchema_Planets(t *testing.T) {
+
+	t.Run("related entities of a page are loaded once", func(t *testing.T) {
+		stub := newStub()
+		res := exec(t, stub, Request{Query: `{
+			planets(first: 2) { planets { name films { title planets { name } } } hasNextPage endCursor }
+		}`})
+
+		assert.Nil(t, res["errors"])
+		page := res["data"].(map[string]interface{})["planets"].(map[string]interface{})
+		assert.Equal(t, true, page["hasNextPage"])
+		assert.Equal(t, primitive.NilObjectID.Hex(), page["endCursor"])
+		assert.Len(t, page["planets"], 2)
+
+		assert.Equal(t, [][]string{{"films/1", "films/3", "films/6"}}, stub.films)
+		assert.Equal(t, [][]string{{"planets/1", "planets/2", "planets/9"}}, stub.planets)
+		assert.Empty(t, stub.people)
+	})
+
+	t.Run("filter and cursor", func(t *testing.T) {
+		stub := newStub()
+		after := "5f1b2c3d4e5f6a7b8c9d0e1f"
+		res := exec(t, stub, Request{
+			Query:     `query($after: String) { planets(filter: {names: ["Hoth"], climate: "froz"}, first: 1, after: $after) { planets { name } hasNextPage } }`,
+			Variables: map[string]interface{}{"after": after},
+		})
+
+		assert.Nil(t, res["errors"])
+		assert.Equal(t, map[string]interface{}{"planets": map[string]interface{}{
+			"planets": []interface{}{map[string]interface{}{"name": "Hoth"}}, "hasNextPage": false,
+		}}, res["data"])
+		id, _ := primitive.ObjectIDFromHex(after)
+		assert.Equal(t, model.PlanetQuery{Names: []string{"Hoth"}, Climate: "froz", After: id, Limit: 2}, stub.CalledWith["query"])
+	})
+
+	errs := []struct {
+		name          string
+		query         string
+		expectedError string
+	}{
+		{name: "page too large", query: `{ planets(first: 3) { hasNextPage } }`, expectedError: "first must be between 1 and 2, got 3"},
+		{name: "invalid cursor", query: `{ planets(first: 1, after: "x") { hasNextPage } }`, expectedError: `invalid cursor "x"`},
+		{name: "id and name", query: `{ planet(id: "5f1b2c3d4e5f6a7b8c9d0e1f", name: "Hoth") { name } }`, expectedError: "planet takes either an id or a name"},
+		{name: "invalid id", query: `{ planet(id: "1") { name } }`, expectedError: `invalid planet id "1"`},
+		{name: "too deep", query: `{ planet(name: "Hoth") { films { planets { films { planets { films { planets { films { title } } } } } } } } }`,
+			expectedError: `Field "title" has depth 9 that exceeds max depth 8`},
+	}
+	for _, tt := range errs {
+		t.Run(tt.name, func(t *testing.T) {
+			res := exec(t, newStub(), Request{Query: tt.query})
+			errors := res["errors"].([]interface{})
+			assert.Equal(t, tt.expectedError, errors[0].(map[string]interface{})["message"])
+		})
+	}
+}
+
+func TestSchema_PlanetNotFound(t *testing.T) {
+	stub := newStub()
+	stub.Error = mongo.ErrNoDocuments
+	res := exec(t, stub, Request{Query: `{ planet(id: "5f1b2c3d4e5f6a7b8c9d0e1f") { name } }`})
+
+	assert.Nil(t, res["errors"])
+	assert.Equal(t, map[string]interface{}{"planet": nil}, res["data"])
+}
+
+func TestSchema_Mutations(t *testing.T) {
+	id, _ := primitive.ObjectIDFromHex("5f1b2c3d4e5f6a7b8c9d0e1f")
+
+	tests := []struct {
+		name           string
+		query          string
+		readOnly       bool
+		err            error
+		expectedData   interface{}
+		expectedCalled map[string]interface{}
+		expectedError  string
+	}{
+		{
+			name:           "create",
+			query:          `mutation { createPlanets(planets: [{name: "Hoth", climate: "frozen", refs: 1}]) { insertedIds } }`,
+			expectedData:   map[string]interface{}{"createPlanets": map[string]interface{}{"insertedIds": []interface{}{id.Hex()}}},
+			expectedCalled: map[string]interface{}{"planets": []model.Planet{{Name: "Hoth", Climate: "frozen", Refs: 1}}},
+		},
+		{
+			name:           "update",
+			query:          `mutation { updatePlanets(planets: [{name: "Hoth", terrain: "tundra"}]) { matched modified } }`,
+			expectedData:   map[string]interface{}{"updatePlanets": map[string]interface{}{"matched": float64(1), "modified": float64(1)}},
+			expectedCalled: map[string]interface{}{"planets": []model.Planet{{Name: "Hoth", Terrain: "tundra"}}},
+		},
+		{
+			name:           "delete",
+			query:          `mutation { deletePlanets(names: ["Hoth", "Naboo"]) { deleted } }`,
+			expectedData:   map[string]interface{}{"deletePlanets": map[string]interface{}{"deleted": float64(2)}},
+			expectedCalled: map[string]interface{}{"planets": []model.Planet{{Name: "Hoth"}, {Name: "Naboo"}}},
+		},
+		{
+			name:          "read only",
+			query:         `mutation { deletePlanets(names: ["Hoth"]) { deleted } }`,
+			readOnly:      true,
+			expectedData:  nil,
+			expectedError: ErrReadOnly.Error(),
+		},
+		{
+			name:          "failure",
+			query:         `mutation { deletePlanets(names: ["Hoth"]) { deleted } }`,
+			err:           errors.New("connection reset"),
+			expectedData:  nil,
+			expectedError: "connection reset",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newStub()
+			stub.Error = tt.err
+			stub.InsertResult = mongo.InsertManyResult{InsertedIDs: []interface{}{id}}
+			stub.UpdateResult = mongo.BulkWriteResult{MatchedCount: 1, ModifiedCount: 1}
+			stub.DeleteResult = mongo.DeleteResult{DeletedCount: 2}
+
+			res := exec(t, stub, Request{Query: tt.query, ReadOnly: tt.readOnly})
+			assert.Equal(t, tt.expectedData, res["data"])
+			if tt.expectedError != "" {
+				errors := res["errors"].([]interface{})
+				assert.Equal(t, tt.expectedError, errors[0].(map[string]interface{})["message"])
+				return
+			}
+			assert.Nil(t, res["errors"])
+			assert.Equal(t, tt.expectedCalled, stub.CalledWith)
+		})
+	}
+}
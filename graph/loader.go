@@ -0,0 +1,204 @@
+package graph
+
+import (
+	"context"
+	"github.com/gugabfigueiredo/star-wars-api/model"
+	"github.com/gugabfigueiredo/star-wars-api/service"
+	"github.com/gugabfigueiredo/swapi"
+	"sort"
+	"sync"
+)
+
+// loader fetches entities by key for a single request. Keys are queued as soon as a loaded entity refers to
+// them, and the first load of a key not fetched yet fetches every queued key at once: the films of a page of
+// planets cost one fetch, not one per planet. Results are kept until the end of the request.
+type loader struct {
+	fetch func(ctx context.Context, keys []string) (map[string]interface{}, error)
+
+	mu      sync.Mutex
+	queued  map[string]bool
+	batches map[string]*batch
+}
+
+// batch is a fetch of keys, done is closed once its values are in
+type batch struct {
+	done   chan struct{}
+	values map[string]interface{}
+	err    error
+}
+
+func newLoader(fetch func(ctx context.Context, keys []string) (map[string]interface{}, error)) *loader {
+	return &loader{fetch: fetch, queued: map[string]bool{}, batches: map[string]*batch{}}
+}
+
+// queue has keys fetched with the next batch, empty keys refer to nothing
+func (l *loader) queue(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		if _, ok := l.batches[key]; !ok && key != "" {
+			l.queued[key] = true
+		}
+	}
+}
+
+// load returns the values of keys that were found, in the order of keys
+func (l *loader) load(ctx context.Context, keys []string) ([]interface{}, error) {
+	keys = nonEmpty(keys)
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	l.mu.Lock()
+	var missing bool
+	for _, key := range keys {
+		if _, ok := l.batches[key]; !ok {
+			l.queued[key] = true
+			missing = true
+		}
+	}
+	var own *batch
+	var fetched []string
+	if missing {
+		own = &batch{done: make(chan struct{})}
+		for key := range l.queued {
+			fetched = append(fetched, key)
+			l.batches[key] = own
+		}
+		l.queued = map[string]bool{}
+	}
+	batches := make([]*batch, len(keys))
+	for i, key := range keys {
+		batches[i] = l.batches[key]
+	}
+	l.mu.Unlock()
+
+	if own != nil {
+		sort.Strings(fetched)
+		own.values, own.err = l.fetch(ctx, fetched)
+		close(own.done)
+	}
+
+	values := make([]interface{}, 0, len(keys))
+	for i, key := range keys {
+		select {
+		case <-batches[i].done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if batches[i].err != nil {
+			return nil, batches[i].err
+		}
+		if value, ok := batches[i].values[key]; ok {
+			values = append(values, value)
+		}
+	}
+	return values, nil
+}
+
+func nonEmpty(keys []string) []string {
+	var found []string
+	for _, key := range keys {
+		if key != "" {
+			found = append(found, key)
+		}
+	}
+	return found
+}
+
+// loaders are the loaders of a request, planets by swapi url, films and people
+type loaders struct {
+	planets *loader
+	films   *loader
+	people  *loader
+}
+
+func newLoaders(repo service.IService, related service.IRelated) *loaders {
+	l := &loaders{}
+
+	l.planets = newLoader(func(ctx context.Context, urls []string) (map[string]interface{}, error) {
+		planets, err := repo.FindPlanets(ctx, model.PlanetQuery{URLs: urls})
+		if err != nil {
+			return nil, err
+		}
+		l.sawPlanets(planets)
+		values := make(map[string]interface{}, len(planets))
+		for _, planet := range planets {
+			values[planet.URL] = planet
+		}
+		return values, nil
+	})
+
+	l.films = newLoader(func(ctx context.Context, urls []string) (map[string]interface{}, error) {
+		films, err := related.Films(ctx, urls)
+		if err != nil {
+			return nil, err
+		}
+		values := make(map[string]interface{}, len(films))
+		for url, film := range films {
+			l.planets.queue(film.PlanetURLs...)
+			values[url] = film
+		}
+		return values, nil
+	})
+
+	l.people = newLoader(func(ctx context.Context, urls []string) (map[string]interface{}, error) {
+		people, err := related.People(ctx, urls)
+		if err != nil {
+			return nil, err
+		}
+		values := make(map[string]interface{}, len(people))
+		for url, person := range people {
+			l.planets.queue(person.Homeworld)
+			l.films.queue(person.FilmURLs...)
+			values[url] = person
+		}
+		return values, nil
+	})
+	return l
+}
+
+// sawPlanets queues the films and residents of planets about to be resolved
+func (l *loaders) sawPlanets(planets []*model.Planet) {
+	for _, planet := range planets {
+		l.films.queue(planet.Films...)
+		l.people.queue(planet.Residents...)
+	}
+}
+
+func (l *loaders) loadPlanets(ctx context.Context, urls []string) ([]*model.Planet, error) {
+	values, err := l.planets.load(ctx, urls)
+	planets := make([]*model.Planet, len(values))
+	for i, value := range values {
+		planets[i] = value.(*model.Planet)
+	}
+	return planets, err
+}
+
+func (l *loaders) loadFilms(ctx context.Context, urls []string) ([]swapi.Film, error) {
+	values, err := l.films.load(ctx, urls)
+	films := make([]swapi.Film, len(values))
+	for i, value := range values {
+		films[i] = value.(swapi.Film)
+	}
+	return films, err
+}
+
+func (l *loaders) loadPeople(ctx context.Context, urls []string) ([]swapi.Person, error) {
+	values, err := l.people.load(ctx, urls)
+	people := make([]swapi.Person, len(values))
+	for i, value := range values {
+		people[i] = value.(swapi.Person)
+	}
+	return people, err
+}
+
+type loadersKey struct{}
+
+func withLoaders(ctx context.Context, l *loaders) context.Context {
+	return context.WithValue(ctx, loadersKey{}, l)
+}
+
+func loadersFrom(ctx context.Context) *loaders {
+	return ctx.Value(loadersKey{}).(*loaders)
+}
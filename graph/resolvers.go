@@ -0,0 +1,377 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/graph-gophers/graphql-go"
+	"github.com/gugabfigueiredo/star-wars-api/model"
+	"github.com/gugabfigueiredo/star-wars-api/service"
+	"github.com/gugabfigueiredo/swapi"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// resolver is the root of queries and mutations
+type resolver struct {
+	api    service.IService
+	config *Config
+}
+
+type planetArgs struct {
+	ID   *graphql.ID
+	Name *string
+}
+
+func (r *resolver) Planet(ctx context.Context, args planetArgs) (*planetResolver, error) {
+	var filter bson.M
+	switch {
+	case args.ID != nil && args.Name == nil:
+		id, err := primitive.ObjectIDFromHex(string(*args.ID))
+		if err != nil {
+			return nil, fmt.Errorf("invalid planet id %q", *args.ID)
+		}
+		filter = bson.M{"_id": id}
+	case args.Name != nil && args.ID == nil:
+		filter = bson.M{"name": *args.Name}
+	default:
+		return nil, errors.New("planet takes either an id or a name")
+	}
+
+	var planet model.Planet
+	if err := r.api.GetPlanet(ctx, filter, &planet); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return newPlanetResolvers(ctx, []*model.Planet{&planet})[0], nil
+}
+
+type planetsArgs struct {
+	Filter *planetFilter
+	First  int32
+	After  *string
+}
+
+type planetFilter struct {
+	Names   *[]string
+	Search  *string
+	Climate *string
+	Terrain *string
+}
+
+func (r *resolver) Planets(ctx context.Context, args planetsArgs) (*planetPageResolver, error) {
+	if args.First < 1 || int(args.First) > r.config.MaxPageSize {
+		return nil, fmt.Errorf("first must be between 1 and %d, got %d", r.config.MaxPageSize, args.First)
+	}
+
+	// one more planet tells whether there is a next page
+	query := model.PlanetQuery{Limit: int(args.First) + 1}
+	if args.After != nil {
+		after, err := primitive.ObjectIDFromHex(*args.After)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cursor %q", *args.After)
+		}
+		query.After = after
+	}
+	if f := args.Filter; f != nil {
+		if f.Names != nil {
+			query.Names = *f.Names
+		}
+		query.Search = value(f.Search)
+		query.Climate = value(f.Climate)
+		query.Terrain = value(f.Terrain)
+	}
+
+	planets, err := r.api.FindPlanets(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &planetPageResolver{hasNextPage: len(planets) > int(args.First)}
+	if page.hasNextPage {
+		planets = planets[:args.First]
+	}
+	if len(planets) > 0 {
+		cursor := planets[len(planets)-1].ID.Hex()
+		page.endCursor = &cursor
+	}
+	page.planets = newPlanetResolvers(ctx, planets)
+	return page, nil
+}
+
+type planetInput struct {
+	Name    string
+	Climate *string
+	Terrain *string
+	Refs    *int32
+}
+
+// toPlanets are the planets of inputs, fields left out are empty like in the json of the planets routes
+func toPlanets(inputs []planetInput) []model.Planet {
+	planets := make([]model.Planet, len(inputs))
+	for i, input := range inputs {
+		planets[i] = model.Planet{Name: input.Name, Climate: value(input.Climate), Terrain: value(input.Terrain)}
+		if input.Refs != nil {
+			planets[i].Refs = int(*input.Refs)
+		}
+	}
+	return planets
+}
+
+func (r *resolver) CreatePlanets(ctx context.Context, args struct{ Planets []planetInput }) (*insertResultResolver, error) {
+	if readOnly(ctx) {
+		return nil, ErrReadOnly
+	}
+	res, err := r.api.InsertPlanets(ctx, toPlanets(args.Planets))
+	if err != nil {
+		return nil, err
+	}
+
+	result := &insertResultResolver{}
+	for _, id := range res.InsertedIDs {
+		if oid, ok := id.(primitive.ObjectID); ok {
+			result.ids = append(result.ids, graphql.ID(oid.Hex()))
+			continue
+		}
+		result.ids = append(result.ids, graphql.ID(fmt.Sprint(id)))
+	}
+	return result, nil
+}
+
+func (r *resolver) UpdatePlanets(ctx context.Context, args struct{ Planets []planetInput }) (*updateResultResolver, error) {
+	if readOnly(ctx) {
+		return nil, ErrReadOnly
+	}
+	res, err := r.api.UpdatePlanets(ctx, toPlanets(args.Planets))
+	if err != nil {
+		return nil, err
+	}
+	return &updateResultResolver{res: res}, nil
+}
+
+func (r *resolver) DeletePlanets(ctx context.Context, args struct{ Names []string }) (*deleteResultResolver, error) {
+	if readOnly(ctx) {
+		return nil, ErrReadOnly
+	}
+	planets := make([]model.Planet, len(args.Names))
+	for i, name := range args.Names {
+		planets[i].Name = name
+	}
+	res, err := r.api.DeletePlanets(ctx, planets)
+	if err != nil {
+		return nil, err
+	}
+	return &deleteResultResolver{res: res}, nil
+}
+
+type planetPageResolver struct {
+	planets     []*planetResolver
+	endCursor   *string
+	hasNextPage bool
+}
+
+func (p *planetPageResolver) Planets() []*planetResolver {
+	return p.planets
+}
+
+func (p *planetPageResolver) EndCursor() *string {
+	return p.endCursor
+}
+
+func (p *planetPageResolver) HasNextPage() bool {
+	return p.hasNextPage
+}
+
+type planetResolver struct {
+	planet *model.Planet
+}
+
+// newPlanetResolvers resolves planets, queueing their films and residents to be loaded together
+func newPlanetResolvers(ctx context.Context, planets []*model.Planet) []*planetResolver {
+	loadersFrom(ctx).sawPlanets(planets)
+	resolvers := make([]*planetResolver, len(planets))
+	for i, planet := range planets {
+		resolvers[i] = &planetResolver{planet: planet}
+	}
+	return resolvers
+}
+
+func (p *planetResolver) ID() graphql.ID {
+	return graphql.ID(p.planet.ID.Hex())
+}
+
+func (p *planetResolver) Name() string {
+	return p.planet.Name
+}
+
+func (p *planetResolver) Climate() string {
+	return p.planet.Climate
+}
+
+func (p *planetResolver) Terrain() string {
+	return p.planet.Terrain
+}
+
+func (p *planetResolver) Refs() int32 {
+	return int32(p.planet.Refs)
+}
+
+func (p *planetResolver) UpdatedAt() *graphql.Time {
+	if p.planet.UpdatedAt == nil {
+		return nil
+	}
+	return &graphql.Time{Time: *p.planet.UpdatedAt}
+}
+
+func (p *planetResolver) URL() *string {
+	if p.planet.URL == "" {
+		return nil
+	}
+	return &p.planet.URL
+}
+
+func (p *planetResolver) Films(ctx context.Context) ([]*filmResolver, error) {
+	films, err := loadersFrom(ctx).loadFilms(ctx, p.planet.Films)
+	return filmResolvers(films), err
+}
+
+func (p *planetResolver) Residents(ctx context.Context) ([]*personResolver, error) {
+	people, err := loadersFrom(ctx).loadPeople(ctx, p.planet.Residents)
+	return personResolvers(people), err
+}
+
+type filmResolver struct {
+	film swapi.Film
+}
+
+func filmResolvers(films []swapi.Film) []*filmResolver {
+	resolvers := make([]*filmResolver, len(films))
+	for i := range films {
+		resolvers[i] = &filmResolver{film: films[i]}
+	}
+	return resolvers
+}
+
+func (f *filmResolver) Title() string {
+	return f.film.Title
+}
+
+func (f *filmResolver) EpisodeID() int32 {
+	return int32(f.film.EpisodeID)
+}
+
+func (f *filmResolver) Director() string {
+	return f.film.Director
+}
+
+func (f *filmResolver) Producer() string {
+	return f.film.Producer
+}
+
+func (f *filmResolver) OpeningCrawl() string {
+	return f.film.OpeningCrawl
+}
+
+func (f *filmResolver) URL() string {
+	return f.film.URL
+}
+
+func (f *filmResolver) Planets(ctx context.Context) ([]*planetResolver, error) {
+	planets, err := loadersFrom(ctx).loadPlanets(ctx, f.film.PlanetURLs)
+	if err != nil {
+		return nil, err
+	}
+	return newPlanetResolvers(ctx, planets), nil
+}
+
+type personResolver struct {
+	person swapi.Person
+}
+
+func personResolvers(people []swapi.Person) []*personResolver {
+	resolvers := make([]*personResolver, len(people))
+	for i := range people {
+		resolvers[i] = &personResolver{person: people[i]}
+	}
+	return resolvers
+}
+
+func (p *personResolver) Name() string {
+	return p.person.Name
+}
+
+func (p *personResolver) BirthYear() string {
+	return p.person.BirthYear
+}
+
+func (p *personResolver) Gender() string {
+	return p.person.Gender
+}
+
+func (p *personResolver) Height() string {
+	return p.person.Height
+}
+
+func (p *personResolver) Mass() string {
+	return p.person.Mass
+}
+
+func (p *personResolver) URL() string {
+	return p.person.URL
+}
+
+func (p *personResolver) Homeworld(ctx context.Context) (*planetResolver, error) {
+	planets, err := loadersFrom(ctx).loadPlanets(ctx, []string{p.person.Homeworld})
+	if err != nil || len(planets) == 0 {
+		return nil, err
+	}
+	return newPlanetResolvers(ctx, planets)[0], nil
+}
+
+func (p *personResolver) Films(ctx context.Context) ([]*filmResolver, error) {
+	films, err := loadersFrom(ctx).loadFilms(ctx, p.person.FilmURLs)
+	return filmResolvers(films), err
+}
+
+type insertResultResolver struct {
+	ids []graphql.ID
+}
+
+func (r *insertResultResolver) InsertedIDs() []graphql.ID {
+	return r.ids
+}
+
+type updateResultResolver struct {
+	res *mongo.BulkWriteResult
+}
+
+func (r *updateResultResolver) Matched() int32 {
+	return int32(r.res.MatchedCount)
+}
+
+func (r *updateResultResolver) Modified() int32 {
+	return int32(r.res.ModifiedCount)
+}
+
+func (r *updateResultResolver) Upserted() int32 {
+	return int32(r.res.UpsertedCount)
+}
+
+type deleteResultResolver struct {
+	res *mongo.DeleteResult
+}
+
+func (r *deleteResultResolver) Deleted() int32 {
+	return int32(r.res.DeletedCount)
+}
+
+// value is the string v points to, empty when v is nil
+func value(v *string) string {
+	if v == nil {
+		return ""
+	}
+	return *v
+}
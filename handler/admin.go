@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"github.com/gugabfigueiredo/star-wars-api/log"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// AdminConfig - Configuration of the admin routes that change the running server
+type AdminConfig struct {
+	// Token authorizes admin changes, sent as "Authorization: Bearer <token>". Changes are refused while it is empty.
+	Token string `secret:"true"`
+	// TokenFile is read in place of Token, for secrets mounted as files by docker or kubernetes
+	TokenFile string `envconfig:"TOKEN_FILE"`
+}
+
+// a config logged as a field never shows its token, see log.RedactType
+func init() {
+	log.RedactType(AdminConfig{})
+}
+
+// LoadSecrets reads Token from TokenFile when it is set
+func (c *AdminConfig) LoadSecrets() error {
+	if c.TokenFile == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(c.TokenFile)
+	if err != nil {
+		return fmt.Errorf("error reading secret file: %s", err)
+	}
+	c.Token = strings.TrimRight(string(data), "\r\n")
+	return nil
+}
+
+// MarshalJSON masks the token, so printed settings never show it
+func (c AdminConfig) MarshalJSON() ([]byte, error) {
+	type config AdminConfig
+	masked := config(c)
+	if masked.Token != "" {
+		masked.Token = log.Redacted
+	}
+	return json.Marshal(masked)
+}
+
+// AdminOnly lets through requests with the bearer token of config, admin routes are off while it has none
+func AdminOnly(config *AdminConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if config.Token == "" {
+				http.Error(w, "admin changes are disabled, set an admin token to enable them", http.StatusForbidden)
+				return
+			}
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(config.Token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+				http.Error(w, "invalid admin token", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gugabfigueiredo/star-wars-api/model"
+	"github.com/gugabfigueiredo/star-wars-api/service"
+	"mime"
+	"net/http"
+)
+
+// formatContentTypes are the content types of exported planets. Imports without a format parameter
+// are read in the format of their Content-Type.
+var formatContentTypes = map[model.Format]string{
+	model.FormatJSON:   "application/json",
+	model.FormatNDJSON: "application/x-ndjson",
+	model.FormatCSV:    "text/csv",
+}
+
+// importFormat is the format query parameter, or the format of the request Content-Type
+func importFormat(r *http.Request) (model.Format, error) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return model.ParseFormat(format)
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/ndjson" {
+		return model.FormatNDJSON, nil
+	}
+	for format, contentType := range formatContentTypes {
+		if mediaType == contentType {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("unknown planet format, set format to json, ndjson or csv, or send a matching Content-Type")
+}
+
+// ImportPlanets streams an ndjson, csv or json body into the database in batches. Rows that cannot be decoded
+// or written are listed in the report and skipped. With mode=upsert, planets that exist are updated by name.
+func (h *APIHandler) ImportPlanets(w http.ResponseWriter, r *http.Request) {
+	codec, ok := negotiate(w, r)
+	if !ok {
+		return
+	}
+
+	logger := h.logger(r)
+
+	format, err := importFormat(r)
+	if err != nil {
+		logger.W("Unknown format of planets to import", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var upsert bool
+	switch mode := r.URL.Query().Get("mode"); mode {
+	case "", "insert":
+	case "upsert":
+		upsert = true
+	default:
+		logger.W("Unknown planet import mode", "mode", mode)
+		http.Error(w, fmt.Sprintf("unknown import mode %q, use insert or upsert", mode), http.StatusBadRequest)
+		return
+	}
+
+	logger = logger.C("format", format, "upsert", upsert)
+	logger.I("Import planets request")
+
+	decoder, err := model.NewPlanetDecoder(r.Body, format)
+	if err != nil {
+		logger.W("Error on reading planets to import", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report, err := service.ImportPlanets(r.Context(), h.IService, decoder, service.ImportOptions{Upsert: upsert})
+	status := http.StatusOK
+	switch {
+	case errors.Is(err, model.ErrMalformed):
+		logger.W("Import of planets stopped on a malformed row", "err", err, "rows", report.Rows)
+		status = http.StatusBadRequest
+	case err != nil:
+		logger.E("Import of planets stopped", "err", err, "rows", report.Rows)
+		status = errorStatus(err)
+	default:
+		logger.I("Imported planets", "rows", report.Rows, "failed", report.Failed)
+	}
+
+	w.WriteHeader(status)
+	if err := codec.encode(w, report); err != nil {
+		logger.E("Error on writing to output stream", "err", err)
+	}
+}
+
+// ExportPlanets streams all planets as ndjson, csv or json, from the format parameter and ndjson by default.
+// Planets are written as they are read from the database, a failure after the first one aborts the response.
+func (h *APIHandler) ExportPlanets(w http.ResponseWriter, r *http.Request) {
+	logger := h.logger(r)
+
+	format := model.FormatNDJSON
+	if value := r.URL.Query().Get("format"); value != "" {
+		var err error
+		if format, err = model.ParseFormat(value); err != nil {
+			logger.W("Unknown format of planets to export", "err", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	logger = logger.C("format", format)
+	logger.I("Export planets request")
+
+	if h.planetsNotModified(w, r) {
+		return
+	}
+
+	w.Header().Set("Content-Type", formatContentTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="planets.%s"`, format))
+
+	// the format was checked above
+	encoder, _ := model.NewPlanetEncoder(w, format)
+	count, err := service.ExportPlanets(r.Context(), h.IService, encoder)
+	switch {
+	case err != nil && count == 0:
+		logger.E("Error on reading planets to export", "err", err)
+		w.Header().Del("Content-Disposition")
+		http.Error(w, "Error on reading planets to export", errorStatus(err))
+	case err != nil:
+		// the status is sent already, abort so the client does not take a partial export for a whole one
+		logger.E("Export of planets stopped", "err", err, "planets", count)
+		panic(http.ErrAbortHandler)
+	default:
+		logger.I("Exported planets", "planets", count)
+	}
+}
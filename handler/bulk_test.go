@@ -0,0 +1,181 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gugabfigueiredo/star-wars-api/log"
+	"github.com/gugabfigueiredo/star-wars-api/model"
+	"github.com/gugabfigueiredo/star-wars-api/test"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPIHandler_ImportPlanets(t *testing.T) {
+	tests := []struct {
+		name               string
+		stub               *test.Stub
+		query              string
+		contentType        string
+		body               string
+		expectedStatusCode int
+		expectedReport     *model.ImportReport
+		expectedCalledWith map[string]interface{}
+	}{
+		{
+			name:               "csv from content type",
+			stub:               &test.Stub{},
+			contentType:        "text/csv; charset=utf-8",
+			body:               "name,climate,refs\nHoth,frozen,1\nBespin,,many\n",
+			expectedStatusCode: http.StatusOK,
+			expectedReport: &model.ImportReport{Format: model.FormatCSV, Rows: 2, Inserted: 1, Failed: 1,
+				Errors: []model.RowError{{Row: 2, Message: `invalid refs "many"`}}},
+			expectedCalledWith: map[string]interface{}{"planets": []model.Planet{{Name: "Hoth", Climate: "frozen", Refs: 1}}},
+		},
+		{
+			name:               "upsert ndjson",
+			stub:               &test.Stub{},
+			query:              "?format=ndjson&mode=upsert",
+			body:               `{"Name":"Hoth","Refs":2}`,
+			expectedStatusCode: http.StatusOK,
+			expectedReport:     &model.ImportReport{Format: model.FormatNDJSON, Upsert: true, Rows: 1, Errors: []model.RowError{}},
+			expectedCalledWith: map[string]interface{}{"planets": []model.Planet{{Name: "Hoth", Refs: 2}}, "upsert": true},
+		},
+		{
+			name:               "malformed json",
+			stub:               &test.Stub{},
+			query:              "?format=json",
+			body:               `[{"Name":"Hoth"},{"Name"`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedReport: &model.ImportReport{Format: model.FormatJSON, Rows: 1, Errors: []model.RowError{},
+				Error: "malformed planets: row 2: unexpected EOF"},
+		},
+		{
+			name:               "database failure",
+			stub:               &test.Stub{Error: errors.New("connection reset")},
+			query:              "?format=ndjson",
+			body:               `{"Name":"Hoth"}`,
+			expectedStatusCode: http.StatusInternalServerError,
+			expectedReport:     &model.ImportReport{Format: model.FormatNDJSON, Rows: 1, Errors: []model.RowError{}, Error: "connection reset"},
+			expectedCalledWith: map[string]interface{}{"planets": []model.Planet{{Name: "Hoth"}}},
+		},
+		{
+			name:               "unknown format",
+			stub:               &test.Stub{},
+			contentType:        "application/xml",
+			body:               `<planets/>`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "unknown mode",
+			stub:               &test.Stub{},
+			query:              "?format=csv&mode=replace",
+			body:               "name\nHoth\n",
+			expectedStatusCode: http.StatusBadRequest,
+		},
+	}
+
+	logger := log.New(&log.Config{
+		Context:               "sw-api-test",
+		ConsoleLoggingEnabled: false,
+		EncodeLogsAsJson:      true,
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &APIHandler{IService: tt.stub, Logger: logger}
+
+			req := httptest.NewRequest(http.MethodPost, "/planets/import"+tt.query, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			rec := httptest.NewRecorder()
+			h.ImportPlanets(rec, req)
+
+			assert.Equal(t, tt.expectedStatusCode, rec.Code)
+			assert.Equal(t, tt.expectedCalledWith, tt.stub.CalledWith)
+			if tt.expectedReport != nil {
+				var report model.ImportReport
+				assert.NoError(t, json.NewDecoder(rec.Body).Decode(&report))
+				assert.Equal(t, tt.expectedReport, &report)
+			}
+		})
+	}
+}
+
+func TestAPIHandler_ExportPlanets(t *testing.T) {
+	tests := []struct {
+		name                string
+		stub                *test.Stub
+		query               string
+		expectedStatusCode  int
+		expectedContentType string
+		expectedBody        string
+	}{
+		{
+			name:                "ndjson by default",
+			stub:                &test.Stub{Planets: []*model.Planet{{Name: "Hoth", Refs: 1}, {Name: "Naboo"}}},
+			expectedStatusCode:  http.StatusOK,
+			expectedContentType: "application/x-ndjson",
+			expectedBody: `{"ID":"000000000000000000000000","Name":"Hoth","Climate":"","Terrain":"","Refs":1}` + "\n" +
+				`{"ID":"000000000000000000000000","Name":"Naboo","Climate":"","Terrain":"","Refs":0}` + "\n",
+		},
+		{
+			name:                "csv",
+			stub:                &test.Stub{Planets: []*model.Planet{{Name: "Hoth", Climate: "frozen", Refs: 1}}},
+			query:               "?format=csv",
+			expectedStatusCode:  http.StatusOK,
+			expectedContentType: "text/csv",
+			expectedBody:        "id,name,climate,terrain,refs\n,Hoth,frozen,,1\n",
+		},
+		{
+			name:                "empty json",
+			stub:                &test.Stub{},
+			query:               "?format=json",
+			expectedStatusCode:  http.StatusOK,
+			expectedContentType: "application/json",
+			expectedBody:        "[]\n",
+		},
+		{
+			name:                "fail before the first planet",
+			stub:                &test.Stub{Error: errors.New("connection reset")},
+			expectedStatusCode:  http.StatusInternalServerError,
+			expectedContentType: "text/plain; charset=utf-8",
+			expectedBody:        "Error on reading planets to export\n",
+		},
+		{
+			name:                "unknown format",
+			stub:                &test.Stub{},
+			query:               "?format=xml",
+			expectedStatusCode:  http.StatusBadRequest,
+			expectedContentType: "text/plain; charset=utf-8",
+			expectedBody:        "unknown planet format \"xml\", use json, ndjson or csv\n",
+		},
+	}
+
+	logger := log.New(&log.Config{
+		Context:               "sw-api-test",
+		ConsoleLoggingEnabled: false,
+		EncodeLogsAsJson:      true,
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &APIHandler{IService: tt.stub, Logger: logger}
+
+			rec := httptest.NewRecorder()
+			h.ExportPlanets(rec, httptest.NewRequest(http.MethodGet, "/planets/export"+tt.query, nil))
+
+			assert.Equal(t, tt.expectedStatusCode, rec.Code)
+			assert.Equal(t, tt.expectedContentType, rec.Header().Get("Content-Type"))
+			assert.Equal(t, tt.expectedBody, rec.Body.String())
+		})
+	}
+
+	t.Run("abort after the first planet", func(t *testing.T) {
+		h := &APIHandler{IService: &test.Stub{Planets: []*model.Planet{{Name: "Hoth"}}, Error: errors.New("cursor killed")}, Logger: logger}
+		assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+			h.ExportPlanets(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/planets/export", nil))
+		})
+	})
+}
@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HTTPConfig - Configuration of response compression and caching headers
+type HTTPConfig struct {
+	// Encodings responses are compressed with, preferred in this order when the client accepts several of them.
+	// Empty sends every response as it is.
+	Encodings []string `default:"zstd,br,gzip"`
+	// CompressMinSize is the size responses are compressed from, smaller ones gain little
+	CompressMinSize int `default:"1024"`
+	// MaxBodySize bounds the request bodies of planet creates, updates, deletes and graphql requests in bytes,
+	// 0 leaves them unbounded.
+	// Imports stream their bodies and are not bounded by it.
+	MaxBodySize int64 `default:"1048576"`
+	// TrustedProxies are the addresses or CIDR ranges of the proxies in front of the server. Only requests coming
+	// from one of them have their X-Forwarded-For and X-Real-IP taken as the client address.
+	TrustedProxies []string
+
+	// Planets, Planet and Export set the caching of planet listings, single planet reads and exports
+	Planets RouteCache
+	Planet  RouteCache
+	Export  RouteCache
+}
+
+// RouteCache sets the caching headers and compression of a read route
+type RouteCache struct {
+	// CacheControl is sent as it is, no-cache has clients revalidate before every use
+	CacheControl string `default:"no-cache"`
+	// Conditional sends ETag and Last-Modified, and answers If-None-Match and If-Modified-Since with 304
+	// while planets are unchanged
+	Conditional bool `default:"true"`
+	// Compress encodes responses in one of the configured encodings the client accepts
+	Compress bool `default:"true"`
+}
+
+// CheckEncodings fails on encodings responses cannot be compressed with
+func CheckEncodings(encodings []string) error {
+	for _, encoding := range encodings {
+		if _, ok := encoders[encoding]; !ok {
+			return fmt.Errorf("unknown encoding %q, use %s, %s or %s", encoding, EncodingZstd, EncodingBrotli, EncodingGzip)
+		}
+	}
+	return nil
+}
+
+type conditionalKey struct{}
+
+// Caching sets the Cache-Control of a read route, compresses its responses and lets its handlers answer
+// conditional requests, as configured for the route
+func Caching(config *HTTPConfig, route RouteCache) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if route.Compress && len(config.Encodings) > 0 {
+			next = Compress(config.Encodings, config.CompressMinSize)(next)
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if route.CacheControl != "" {
+				w.Header().Set("Cache-Control", route.CacheControl)
+			}
+			// responses are negotiated, caches must tell them apart
+			w.Header().Add("Vary", "Accept")
+			if route.Conditional {
+				r = r.WithContext(context.WithValue(r.Context(), conditionalKey{}, true))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// conditional reports whether the route of r answers conditional requests
+func conditional(r *http.Request) bool {
+	enabled, _ := r.Context().Value(conditionalKey{}).(bool)
+	return enabled
+}
+
+// notModified sets the validators of conditional routes and answers 304 when the copy the client has is still
+// current. The ETag tells the modified time to the millisecond and is checked first, from If-None-Match.
+// If-Modified-Since only tells seconds, so Last-Modified is only sent once the second of the modification is over:
+// a write later in that second would look no newer than the copy of the client otherwise.
+// A zero modified time is unknown and never matches.
+func notModified(w http.ResponseWriter, r *http.Request, modified time.Time) bool {
+	if !conditional(r) || modified.IsZero() {
+		return false
+	}
+	etag := fmt.Sprintf(`W/"%d"`, modified.UnixNano()/int64(time.Millisecond))
+	w.Header().Set("ETag", etag)
+	settled := time.Since(modified.Truncate(time.Second)) >= time.Second
+	if settled {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		if !etagMatches(match, etag) {
+			return false
+		}
+	} else {
+		since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+		// http dates have no fraction of a second
+		if err != nil || !settled || modified.Truncate(time.Second).After(since) {
+			return false
+		}
+	}
+
+	w.Header().Del("Content-Type")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatches compares the entity tags of an If-None-Match header with etag, weakly
+func etagMatches(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// planetsNotModified is notModified for the whole collection of planets, checked before it is read.
+// Planets are served when their last modification cannot be read.
+func (h *APIHandler) planetsNotModified(w http.ResponseWriter, r *http.Request) bool {
+	if !conditional(r) {
+		return false
+	}
+	modified, err := h.LastModified(r.Context())
+	if err != nil {
+		h.logger(r).W("Error on reading when planets last changed", "err", err)
+		return false
+	}
+	return notModified(w, r, modified)
+}
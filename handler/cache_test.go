@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gugabfigueiredo/star-wars-api/log"
+	"github.com/gugabfigueiredo/star-wars-api/model"
+	"github.com/gugabfigueiredo/star-wars-api/test"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCaching(t *testing.T) {
+	modified := time.Date(2021, 9, 1, 10, 0, 0, 500, time.UTC)
+	etag := `W/"1630490400000"`
+	// written within the second to come, a later write could still share its http date
+	recent := time.Now().Truncate(time.Second).Add(time.Second + 100*time.Millisecond)
+	planets := []*model.Planet{{Name: "Hoth"}}
+
+	tests := []struct {
+		name                 string
+		stub                 *test.Stub
+		route                RouteCache
+		ifModifiedSince      string
+		ifNoneMatch          string
+		expectedStatusCode   int
+		expectedLastModified string
+		expectedETag         string
+		expectedCacheControl string
+	}{
+		{
+			name:                 "changed since",
+			stub:                 &test.Stub{Planets: planets, Modified: modified},
+			route:                RouteCache{CacheControl: "no-cache", Conditional: true},
+			ifModifiedSince:      "Wed, 01 Sep 2021 09:59:59 GMT",
+			expectedStatusCode:   http.StatusOK,
+			expectedLastModified: "Wed, 01 Sep 2021 10:00:00 GMT",
+			expectedETag:         etag,
+			expectedCacheControl: "no-cache",
+		},
+		{
+			name:                 "not modified",
+			stub:                 &test.Stub{Planets: planets, Modified: modified},
+			route:                RouteCache{CacheControl: "max-age=60", Conditional: true},
+			ifModifiedSince:      "Wed, 01 Sep 2021 10:00:00 GMT",
+			expectedStatusCode:   http.StatusNotModified,
+			expectedLastModified: "Wed, 01 Sep 2021 10:00:00 GMT",
+			expectedETag:         etag,
+			expectedCacheControl: "max-age=60",
+		},
+		{
+			name:                 "etag matches",
+			stub:                 &test.Stub{Planets: planets, Modified: modified},
+			route:                RouteCache{Conditional: true},
+			ifNoneMatch:          `"other", ` + etag,
+			expectedStatusCode:   http.StatusNotModified,
+			expectedLastModified: "Wed, 01 Sep 2021 10:00:00 GMT",
+			expectedETag:         etag,
+		},
+		{
+			name:                 "etag changed within the same second",
+			stub:                 &test.Stub{Planets: planets, Modified: modified.Add(300 * time.Millisecond)},
+			route:                RouteCache{Conditional: true},
+			ifNoneMatch:          etag,
+			ifModifiedSince:      "Wed, 01 Sep 2021 10:00:00 GMT",
+			expectedStatusCode:   http.StatusOK,
+			expectedLastModified: "Wed, 01 Sep 2021 10:00:00 GMT",
+			expectedETag:         `W/"1630490400300"`,
+		},
+		{
+			name:               "written this second",
+			stub:               &test.Stub{Planets: planets, Modified: recent},
+			route:              RouteCache{Conditional: true},
+			ifModifiedSince:    recent.UTC().Format(http.TimeFormat),
+			expectedStatusCode: http.StatusOK,
+			expectedETag:       fmt.Sprintf(`W/"%d"`, recent.UnixNano()/int64(time.Millisecond)),
+		},
+		{
+			name:               "not conditional",
+			stub:               &test.Stub{Planets: planets, Modified: modified},
+			route:              RouteCache{},
+			ifModifiedSince:    "Wed, 01 Sep 2021 10:00:00 GMT",
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "never modified",
+			stub:               &test.Stub{Planets: planets},
+			route:              RouteCache{Conditional: true},
+			ifModifiedSince:    "Wed, 01 Sep 2021 10:00:00 GMT",
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "last modification unknown",
+			stub:               &test.Stub{Modified: modified, Error: errors.New("connection reset")},
+			route:              RouteCache{Conditional: true},
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+	}
+
+	logger := log.New(&log.Config{
+		Context:               "sw-api-test",
+		ConsoleLoggingEnabled: false,
+		EncodeLogsAsJson:      true,
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &APIHandler{IService: tt.stub, Logger: logger}
+			route := Caching(&HTTPConfig{}, tt.route)(http.HandlerFunc(h.FindAllPlanets))
+
+			req := httptest.NewRequest(http.MethodGet, "/planets", nil)
+			req.Header.Set("If-Modified-Since", tt.ifModifiedSince)
+			if tt.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			rec := httptest.NewRecorder()
+			route.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.expectedStatusCode, rec.Code)
+			assert.Equal(t, tt.expectedLastModified, rec.Header().Get("Last-Modified"))
+			assert.Equal(t, tt.expectedETag, rec.Header().Get("ETag"))
+			assert.Equal(t, tt.expectedCacheControl, rec.Header().Get("Cache-Control"))
+			if tt.expectedStatusCode == http.StatusNotModified {
+				assert.Empty(t, rec.Body.String())
+			}
+		})
+	}
+
+	t.Run("single planet", func(t *testing.T) {
+		h := &APIHandler{IService: &test.Stub{Planet: &model.Planet{Name: "Hoth", UpdatedAt: &modified}}, Logger: logger}
+		route := Caching(&HTTPConfig{}, RouteCache{Conditional: true})(http.HandlerFunc(h.FindPlanetByName))
+
+		req := httptest.NewRequest(http.MethodGet, "/planets/name/Hoth", nil)
+		req.Header.Set("If-Modified-Since", "Wed, 01 Sep 2021 10:00:00 GMT")
+		rec := httptest.NewRecorder()
+		route.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusNotModified, rec.Code)
+		assert.Empty(t, rec.Header().Get("Content-Type"))
+	})
+
+	t.Run("compressed", func(t *testing.T) {
+		var many []*model.Planet
+		for i := 0; i < 100; i++ {
+			many = append(many, &model.Planet{Name: "Hoth", Climate: "frozen"})
+		}
+		h := &APIHandler{IService: &test.Stub{Planets: many}, Logger: logger}
+		config := &HTTPConfig{Encodings: []string{EncodingGzip}, CompressMinSize: 1024}
+		route := Caching(config, RouteCache{Compress: true})(http.HandlerFunc(h.FindAllPlanets))
+
+		req := httptest.NewRequest(http.MethodGet, "/planets", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		route.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, EncodingGzip, rec.Header().Get("Content-Encoding"))
+		assert.Equal(t, []string{"Accept", "Accept-Encoding"}, rec.Header().Values("Vary"))
+	})
+}
@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// errUnsupportedMediaType is returned for request bodies of a Content-Type no codec reads
+var errUnsupportedMediaType = errors.New("unsupported media type")
+
+// codec writes responses and reads request bodies in one media type
+type codec struct {
+	// mediaTypes the codec answers to, responses are sent as the first one
+	mediaTypes []string
+	encode     func(w io.Writer, v interface{}) error
+	decode     func(r io.Reader, v interface{}) error
+}
+
+// codecs in order of preference, json is picked when the client accepts any type or sends no Accept header.
+// Binary formats and xml use the json field names, so every format carries the same fields.
+var codecs = []*codec{
+	{
+		mediaTypes: []string{"application/json"},
+		encode:     func(w io.Writer, v interface{}) error { return json.NewEncoder(w).Encode(v) },
+		decode:     func(r io.Reader, v interface{}) error { return json.NewDecoder(r).Decode(v) },
+	},
+	{
+		mediaTypes: []string{"application/msgpack", "application/x-msgpack", "application/vnd.msgpack"},
+		encode: func(w io.Writer, v interface{}) error {
+			encoder := msgpack.NewEncoder(w)
+			encoder.SetCustomStructTag("json")
+			return encoder.Encode(v)
+		},
+		decode: func(r io.Reader, v interface{}) error {
+			decoder := msgpack.NewDecoder(r)
+			decoder.SetCustomStructTag("json")
+			return decoder.Decode(v)
+		},
+	},
+	{
+		mediaTypes: []string{"application/cbor"},
+		encode:     func(w io.Writer, v interface{}) error { return cbor.NewEncoder(w).Encode(v) },
+		decode:     func(r io.Reader, v interface{}) error { return cbor.NewDecoder(r).Decode(v) },
+	},
+	{
+		mediaTypes: []string{"application/xml", "text/xml"},
+		encode:     encodeXML,
+		decode:     decodeXML,
+	},
+}
+
+// codecTypes are the media types of all codecs, in order of preference
+func codecTypes() []string {
+	var types []string
+	for _, c := range codecs {
+		types = append(types, c.mediaTypes...)
+	}
+	return types
+}
+
+// codecFor is the codec of mediaType, nil when there is none
+func codecFor(mediaType string) *codec {
+	for _, c := range codecs {
+		for _, t := range c.mediaTypes {
+			if t == mediaType {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+// preferredType is the offer the Accept header of r ranks best, by quality and then by order in the header.
+// Wildcards pick the first offer they match. Without an Accept header the first offer is taken.
+func preferredType(r *http.Request, offers []string) (string, bool) {
+	header := r.Header.Get("Accept")
+	if strings.TrimSpace(header) == "" {
+		return offers[0], true
+	}
+
+	var best string
+	bestQuality := 0.0
+	for _, accepted := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		if quality <= bestQuality {
+			continue
+		}
+		for _, offer := range offers {
+			if matchesType(mediaType, offer) {
+				best, bestQuality = offer, quality
+				break
+			}
+		}
+	}
+	return best, best != ""
+}
+
+// matchesType reports whether offer is accepted by mediaType, which may be */* or type/*
+func matchesType(mediaType, offer string) bool {
+	if mediaType == "*/*" || mediaType == offer {
+		return true
+	}
+	return strings.HasSuffix(mediaType, "/*") && strings.HasPrefix(offer, strings.TrimSuffix(mediaType, "*"))
+}
+
+// negotiate picks the codec of the response from the Accept header and sets its Content-Type.
+// When the client accepts none of them it answers 406 with the supported types and returns false.
+func negotiate(w http.ResponseWriter, r *http.Request) (*codec, bool) {
+	mediaType, ok := preferredType(r, codecTypes())
+	if !ok {
+		notAcceptable(w, codecTypes())
+		return nil, false
+	}
+	c := codecFor(mediaType)
+	w.Header().Set("Content-Type", c.mediaTypes[0])
+	return c, true
+}
+
+// notAcceptable answers 406 listing the media types the route can respond with
+func notAcceptable(w http.ResponseWriter, offers []string) {
+	http.Error(w, fmt.Sprintf("none of the accepted media types is supported, use one of %s", strings.Join(offers, ", ")),
+		http.StatusNotAcceptable)
+}
+
+// decodeBody reads the request body into v in the codec of its Content-Type, json when there is none
+func decodeBody(r *http.Request, v interface{}) error {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return codecs[0].decode(r.Body, v)
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("%w %q", errUnsupportedMediaType, contentType)
+	}
+	c := codecFor(mediaType)
+	if c == nil {
+		return fmt.Errorf("%w %q, use one of %s", errUnsupportedMediaType, mediaType, strings.Join(codecTypes(), ", "))
+	}
+	return c.decode(r.Body, v)
+}
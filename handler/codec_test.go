@@ -0,0 +1,209 @@
+package handler
+
+import (
+	"bytes"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/gugabfigueiredo/star-wars-api/log"
+	"github.com/gugabfigueiredo/star-wars-api/model"
+	"github.com/gugabfigueiredo/star-wars-api/service"
+	"github.com/gugabfigueiredo/star-wars-api/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPreferredType(t *testing.T) {
+	tests := []struct {
+		name         string
+		accept       string
+		expectedType string
+		expectedOK   bool
+	}{
+		{name: "no accept header", accept: "", expectedType: "application/json", expectedOK: true},
+		{name: "anything", accept: "*/*", expectedType: "application/json", expectedOK: true},
+		{name: "exact", accept: "application/cbor", expectedType: "application/cbor", expectedOK: true},
+		{name: "alias", accept: "application/x-msgpack", expectedType: "application/x-msgpack", expectedOK: true},
+		{name: "by quality", accept: "application/json;q=0.5, application/msgpack;q=0.9", expectedType: "application/msgpack", expectedOK: true},
+		{name: "by order on equal quality", accept: "application/xml, application/json", expectedType: "application/xml", expectedOK: true},
+		{name: "subtype wildcard", accept: "text/html, text/*;q=0.8", expectedType: "text/xml", expectedOK: true},
+		{name: "unsupported skipped", accept: "text/html, application/cbor;q=0.1", expectedType: "application/cbor", expectedOK: true},
+		{name: "refused with q=0", accept: "application/json;q=0", expectedOK: false},
+		{name: "unsupported", accept: "text/html, image/png", expectedOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept", tt.accept)
+			mediaType, ok := preferredType(r, codecTypes())
+			assert.Equal(t, tt.expectedOK, ok)
+			assert.Equal(t, tt.expectedType, mediaType)
+		})
+	}
+}
+
+func TestCodecs_RoundTrip(t *testing.T) {
+	id, _ := primitive.ObjectIDFromHex("5f1b2c3d4e5f6a7b8c9d0e1f")
+	planets := []model.Planet{
+		{ID: id, Name: "Hoth", Climate: "frozen", Terrain: "tundra, ice caves", Refs: 1, Sources: map[string]string{"climate": "manual"}},
+		{Name: "1 < 2 & \"Naboo\"", Refs: 4},
+	}
+	report := &model.SyncReport{Policy: model.LocalWins, StartedAt: time.Date(2021, 9, 1, 10, 0, 0, 0, time.UTC), Planets: 2,
+		Conflicts: []model.FieldConflict{{Planet: "Hoth", Field: "weather", Local: "chilly", Upstream: "frozen"}}}
+
+	for _, c := range codecs {
+		t.Run(c.mediaTypes[0], func(t *testing.T) {
+			var buf bytes.Buffer
+			assert.NoError(t, c.encode(&buf, planets))
+			var decoded []model.Planet
+			assert.NoError(t, c.decode(bytes.NewReader(buf.Bytes()), &decoded))
+			assert.Equal(t, planets, decoded)
+
+			buf.Reset()
+			assert.NoError(t, c.encode(&buf, report))
+			var decodedReport model.SyncReport
+			assert.NoError(t, c.decode(bytes.NewReader(buf.Bytes()), &decodedReport))
+			// formats keep the instant but not always the location
+			assert.True(t, report.StartedAt.Equal(decodedReport.StartedAt))
+			assert.True(t, report.FinishedAt.Equal(decodedReport.FinishedAt))
+			decodedReport.StartedAt, decodedReport.FinishedAt = report.StartedAt, report.FinishedAt
+			assert.Equal(t, report, &decodedReport)
+		})
+	}
+}
+
+func TestEncodeXML(t *testing.T) {
+	var buf bytes.Buffer
+	err := encodeXML(&buf, map[string]interface{}{
+		"planets": []*model.Planet{{Name: "Hoth", Refs: 1}},
+		"0":       nil,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>`+"\n"+
+		`<response><entry key="0"></entry><planets><item><ID>000000000000000000000000</ID><Name>Hoth</Name>`+
+		`<Climate></Climate><Terrain></Terrain><Refs>1</Refs></item></planets></response>`+"\n", buf.String())
+}
+
+func TestDecodeBody(t *testing.T) {
+	tests := []struct {
+		name           string
+		contentType    string
+		body           []byte
+		expectedLevel  LogLevel
+		expectedStatus int
+	}{
+		{name: "json without content type", body: []byte(`{"level":"debug"}`), expectedLevel: LogLevel{Level: "debug"}},
+		{name: "xml", contentType: "text/xml; charset=utf-8", body: []byte(`<response><level>warn</level></response>`), expectedLevel: LogLevel{Level: "warn"}},
+		{name: "msgpack", contentType: "application/msgpack", body: mustMsgpack(t, map[string]string{"level": "error"}), expectedLevel: LogLevel{Level: "error"}},
+		{name: "cbor", contentType: "application/cbor", body: mustCBOR(t, map[string]string{"level": "trace"}), expectedLevel: LogLevel{Level: "trace"}},
+		{name: "unsupported", contentType: "application/yaml", body: []byte("level: info"), expectedStatus: http.StatusUnsupportedMediaType},
+		{name: "xml nested too deep", contentType: "application/xml",
+			body:           []byte(strings.Repeat("<a>", 100000) + strings.Repeat("</a>", 100000)),
+			expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPut, "/", bytes.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+
+			var level LogLevel
+			err := decodeBody(r, &level)
+			if tt.expectedStatus != 0 {
+				assert.Equal(t, tt.expectedStatus, errorStatus(err))
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedLevel, level)
+		})
+	}
+}
+
+func TestAPIHandler_Negotiation(t *testing.T) {
+	logger := log.New(&log.Config{
+		Context:               "sw-api-test",
+		ConsoleLoggingEnabled: false,
+		EncodeLogsAsJson:      true,
+	})
+
+	t.Run("planet as msgpack", func(t *testing.T) {
+		h := &APIHandler{IService: &test.Stub{Planet: &model.Planet{Name: "Hoth", Refs: 1}}, Logger: logger}
+		req := httptest.NewRequest(http.MethodGet, "/planets/name/Hoth", nil)
+		req.Header.Set("Accept", "application/msgpack, application/json;q=0.1")
+		rec := httptest.NewRecorder()
+		h.FindPlanetByName(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "application/msgpack", rec.Header().Get("Content-Type"))
+		var planet model.Planet
+		assert.NoError(t, codecFor("application/msgpack").decode(rec.Body, &planet))
+		assert.Equal(t, model.Planet{Name: "Hoth", Refs: 1}, planet)
+	})
+
+	t.Run("all planets as cbor", func(t *testing.T) {
+		h := &APIHandler{IService: &test.Stub{Planets: []*model.Planet{{Name: "Hoth"}, {Name: "Naboo"}}}, Logger: logger}
+		req := httptest.NewRequest(http.MethodGet, "/planets", nil)
+		req.Header.Set("Accept", "application/cbor")
+		rec := httptest.NewRecorder()
+		h.FindAllPlanets(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "application/cbor", rec.Header().Get("Content-Type"))
+		var planets []model.Planet
+		assert.NoError(t, cbor.Unmarshal(rec.Body.Bytes(), &planets))
+		assert.Equal(t, []model.Planet{{Name: "Hoth"}, {Name: "Naboo"}}, planets)
+	})
+
+	t.Run("create from xml", func(t *testing.T) {
+		stub := &test.Stub{}
+		h := &APIHandler{IService: stub, Logger: logger}
+		req := httptest.NewRequest(http.MethodPost, "/planets/create",
+			strings.NewReader(`<planets><item><Name>Hoth</Name><Refs>2</Refs></item></planets>`))
+		req.Header.Set("Content-Type", "application/xml")
+		req.Header.Set("Accept", "application/xml")
+		rec := httptest.NewRecorder()
+		h.CreatePlanets(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "application/xml", rec.Header().Get("Content-Type"))
+		assert.Equal(t, map[string]interface{}{"planets": []model.Planet{{Name: "Hoth", Refs: 2}}}, stub.CalledWith)
+	})
+
+	t.Run("unsupported body", func(t *testing.T) {
+		h := &APIHandler{IService: &test.Stub{}, Logger: logger}
+		req := httptest.NewRequest(http.MethodPost, "/planets/create", strings.NewReader(`name: Hoth`))
+		req.Header.Set("Content-Type", "application/yaml")
+		rec := httptest.NewRecorder()
+		h.CreatePlanets(rec, req)
+
+		assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+	})
+
+	t.Run("not acceptable", func(t *testing.T) {
+		h := &HealthHandler{Service: &service.HealthService{}, Logger: logger}
+		req := httptest.NewRequest(http.MethodGet, "/livez", nil)
+		req.Header.Set("Accept", "text/html")
+		rec := httptest.NewRecorder()
+		h.Live(rec, req)
+
+		assert.Equal(t, http.StatusNotAcceptable, rec.Code)
+		assert.Contains(t, rec.Body.String(), "application/json, application/msgpack")
+	})
+}
+
+func mustMsgpack(t *testing.T, v interface{}) []byte {
+	data, err := msgpack.Marshal(v)
+	assert.NoError(t, err)
+	return data
+}
+
+func mustCBOR(t *testing.T, v interface{}) []byte {
+	data, err := cbor.Marshal(v)
+	assert.NoError(t, err)
+	return data
+}
@@ -0,0 +1,182 @@
+package handler
+
+import (
+	"compress/gzip"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	EncodingZstd   = "zstd"
+	EncodingBrotli = "br"
+	EncodingGzip   = "gzip"
+)
+
+// encoder is a compressing writer that can be reused for another response
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+// encoders pool the writers of every encoding, zstd and brotli writers are costly to set up per response
+var encoders = map[string]*sync.Pool{
+	EncodingZstd: {New: func() interface{} {
+		w, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		return w
+	}},
+	EncodingBrotli: {New: func() interface{} {
+		return brotli.NewWriterLevel(nil, brotli.DefaultCompression)
+	}},
+	EncodingGzip: {New: func() interface{} {
+		return gzip.NewWriter(nil)
+	}},
+}
+
+// acceptedEncoding is the first of encodings the Accept-Encoding header of r allows with the best quality,
+// empty when the response goes as it is
+func acceptedEncoding(r *http.Request, encodings []string) string {
+	qualities := map[string]float64{}
+	for _, accepted := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		qualities[coding] = quality
+	}
+
+	var best string
+	bestQuality := 0.0
+	for _, encoding := range encodings {
+		quality, ok := qualities[encoding]
+		if !ok {
+			quality = qualities["*"]
+		}
+		if quality > bestQuality {
+			best, bestQuality = encoding, quality
+		}
+	}
+	return best
+}
+
+// Compress encodes responses of at least minSize bytes in the first of encodings the client accepts.
+// Smaller responses, and those already encoded, are sent as they are.
+func Compress(encodings []string, minSize int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			encoding := acceptedEncoding(r, encodings)
+			if encoding == "" || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{ResponseWriter: w, encoding: encoding, minSize: minSize}
+			next.ServeHTTP(cw, r)
+			// a handler that panics aborts the response, there is nothing to finish then
+			cw.close()
+		})
+	}
+}
+
+// compressWriter holds the start of a response until it is large enough to be worth compressing
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+
+	status  int
+	buf     []byte
+	decided bool
+	encoder encoder
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status != 0 {
+		return
+	}
+	cw.status = status
+	// responses without a body go out now
+	if status == http.StatusNotModified || status == http.StatusNoContent || status < http.StatusOK {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.decided {
+		if cw.encoder != nil {
+			return cw.encoder.Write(b)
+		}
+		return cw.ResponseWriter.Write(b)
+	}
+
+	cw.buf = append(cw.buf, b...)
+	if len(cw.buf) >= cw.minSize {
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// decide sends the header, compressed if compress and nothing encoded the response yet, then what was held back
+func (cw *compressWriter) decide(compress bool) error {
+	cw.decided = true
+	header := cw.Header()
+	if compress && header.Get("Content-Encoding") == "" {
+		header.Set("Content-Encoding", cw.encoding)
+		header.Del("Content-Length")
+		cw.encoder = encoders[cw.encoding].Get().(encoder)
+		cw.encoder.Reset(cw.ResponseWriter)
+	}
+
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	buf := cw.buf
+	cw.buf = nil
+	_, err := cw.Write(buf)
+	return err
+}
+
+// Flush sends what was written so far, compressed as streamed responses are expected to go on
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.decide(true)
+	}
+	if cw.encoder != nil {
+		cw.encoder.Flush()
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		cw.decide(false)
+	}
+	if cw.encoder != nil {
+		cw.encoder.Close()
+		encoders[cw.encoding].Put(cw.encoder)
+		cw.encoder = nil
+	}
+}
@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptedEncoding(t *testing.T) {
+	encodings := []string{EncodingZstd, EncodingBrotli, EncodingGzip}
+	tests := []struct {
+		name             string
+		acceptEncoding   string
+		expectedEncoding string
+	}{
+		{name: "none", acceptEncoding: "", expectedEncoding: ""},
+		{name: "server preference", acceptEncoding: "gzip, deflate, br", expectedEncoding: EncodingBrotli},
+		{name: "client quality", acceptEncoding: "zstd;q=0.5, gzip", expectedEncoding: EncodingGzip},
+		{name: "wildcard", acceptEncoding: "*", expectedEncoding: EncodingZstd},
+		{name: "wildcard with refusals", acceptEncoding: "zstd;q=0, br;q=0, *;q=0.1", expectedEncoding: EncodingGzip},
+		{name: "identity only", acceptEncoding: "identity", expectedEncoding: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			assert.Equal(t, tt.expectedEncoding, acceptedEncoding(r, encodings))
+		})
+	}
+}
+
+func TestCompress(t *testing.T) {
+	large := strings.Repeat(`{"Name":"Hoth","Climate":"frozen"}`, 100)
+	decoders := map[string]func(io.Reader) io.Reader{
+		EncodingGzip: func(r io.Reader) io.Reader {
+			zr, err := gzip.NewReader(r)
+			assert.NoError(t, err)
+			return zr
+		},
+		EncodingBrotli: func(r io.Reader) io.Reader { return brotli.NewReader(r) },
+		EncodingZstd: func(r io.Reader) io.Reader {
+			zr, err := zstd.NewReader(r)
+			assert.NoError(t, err)
+			return zr
+		},
+	}
+
+	tests := []struct {
+		name             string
+		acceptEncoding   string
+		status           int
+		header           map[string]string
+		writes           []string
+		expectedEncoding string
+		expectedStatus   int
+	}{
+		{name: "gzip", acceptEncoding: "gzip", writes: []string{large[:10], large[10:]}, expectedEncoding: EncodingGzip, expectedStatus: http.StatusOK},
+		{name: "brotli", acceptEncoding: "br", writes: []string{large}, expectedEncoding: EncodingBrotli, expectedStatus: http.StatusOK},
+		{name: "zstd keeps the status", acceptEncoding: "zstd", status: http.StatusCreated, writes: []string{large}, expectedEncoding: EncodingZstd, expectedStatus: http.StatusCreated},
+		{name: "small response", acceptEncoding: "gzip", writes: []string{"[]\n"}, expectedStatus: http.StatusOK},
+		{name: "not accepted", writes: []string{large}, expectedStatus: http.StatusOK},
+		{name: "encoded already", acceptEncoding: "gzip", header: map[string]string{"Content-Encoding": "identity"}, writes: []string{large}, expectedEncoding: "identity", expectedStatus: http.StatusOK},
+		{name: "not modified", acceptEncoding: "gzip", status: http.StatusNotModified, expectedStatus: http.StatusNotModified},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Compress([]string{EncodingZstd, EncodingBrotli, EncodingGzip}, 1024)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for key, value := range tt.header {
+					w.Header().Set(key, value)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+				}
+				for _, write := range tt.writes {
+					_, _ = io.WriteString(w, write)
+				}
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+			assert.Equal(t, "Accept-Encoding", rec.Header().Get("Vary"))
+			assert.Equal(t, tt.expectedEncoding, rec.Header().Get("Content-Encoding"))
+
+			body := io.Reader(rec.Body)
+			if decode, ok := decoders[tt.expectedEncoding]; ok {
+				body = decode(rec.Body)
+			}
+			data, err := ioutil.ReadAll(body)
+			assert.NoError(t, err)
+			assert.Equal(t, strings.Join(tt.writes, ""), string(data))
+		})
+	}
+
+	t.Run("flush sends what was written", func(t *testing.T) {
+		h := Compress([]string{EncodingGzip}, 1024)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, "first")
+			w.(http.Flusher).Flush()
+		}))
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+
+		assert.True(t, rec.Flushed)
+		assert.Equal(t, EncodingGzip, rec.Header().Get("Content-Encoding"))
+		zr, err := gzip.NewReader(bytes.NewReader(rec.Body.Bytes()))
+		assert.NoError(t, err)
+		data, err := ioutil.ReadAll(zr)
+		assert.NoError(t, err)
+		assert.Equal(t, "first", string(data))
+	})
+}
@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gugabfigueiredo/star-wars-api/graph"
+	"github.com/gugabfigueiredo/star-wars-api/log"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// graphQLContentType is the media type of a query sent as the request body, instead of inside a json body
+const graphQLContentType = "application/graphql"
+
+type GraphQLHandler struct {
+	Schema *graph.Schema
+	Logger *log.Logger
+}
+
+// Serve runs the query of the GET parameters, or of a POST body in json or application/graphql.
+// Mutations are only run for POST requests. Responses are json, with the errors of the query in them.
+func (h *GraphQLHandler) Serve(w http.ResponseWriter, r *http.Request) {
+	offers := []string{"application/json"}
+	if _, ok := preferredType(r, offers); !ok {
+		notAcceptable(w, offers)
+		return
+	}
+
+	logger := log.FromContext(r.Context(), h.Logger)
+
+	request, err := graphQLRequest(r)
+	if err != nil {
+		logger.W("Invalid GraphQL request", "err", err)
+		status := http.StatusBadRequest
+		if errors.Is(err, errUnsupportedMediaType) {
+			status = http.StatusUnsupportedMediaType
+		}
+		if errors.Is(err, errBodyTooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	res := h.Schema.Exec(r.Context(), request)
+	if len(res.Errors) > 0 {
+		logger.W("GraphQL request had errors", "operation", request.OperationName, "errors", res.Errors)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		logger.E("Error on writing to output stream", "err", err)
+		http.Error(w, "Error on writing to output stream", http.StatusInternalServerError)
+		return
+	}
+}
+
+// graphQLRequest reads the request from the query parameters of GET requests, which are read-only,
+// or from the body of others
+func graphQLRequest(r *http.Request) (graph.Request, error) {
+	var request graph.Request
+	if r.Method == http.MethodGet {
+		params := r.URL.Query()
+		request = graph.Request{Query: params.Get("query"), OperationName: params.Get("operationName"), ReadOnly: true}
+		if variables := params.Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &request.Variables); err != nil {
+				return request, fmt.Errorf("invalid variables: %s", err)
+			}
+		}
+	} else {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch mediaType {
+		case "", "application/json":
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				return request, fmt.Errorf("invalid json body: %w", err)
+			}
+		case graphQLContentType:
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				return request, err
+			}
+			request.Query = string(body)
+		default:
+			return request, fmt.Errorf("%w %q, use application/json or %s", errUnsupportedMediaType, mediaType, graphQLContentType)
+		}
+	}
+
+	if strings.TrimSpace(request.Query) == "" {
+		return request, errors.New("missing query")
+	}
+	return request, nil
+}
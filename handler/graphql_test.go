@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"github.com/gugabfigueiredo/star-wars-api/graph"
+	"github.com/gugabfigueiredo/star-wars-api/log"
+	"github.com/gugabfigueiredo/star-wars-api/model"
+	"github.com/gugabfigueiredo/star-wars-api/test"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestGraphQLHandler_Serve(t *testing.T) {
+
+	tests := []struct {
+		name           string
+		method         string
+		params         url.Values
+		contentType    string
+		accept         string
+		body           string
+		limit          int64
+		expectedStatus int
+		expectedBody   string
+		expectedCalled map[string]interface{}
+	}{
+		{
+			name:           "query in the url",
+			method:         http.MethodGet,
+			params:         url.Values{"query": {`query($name: String) { planet(name: $name) { name climate } }`}, "variables": {`{"name": "Hoth"}`}},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"data":{"planet":{"name":"Hoth","climate":"frozen"}}}`,
+		},
+		{
+			name:           "json body",
+			method:         http.MethodPost,
+			contentType:    "application/json",
+			body:           `{"query": "mutation($names: [String!]!) { deletePlanets(names: $names) { deleted } }", "variables": {"names": ["Hoth"]}}`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"data":{"deletePlanets":{"deleted":1}}}`,
+			expectedCalled: map[string]interface{}{"planets": []model.Planet{{Name: "Hoth"}}},
+		},
+		{
+			name:           "graphql body",
+			method:         http.MethodPost,
+			contentType:    "application/graphql",
+			body:           `{ planet(name: "Hoth") { name } }`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"data":{"planet":{"name":"Hoth"}}}`,
+		},
+		{
+			name:           "no mutations in the url",
+			method:         http.MethodGet,
+			params:         url.Values{"query": {`mutation { deletePlanets(names: ["Hoth"]) { deleted } }`}},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"errors":[{"message":"mutations are not allowed in read-only requests","path":["deletePlanets"]}],"data":null}`,
+		},
+		{
+			name:           "query errors",
+			method:         http.MethodPost,
+			body:           `{"query": "{ planet(name: \"Hoth\") { weather } }"}`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"errors":[{"message":"Cannot query field \"weather\" on type \"Planet\".","locations":[{"line":1,"column":26}]}]}`,
+		},
+		{
+			name:           "missing query",
+			method:         http.MethodGet,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "missing query",
+		},
+		{
+			name:           "invalid variables",
+			method:         http.MethodGet,
+			params:         url.Values{"query": {`{ planet(name: "Hoth") { name } }`}, "variables": {`{name`}},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "invalid variables",
+		},
+		{
+			name:           "unsupported body",
+			method:         http.MethodPost,
+			contentType:    "application/yaml",
+			body:           `query: "{ planet { name } }"`,
+			expectedStatus: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:           "json body too large",
+			method:         http.MethodPost,
+			body:           `{"query": "{ planet(name: \"` + strings.Repeat("Hoth", 64) + `\") { name } }"}`,
+			limit:          128,
+			expectedStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:           "graphql body too large",
+			method:         http.MethodPost,
+			contentType:    "application/graphql",
+			body:           `{ planet(name: "` + strings.Repeat("Hoth", 64) + `") { name } }`,
+			limit:          128,
+			expectedStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:           "not acceptable",
+			method:         http.MethodGet,
+			params:         url.Values{"query": {`{ planet(name: "Hoth") { name } }`}},
+			accept:         "application/xml",
+			expectedStatus: http.StatusNotAcceptable,
+		},
+	}
+
+	logger := log.New(&log.Config{
+		Context:               "sw-api-test",
+		ConsoleLoggingEnabled: false,
+		EncodeLogsAsJson:      true,
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &test.Stub{
+				Planet:       &model.Planet{Name: "Hoth", Climate: "frozen"},
+				DeleteResult: mongo.DeleteResult{DeletedCount: 1},
+			}
+			schema, err := graph.New(stub, stub, &graph.Config{MaxDepth: 8, MaxPageSize: 100})
+			assert.NoError(t, err)
+			h := &GraphQLHandler{Schema: schema, Logger: logger}
+
+			req := httptest.NewRequest(tt.method, "/graphql?"+tt.params.Encode(), strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			if tt.limit > 0 {
+				// streamed, so the body is only found too large while it is read
+				req.ContentLength = -1
+			}
+			rec := httptest.NewRecorder()
+			MaxBodySize(tt.limit)(http.HandlerFunc(h.Serve)).ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+			if tt.expectedStatus == http.StatusOK {
+				assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+				assert.JSONEq(t, tt.expectedBody, rec.Body.String())
+			} else {
+				assert.Contains(t, rec.Body.String(), tt.expectedBody)
+			}
+			if tt.expectedCalled != nil {
+				assert.Equal(t, tt.expectedCalled, stub.CalledWith)
+			}
+		})
+	}
+}
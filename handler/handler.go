@@ -1,7 +1,6 @@
 package handler
 
 import (
-	"encoding/json"
 	"github.com/go-chi/chi"
 	"github.com/gugabfigueiredo/star-wars-api/log"
 	"github.com/gugabfigueiredo/star-wars-api/model"
@@ -12,7 +11,7 @@ import (
 
 type IHandler interface {
 	service.IService
-	FindAllPlanets(w http.ResponseWriter, _ *http.Request)
+	FindAllPlanets(w http.ResponseWriter, r *http.Request)
 	FindPlanet(w http.ResponseWriter, r *http.Request)
 	FindPlanetByID(w http.ResponseWriter, r *http.Request)
 	CreatePlanets(w http.ResponseWriter, r *http.Request)
@@ -24,41 +23,91 @@ type APIHandler struct {
 	Logger *log.Logger
 }
 
-func (h *APIHandler) FindAllPlanets(w http.ResponseWriter, _ *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+// FindAllPlanets streams every planet as it is read from the database, as a json array or as ndjson
+// when the client accepts it, so memory does not grow with the number of planets.
+// The other response formats need the whole list first.
+func (h *APIHandler) FindAllPlanets(w http.ResponseWriter, r *http.Request) {
+	offers := append(codecTypes(), formatContentTypes[model.FormatNDJSON], "application/ndjson")
+	mediaType, ok := preferredType(r, offers)
+	if !ok {
+		notAcceptable(w, offers)
+		return
+	}
+
+	logger := h.logger(r)
+	logger.I("Request all planets")
 
-	h.Logger.I("Request all planets")
+	if h.planetsNotModified(w, r) {
+		return
+	}
 
-	planets, err := h.GetAllPlanets()
+	format := model.FormatJSON
+	switch mediaType {
+	case formatContentTypes[model.FormatJSON]:
+	case formatContentTypes[model.FormatNDJSON], "application/ndjson":
+		format = model.FormatNDJSON
+	default:
+		h.renderAllPlanets(w, r, codecFor(mediaType))
+		return
+	}
+	w.Header().Set("Content-Type", formatContentTypes[format])
+
+	encoder, _ := model.NewPlanetEncoder(w, format)
+	count, err := service.ExportPlanets(r.Context(), h.IService, encoder)
+	switch {
+	case err != nil && count == 0:
+		logger.E("Failed to request for all planets", "err", err)
+		http.Error(w, "Failed to request for all planets", errorStatus(err))
+	case err != nil:
+		// the status is sent already, abort so the client does not take part of the planets for all of them
+		logger.E("Failed to stream all planets", "err", err, "planets", count)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// renderAllPlanets writes all planets at once in codec
+func (h *APIHandler) renderAllPlanets(w http.ResponseWriter, r *http.Request, codec *codec) {
+	w.Header().Set("Content-Type", codec.mediaTypes[0])
+
+	logger := h.logger(r)
+
+	planets, err := h.GetAllPlanets(r.Context())
 	if err != nil {
-		h.Logger.E("Failed to request for all planets", "err", err)
-		http.Error(w, "Failed to request for all planets", http.StatusInternalServerError)
+		logger.E("Failed to request for all planets", "err", err)
+		http.Error(w, "Failed to request for all planets", errorStatus(err))
 		return
 	}
 
-	if err := json.NewEncoder(w).Encode(&planets); err != nil {
-		h.Logger.E("Error on marshal all planets", "err", err)
+	if err := codec.encode(w, planets); err != nil {
+		logger.E("Error on marshal all planets", "err", err)
 		http.Error(w, "Error on marshal all planets", http.StatusInternalServerError)
 		return
 	}
 }
 
 func (h *APIHandler) FindPlanetByName(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+	codec, ok := negotiate(w, r)
+	if !ok {
+		return
+	}
 
 	name := chi.URLParam(r, "name")
 
-	logger := h.Logger.C("name", name)
-	logger.I("Request planet by name", "name", name)
+	logger := h.logger(r).C("name", name)
+	logger.I("Request planet by name")
 
 	var planet model.Planet
-	if err := h.GetPlanet(bson.M{"name": name}, &planet); err != nil {
+	if err := h.GetPlanet(r.Context(), bson.M{"name": name}, &planet); err != nil {
 		logger.E("Error on calling db for planet by name", "err", err)
-		http.Error(w, "Error on calling db for planet by name", http.StatusInternalServerError)
+		http.Error(w, "Error on calling db for planet by name", errorStatus(err))
+		return
+	}
+
+	if planet.UpdatedAt != nil && notModified(w, r, *planet.UpdatedAt) {
 		return
 	}
 
-	if err := json.NewEncoder(w).Encode(planet); err != nil {
+	if err := codec.encode(w, planet); err != nil {
 		logger.E("Error on marshal planet by name", "err", err)
 		http.Error(w, "Error on marshal planet by name", http.StatusInternalServerError)
 		return
@@ -66,48 +115,59 @@ func (h *APIHandler) FindPlanetByName(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *APIHandler) FindPlanetByID(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+	codec, ok := negotiate(w, r)
+	if !ok {
+		return
+	}
 
 	ID := chi.URLParam(r, "planetID")
 
-	logger := h.Logger.C("ID", ID)
-	logger.I("Request planet by id", "ID", ID)
+	logger := h.logger(r).C("ID", ID)
+	logger.I("Request planet by id")
 
 	var planet model.Planet
-	if err := h.GetPlanet(bson.M{"_id": ID}, &planet);err != nil {
-		h.Logger.E("Error on calling db for planet by id", "err", err, "_id", ID)
-		http.Error(w, "Error on calling db for planet by id", http.StatusInternalServerError)
+	if err := h.GetPlanet(r.Context(), bson.M{"_id": ID}, &planet);err != nil {
+		logger.E("Error on calling db for planet by id", "err", err)
+		http.Error(w, "Error on calling db for planet by id", errorStatus(err))
+		return
+	}
+
+	if planet.UpdatedAt != nil && notModified(w, r, *planet.UpdatedAt) {
 		return
 	}
 
-	if err := json.NewEncoder(w).Encode(planet); err != nil {
-		h.Logger.E("Error on marshal planet by ID", "err", err, "_id", ID, "planet", planet)
+	if err := codec.encode(w, planet); err != nil {
+		logger.E("Error on marshal planet by ID", "err", err, "planet", planet)
 		http.Error(w, "Error on marshal planet by ID", http.StatusInternalServerError)
 		return
 	}
 }
 
 func (h *APIHandler) CreatePlanets(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+	codec, ok := negotiate(w, r)
+	if !ok {
+		return
+	}
 
-	h.Logger.I("Create planet request")
+	logger := h.logger(r)
+	logger.I("Create planet request")
 
 	var planets []model.Planet
-	if err := json.NewDecoder(r.Body).Decode(&planets); err != nil {
-		h.Logger.E("Error on unmarshal planets payload for creation", "err", err, "planets", planets)
-		http.Error(w, "Error on unmarshal planets payload for creation", http.StatusInternalServerError)
+	if err := decodeBody(r, &planets); err != nil {
+		logger.E("Error on unmarshal planets payload for creation", "err", err, "planets", planets)
+		http.Error(w, "Error on unmarshal planets payload for creation", errorStatus(err))
 		return
 	}
 
-	res, err := h.InsertPlanets(planets)
+	res, err := h.InsertPlanets(r.Context(), planets)
 	if err != nil {
-		h.Logger.E("Error on insert planets into database", "err", err, "res", res)
-		http.Error(w, "Error on insert planets into database", http.StatusInternalServerError)
+		logger.E("Error on insert planets into database", "err", err, "res", res)
+		http.Error(w, "Error on insert planets into database", errorStatus(err))
 		return
 	}
 
-	if err := json.NewEncoder(w).Encode(res); err != nil {
-		h.Logger.E("Error on writing to output stream", "err", err)
+	if err := codec.encode(w, res); err != nil {
+		logger.E("Error on writing to output stream", "err", err)
 		http.Error(w, "Error on writing to output stream", http.StatusInternalServerError)
 		return
 	}
@@ -115,26 +175,30 @@ func (h *APIHandler) CreatePlanets(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *APIHandler) PlanetUpdate(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+	codec, ok := negotiate(w, r)
+	if !ok {
+		return
+	}
 
-	h.Logger.I("Update planets request")
+	logger := h.logger(r)
+	logger.I("Update planets request")
 
 	var planets []model.Planet
-	if err := json.NewDecoder(r.Body).Decode(&planets); err != nil {
-		h.Logger.E("Error on unmarshal planets payload for creation", "err", err, "planets", planets)
-		http.Error(w, "Error on unmarshal planets payload for creation", http.StatusInternalServerError)
+	if err := decodeBody(r, &planets); err != nil {
+		logger.E("Error on unmarshal planets payload for creation", "err", err, "planets", planets)
+		http.Error(w, "Error on unmarshal planets payload for creation", errorStatus(err))
 		return
 	}
 
-	res, err := h.UpdatePlanets(planets)
+	res, err := h.UpdatePlanets(r.Context(), planets)
 	if err != nil {
-		h.Logger.E("Error on insert planets into database", "err", err, "res", res)
-		http.Error(w, "Error on insert planets into database", http.StatusInternalServerError)
+		logger.E("Error on insert planets into database", "err", err, "res", res)
+		http.Error(w, "Error on insert planets into database", errorStatus(err))
 		return
 	}
 
-	if err := json.NewEncoder(w).Encode(res); err != nil {
-		h.Logger.E("Error on writing to output stream", "err", err)
+	if err := codec.encode(w, res); err != nil {
+		logger.E("Error on writing to output stream", "err", err)
 		http.Error(w, "Error on writing to output stream", http.StatusInternalServerError)
 		return
 	}
@@ -142,26 +206,30 @@ func (h *APIHandler) PlanetUpdate(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *APIHandler) RemovePlanets(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+	codec, ok := negotiate(w, r)
+	if !ok {
+		return
+	}
 
-	h.Logger.I("Remove planet request")
+	logger := h.logger(r)
+	logger.I("Remove planet request")
 
 	var planets []model.Planet
-	if err := json.NewDecoder(r.Body).Decode(&planets); err != nil {
-		h.Logger.E("Error on unmarshal planets payload for creation", "err", err, "planets", planets)
-		http.Error(w, "Error on unmarshal planets payload for creation", http.StatusInternalServerError)
+	if err := decodeBody(r, &planets); err != nil {
+		logger.E("Error on unmarshal planets payload for creation", "err", err, "planets", planets)
+		http.Error(w, "Error on unmarshal planets payload for creation", errorStatus(err))
 		return
 	}
 
-	res, err := h.DeletePlanets(planets)
+	res, err := h.DeletePlanets(r.Context(), planets)
 	if err != nil {
-		h.Logger.E("Error on delete planets into database", "err", err, "res", res)
-		http.Error(w, "Error on delete planets into database", http.StatusInternalServerError)
+		logger.E("Error on delete planets into database", "err", err, "res", res)
+		http.Error(w, "Error on delete planets into database", errorStatus(err))
 		return
 	}
 
-	if err := json.NewEncoder(w).Encode(res); err != nil {
-		h.Logger.E("Error on writing to output stream", "err", err)
+	if err := codec.encode(w, res); err != nil {
+		logger.E("Error on writing to output stream", "err", err)
 		http.Error(w, "Error on writing to output stream", http.StatusInternalServerError)
 		return
 	}
@@ -169,20 +237,30 @@ func (h *APIHandler) RemovePlanets(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *APIHandler) SetMovieRefs(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+	codec, ok := negotiate(w, r)
+	if !ok {
+		return
+	}
 
-	h.Logger.I("Update planets movie refs")
+	logger := h.logger(r)
+	logger.I("Update planets movie refs")
 
-	if err := h.UpdatePlanetRefs(); err != nil {
-		h.Logger.E("failed to update planet refs by request", "err", err)
-		http.Error(w, "failed to update planet refs by request", http.StatusInternalServerError)
+	report, err := h.UpdatePlanetRefs(r.Context())
+	if err != nil {
+		logger.E("failed to update planet refs by request", "err", err)
+		http.Error(w, "failed to update planet refs by request", errorStatus(err))
 		return
 	}
 
-	if err := json.NewEncoder(w).Encode([]byte(`{"status":"success"}`)); err != nil {
-		h.Logger.E("Error on writing to output stream", "err", err)
+	if err := codec.encode(w, report); err != nil {
+		logger.E("Error on writing to output stream", "err", err)
 		http.Error(w, "Error on writing to output stream", http.StatusInternalServerError)
 		return
 	}
 	return
-}
\ No newline at end of file
+}
+
+// logger returns the request-scoped logger set by RequestLogger, or the handler logger outside of it
+func (h *APIHandler) logger(r *http.Request) *log.Logger {
+	return log.FromContext(r.Context(), h.Logger)
+}
@@ -2,6 +2,7 @@ package handler
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,8 +14,11 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"net/http"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"net/http/httptest"
+	"runtime"
 	"testing"
+	"time"
 )
 
 func TestAPIHandler_FindPlanets(t *testing.T) {
@@ -165,6 +169,171 @@ func TestAPIHandler_FindPlanets(t *testing.T) {
 	}
 }
 
+func TestAPIHandler_FindAllPlanets(t *testing.T) {
+	planets := []*model.Planet{{Name: "Hoth", Refs: 1}, {Name: "Naboo"}}
+	tests := []struct {
+		name                string
+		stub                *test.Stub
+		accept              string
+		expectedStatusCode  int
+		expectedContentType string
+		expectedBody        string
+	}{
+		{
+			name:                "json array",
+			stub:                &test.Stub{Planets: planets},
+			expectedStatusCode:  http.StatusOK,
+			expectedContentType: "application/json",
+			expectedBody: `[{"ID":"000000000000000000000000","Name":"Hoth","Climate":"","Terrain":"","Refs":1},` +
+				`{"ID":"000000000000000000000000","Name":"Naboo","Climate":"","Terrain":"","Refs":0}]` + "\n",
+		},
+		{
+			name:                "ndjson when accepted",
+			stub:                &test.Stub{Planets: planets},
+			accept:              "application/json;q=0.5, application/x-ndjson",
+			expectedStatusCode:  http.StatusOK,
+			expectedContentType: "application/x-ndjson",
+			expectedBody: `{"ID":"000000000000000000000000","Name":"Hoth","Climate":"","Terrain":"","Refs":1}` + "\n" +
+				`{"ID":"000000000000000000000000","Name":"Naboo","Climate":"","Terrain":"","Refs":0}` + "\n",
+		},
+		{
+			name:                "no planets",
+			stub:                &test.Stub{},
+			expectedStatusCode:  http.StatusOK,
+			expectedContentType: "application/json",
+			expectedBody:        "[]\n",
+		},
+		{
+			name:                "fail before the first planet",
+			stub:                &test.Stub{Error: errors.New("connection reset")},
+			expectedStatusCode:  http.StatusInternalServerError,
+			expectedContentType: "text/plain; charset=utf-8",
+			expectedBody:        "Failed to request for all planets\n",
+		},
+	}
+
+	logger := log.New(&log.Config{
+		Context:               "sw-api-test",
+		ConsoleLoggingEnabled: false,
+		EncodeLogsAsJson:      true,
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &APIHandler{IService: tt.stub, Logger: logger}
+
+			req := httptest.NewRequest(http.MethodGet, "/planets", nil)
+			req.Header.Set("Accept", tt.accept)
+			rec := httptest.NewRecorder()
+			h.FindAllPlanets(rec, req)
+
+			assert.Equal(t, tt.expectedStatusCode, rec.Code)
+			assert.Equal(t, tt.expectedContentType, rec.Header().Get("Content-Type"))
+			assert.Equal(t, tt.expectedBody, rec.Body.String())
+		})
+	}
+
+	t.Run("abort after the first planet", func(t *testing.T) {
+		h := &APIHandler{IService: &test.Stub{Planets: planets, Error: errors.New("cursor killed")}, Logger: logger}
+		assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+			h.FindAllPlanets(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/planets", nil))
+		})
+	})
+}
+
+// cursorRepo makes a new planet for every one read, as a mongo cursor decodes them, so the benchmarks
+// measure what the handler holds on to rather than a dataset already in memory
+type cursorRepo struct {
+	*test.Stub
+	count int
+	// live is the heap in use when the last planet is read, after a collection
+	live uint64
+}
+
+func (r *cursorRepo) planet(i int) *model.Planet {
+	return &model.Planet{ID: primitive.NewObjectID(), Name: fmt.Sprintf("Planet %d", i), Climate: "temperate", Terrain: "grasslands, mountains", Refs: i % 6}
+}
+
+// liveHeap is the heap in use once garbage is collected, twice to empty the sync.Pool victim caches
+// that hold on to the json encoding buffers
+func liveHeap() uint64 {
+	var stats runtime.MemStats
+	runtime.GC()
+	runtime.GC()
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
+
+func (r *cursorRepo) GetAllPlanets(context.Context) ([]*model.Planet, error) {
+	var planets []*model.Planet
+	for i := 0; i < r.count; i++ {
+		planets = append(planets, r.planet(i))
+	}
+	r.live = liveHeap()
+	return planets, nil
+}
+
+func (r *cursorRepo) StreamPlanets(_ context.Context, fn func(*model.Planet) error) error {
+	for i := 0; i < r.count; i++ {
+		if err := fn(r.planet(i)); err != nil {
+			return err
+		}
+	}
+	r.live = liveHeap()
+	return nil
+}
+
+// discardResponse is a response writer that keeps nothing of the body, unlike httptest.ResponseRecorder
+type discardResponse struct{ header http.Header }
+
+func (d discardResponse) Header() http.Header         { return d.header }
+func (d discardResponse) Write(b []byte) (int, error) { return len(b), nil }
+func (d discardResponse) WriteHeader(int)             {}
+
+// benchmarkAllPlanets reports the heap held while listing planets as live-B/op, next to the allocation totals
+func benchmarkAllPlanets(b *testing.B, count int, find func(h *APIHandler, w http.ResponseWriter, r *http.Request)) {
+	repo := &cursorRepo{Stub: &test.Stub{}, count: count}
+	h := &APIHandler{IService: repo, Logger: log.New(&log.Config{Context: "sw-api-bench"})}
+	r := httptest.NewRequest(http.MethodGet, "/planets", nil)
+
+	var live uint64
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		before := liveHeap()
+		b.StartTimer()
+
+		find(h, discardResponse{header: http.Header{}}, r)
+		if repo.live > before {
+			live += repo.live - before
+		}
+	}
+	b.ReportMetric(float64(live)/float64(b.N), "live-B/op")
+}
+
+// bufferedAllPlanets is how all planets were listed before streaming, decoded into a slice and then encoded
+func bufferedAllPlanets(h *APIHandler, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	planets, err := h.GetAllPlanets(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to request for all planets", errorStatus(err))
+		return
+	}
+	json.NewEncoder(w).Encode(&planets)
+}
+
+func BenchmarkFindAllPlanets(b *testing.B) {
+	for _, count := range []int{1000, 100000} {
+		b.Run(fmt.Sprintf("buffered/%d", count), func(b *testing.B) {
+			benchmarkAllPlanets(b, count, bufferedAllPlanets)
+		})
+		b.Run(fmt.Sprintf("streamed/%d", count), func(b *testing.B) {
+			benchmarkAllPlanets(b, count, (*APIHandler).FindAllPlanets)
+		})
+	}
+}
+
 func TestAPIHandler_CreateUpdateDelete(t *testing.T) {
 
 	tests := []struct{
@@ -467,4 +636,63 @@ func TestAPIHandler_UpdatePlanetRefs(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+func TestAPIHandler_Cancellation(t *testing.T) {
+
+	tests := []struct {
+		name               string
+		routeTimeout       time.Duration
+		clientTimeout      time.Duration
+		expectedStatusCode int
+		expectedCtxErr     error
+	}{
+		{
+			name:               "route timeout aborts the query",
+			routeTimeout:       50 * time.Millisecond,
+			clientTimeout:      time.Second,
+			expectedStatusCode: http.StatusGatewayTimeout,
+			expectedCtxErr:     context.DeadlineExceeded,
+		},
+		{
+			name:           "client disconnect aborts the query",
+			routeTimeout:   time.Second,
+			clientTimeout:  50 * time.Millisecond,
+			expectedCtxErr: context.Canceled,
+		},
+	}
+
+	logger := log.New(&log.Config{
+		Context:               "sw-api-test",
+		ConsoleLoggingEnabled: false,
+		EncodeLogsAsJson:      true,
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &test.Stub{Block: true, Cancelled: make(chan error, 1)}
+			h := &APIHandler{
+				IService: stub,
+				Logger:   logger,
+			}
+
+			router := chi.NewRouter()
+			router.With(Timeout(tt.routeTimeout)).Get("/planets", h.FindAllPlanets)
+			mockServer := httptest.NewServer(router)
+			defer mockServer.Close()
+
+			client := &http.Client{Timeout: tt.clientTimeout}
+			resp, err := client.Get(fmt.Sprintf("%s/planets", mockServer.URL))
+			if err == nil {
+				defer resp.Body.Close()
+				assert.Equal(t, tt.expectedStatusCode, resp.StatusCode)
+			}
+
+			select {
+			case ctxErr := <-stub.Cancelled:
+				assert.Equal(t, tt.expectedCtxErr, ctxErr)
+			case <-time.After(time.Second):
+				t.Fatal("query was not cancelled")
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"github.com/gugabfigueiredo/star-wars-api/log"
+	"github.com/gugabfigueiredo/star-wars-api/service"
+	"net/http"
+)
+
+type HealthHandler struct {
+	Service *service.HealthService
+	Logger  *log.Logger
+}
+
+// Live only tells the process is up and serving, it checks no dependency so a database outage does not restart pods
+func (h *HealthHandler) Live(w http.ResponseWriter, r *http.Request) {
+	codec, ok := negotiate(w, r)
+	if !ok {
+		return
+	}
+
+	if err := codec.encode(w, Response{Status: service.StatusOK}); err != nil {
+		h.Logger.E("Error on marshal liveness", "err", err)
+		http.Error(w, "Error on marshal liveness", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *HealthHandler) Ready(w http.ResponseWriter, r *http.Request) {
+	codec, ok := negotiate(w, r)
+	if !ok {
+		return
+	}
+
+	readiness := h.Service.Ready(r.Context())
+	if readiness.Status == service.StatusDown {
+		h.Logger.W("Not ready to serve", "readiness", readiness)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	if err := codec.encode(w, readiness); err != nil {
+		h.Logger.E("Error on marshal readiness", "err", err)
+		http.Error(w, "Error on marshal readiness", http.StatusInternalServerError)
+		return
+	}
+}
@@ -1,7 +1,6 @@
 package handler
 
 import (
-	"encoding/json"
 	"github.com/gugabfigueiredo/star-wars-api/log"
 	"github.com/gugabfigueiredo/star-wars-api/service"
 	"net/http"
@@ -14,16 +13,19 @@ type HelloHandler struct {
 
 type Response struct {
 	Status  string `json:"status"`
-	Message string `json:"message"`
+	Message string `json:"message,omitempty"`
 }
 
 func (h *HelloHandler) SayHello(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+	codec, ok := negotiate(w, r)
+	if !ok {
+		return
+	}
 
 	qParams := r.URL.Query()
 	user := qParams.Get("user")
 
-	logger := h.Logger.C("user", user)
+	logger := log.FromContext(r.Context(), h.Logger).C("user", user)
 
 	// Call service
 	message, err := h.Service.SayHello(user)
@@ -40,7 +42,7 @@ func (h *HelloHandler) SayHello(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Write response
-	if err := json.NewEncoder(w).Encode(response); err != nil {
+	if err := codec.encode(w, response); err != nil {
 		logger.E("error on json encoding", "err", err)
 		http.Error(w, "error while writing response", http.StatusInternalServerError)
 		return
@@ -0,0 +1,25 @@
+package handler
+
+import (
+	"github.com/gugabfigueiredo/star-wars-api/log"
+	"github.com/gugabfigueiredo/star-wars-api/service"
+	"net/http"
+)
+
+type LeaseHandler struct {
+	Service *service.LeaseService
+	Logger  *log.Logger
+}
+
+func (h *LeaseHandler) GetLeaseStatus(w http.ResponseWriter, r *http.Request) {
+	codec, ok := negotiate(w, r)
+	if !ok {
+		return
+	}
+
+	if err := codec.encode(w, h.Service.Status()); err != nil {
+		h.Logger.E("Error on marshal lease status", "err", err)
+		http.Error(w, "Error on marshal lease status", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"errors"
+	"github.com/gugabfigueiredo/star-wars-api/log"
+	"net/http"
+	"strings"
+)
+
+type LogHandler struct {
+	Logger *log.Logger
+}
+
+type LogLevel struct {
+	Level string `json:"level"`
+}
+
+func (h *LogHandler) GetLevel(w http.ResponseWriter, r *http.Request) {
+	codec, ok := negotiate(w, r)
+	if !ok {
+		return
+	}
+
+	if err := codec.encode(w, LogLevel{Level: log.Level()}); err != nil {
+		h.Logger.E("Error on marshal log level", "err", err)
+		http.Error(w, "Error on marshal log level", http.StatusInternalServerError)
+		return
+	}
+}
+
+// SetLevel changes the log level of the running server, until it restarts. Logs cannot be disabled this way.
+func (h *LogHandler) SetLevel(w http.ResponseWriter, r *http.Request) {
+	codec, ok := negotiate(w, r)
+	if !ok {
+		return
+	}
+
+	logger := log.FromContext(r.Context(), h.Logger)
+
+	var level LogLevel
+	if err := decodeBody(r, &level); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errUnsupportedMediaType) {
+			status = http.StatusUnsupportedMediaType
+		}
+		logger.E("Error on unmarshal log level payload", "err", err)
+		http.Error(w, "Error on unmarshal log level payload", status)
+		return
+	}
+
+	// disabling logs would hide who changes the server next, only the settings can do that
+	if strings.EqualFold(level.Level, "disabled") {
+		logger.W("Rejected log level change", "to", level.Level)
+		http.Error(w, "logs cannot be disabled at runtime", http.StatusBadRequest)
+		return
+	}
+
+	previous := log.Level()
+	if err := log.SetLevel(level.Level); err != nil {
+		logger.W("Rejected log level change", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// logged at warn so the change shows up whatever the new level is
+	logger.W("Log level changed", "from", previous, "to", log.Level())
+
+	if err := codec.encode(w, LogLevel{Level: log.Level()}); err != nil {
+		logger.E("Error on marshal log level", "err", err)
+		http.Error(w, "Error on marshal log level", http.StatusInternalServerError)
+		return
+	}
+}
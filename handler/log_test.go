@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"github.com/gugabfigueiredo/star-wars-api/log"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLogHandler(t *testing.T) {
+
+	logger := log.New(&log.Config{
+		Context:               "sw-api-test",
+		ConsoleLoggingEnabled: false,
+		EncodeLogsAsJson:      true,
+	})
+	defer log.SetLevel(log.Level())
+
+	h := &LogHandler{Logger: logger}
+
+	rec := httptest.NewRecorder()
+	h.SetLevel(rec, httptest.NewRequest(http.MethodPut, "/admin/log/level", strings.NewReader(`{"level":"debug"}`)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"level":"debug"}`, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	h.SetLevel(rec, httptest.NewRequest(http.MethodPut, "/admin/log/level", strings.NewReader(`{"level":"verbose"}`)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	h.SetLevel(rec, httptest.NewRequest(http.MethodPut, "/admin/log/level", strings.NewReader(`{"level":"disabled"}`)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	h.GetLevel(rec, httptest.NewRequest(http.MethodGet, "/admin/log/level", nil))
+	assert.JSONEq(t, `{"level":"debug"}`, rec.Body.String())
+}
+
+func TestAdminOnly(t *testing.T) {
+
+	tests := []struct {
+		name           string
+		token          string
+		authorization  string
+		expectedStatus int
+	}{
+		{name: "valid token", token: "s3cr3t", authorization: "Bearer s3cr3t", expectedStatus: http.StatusOK},
+		{name: "invalid token", token: "s3cr3t", authorization: "Bearer guess", expectedStatus: http.StatusUnauthorized},
+		{name: "missing token", token: "s3cr3t", expectedStatus: http.StatusUnauthorized},
+		{name: "no admin token set", authorization: "Bearer ", expectedStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := AdminOnly(&AdminConfig{Token: tt.token})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			req := httptest.NewRequest(http.MethodPut, "/admin/log/level", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+		})
+	}
+}
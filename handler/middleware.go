@@ -0,0 +1,235 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi"
+	"github.com/gugabfigueiredo/star-wars-api/httpx"
+	"github.com/gugabfigueiredo/star-wars-api/log"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+// validRequestID keeps ids sent by clients short and printable before they end up in logs
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// Timeout bounds the request context, so repository calls made by the route are cancelled after timeout
+func Timeout(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+type connKey struct{}
+
+// ConnContext keeps the connection of requests in their context for ConnDeadline, set it as the ConnContext
+// of the server
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// ConnDeadline gives the route timeout to read its request and write its response, in place of the read and
+// write timeouts of the server. The server sets them again for the next request on the connection.
+func ConnDeadline(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if conn, ok := r.Context().Value(connKey{}).(net.Conn); ok {
+				deadline := time.Now().Add(timeout)
+				conn.SetReadDeadline(deadline)
+				conn.SetWriteDeadline(deadline)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// errBodyTooLarge is returned reading request bodies over the limit of their route
+var errBodyTooLarge = errors.New("request body too large")
+
+// MaxBodySize refuses request bodies over limit bytes with 413, 0 leaves them unbounded
+func MaxBodySize(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if limit <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > limit {
+				http.Error(w, fmt.Sprintf("request body is over %d bytes", limit), http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = &limitedBody{ReadCloser: r.Body, remaining: limit}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// limitedBody fails with errBodyTooLarge once more than remaining bytes are read
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, errBodyTooLarge
+	}
+	// read one byte more than allowed to tell a body of exactly the limit from a longer one
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) <= b.remaining {
+		b.remaining -= int64(n)
+		return n, err
+	}
+	n, b.remaining = int(b.remaining), -1
+	return n, errBodyTooLarge
+}
+
+// errorStatus reports requests that ran out of time as timeouts instead of server errors
+func errorStatus(err error) int {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	if errors.Is(err, errUnsupportedMediaType) {
+		return http.StatusUnsupportedMediaType
+	}
+	if errors.Is(err, errBodyTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	if errors.Is(err, errXMLTooDeep) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// RequestLogger accepts the X-Request-ID of the caller or assigns a new one, echoes it in the response,
+// and puts a logger tagged with it in the request context for handlers to pick up with log.FromContext.
+// Once the request is served it writes one access log line with the matched chi route pattern.
+func RequestLogger(logger *log.Logger, config *HTTPConfig) func(http.Handler) http.Handler {
+	// validated with the settings
+	proxies, _ := ParseProxies(config.TrustedProxies)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			started := time.Now()
+
+			requestID := r.Header.Get(RequestIDHeader)
+			if !validRequestID.MatchString(requestID) {
+				requestID = newRequestID()
+			}
+			w.Header().Set(RequestIDHeader, requestID)
+
+			tags := []interface{}{"requestID", requestID}
+			if span := trace.SpanContextFromContext(r.Context()); span.IsValid() {
+				tags = append(tags, "traceID", span.TraceID().String())
+			}
+			requestLogger := logger.C(tags...)
+
+			rw := httpx.NewStatusWriter(w)
+			next.ServeHTTP(rw, r.WithContext(log.NewContext(r.Context(), requestLogger)))
+
+			route := "unmatched"
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				route = rctx.RoutePattern()
+			}
+
+			access := requestLogger.I
+			if rw.Status >= http.StatusInternalServerError {
+				access = requestLogger.E
+			}
+			access("access",
+				"method", r.Method,
+				"route", route,
+				"path", r.URL.Path,
+				"status", rw.Status,
+				"bytes", rw.Bytes,
+				"latency", time.Since(started),
+				"ip", clientIP(r, proxies),
+			)
+		})
+	}
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// ParseProxies reads trusted proxies given as addresses or CIDR ranges
+func ParseProxies(proxies []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("%q is not an address or a CIDR range", proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an address or a CIDR range", proxy)
+		}
+		nets = append(nets, network)
+	}
+	return nets, nil
+}
+
+// clientIP is the address the request came from. Behind trusted proxies it is the last address of
+// X-Forwarded-For that is not one of them, or X-Real-IP, headers from anyone else are ignored.
+func clientIP(r *http.Request, proxies []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !trusted(host, proxies) {
+		return host
+	}
+
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		hops := strings.Split(forwarded, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if !trusted(hop, proxies) || i == 0 {
+				return hop
+			}
+		}
+	}
+	if real := r.Header.Get("X-Real-IP"); real != "" {
+		return real
+	}
+	return host
+}
+
+func trusted(address string, proxies []*net.IPNet) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, proxy := range proxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,272 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/go-chi/chi"
+	"github.com/gugabfigueiredo/star-wars-api/log"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRequestLogger(t *testing.T) {
+
+	var out bytes.Buffer
+	logger := log.New(&log.Config{
+		Context:               "sw-api-test",
+		ConsoleLoggingEnabled: false,
+		EncodeLogsAsJson:      true,
+	})
+	output := logger.Output(&out)
+	logger.Logger = &output
+
+	var requestLoggers []*log.Logger
+	router := chi.NewRouter()
+	router.Use(RequestLogger(logger, &HTTPConfig{TrustedProxies: []string{"192.0.2.1", "10.0.0.0/8"}}))
+	router.Get("/planets/name/{name}", func(w http.ResponseWriter, r *http.Request) {
+		requestLoggers = append(requestLoggers, log.FromContext(r.Context(), nil))
+		w.Write([]byte(`{"name":"Hoth"}`))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/planets/name/Hoth", nil)
+	req.Header.Set(RequestIDHeader, "abc-123")
+	req.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	assert.Equal(t, "abc-123", rec.Header().Get(RequestIDHeader))
+	assert.Len(t, requestLoggers, 1)
+	assert.NotNil(t, requestLoggers[0])
+
+	var access map[string]interface{}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.NoError(t, json.Unmarshal([]byte(lines[len(lines)-1]), &access))
+	assert.Equal(t, "access", access["message"])
+	assert.Equal(t, "abc-123", access["requestID"])
+	assert.Equal(t, "GET", access["method"])
+	assert.Equal(t, "/planets/name/{name}", access["route"])
+	assert.Equal(t, float64(200), access["status"])
+	assert.Equal(t, float64(15), access["bytes"])
+	assert.Equal(t, "203.0.113.7", access["ip"])
+
+	// ids that are too long or not printable are replaced
+	req = httptest.NewRequest(http.MethodGet, "/planets/name/Hoth", nil)
+	req.Header.Set(RequestIDHeader, "bad id\n")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	assert.Regexp(t, "^[0-9a-f]{32}$", rec.Header().Get(RequestIDHeader))
+}
+
+// lockedBuffer takes log lines from concurrent requests
+type lockedBuffer struct {
+	sync.Mutex
+	bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.Buffer.Write(p)
+}
+
+// run with -race, request loggers are children of one shared logger and must not write to it
+func TestRequestLogger_Concurrent(t *testing.T) {
+
+	var out lockedBuffer
+	logger := log.New(&log.Config{
+		Context:               "sw-api-test",
+		ConsoleLoggingEnabled: false,
+		EncodeLogsAsJson:      true,
+	})
+	output := logger.Output(&out)
+	logger.Logger = &output
+
+	router := chi.NewRouter()
+	router.Use(RequestLogger(logger, &HTTPConfig{}))
+	router.Get("/planets/name/{name}", func(w http.ResponseWriter, r *http.Request) {
+		log.FromContext(r.Context(), nil).I("handled", "n", chi.URLParam(r, "name"))
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "/planets/name/"+strconv.Itoa(i), nil)
+			req.Header.Set(RequestIDHeader, "req-"+strconv.Itoa(i))
+			router.ServeHTTP(httptest.NewRecorder(), req)
+		}(i)
+	}
+	wg.Wait()
+	logger.I("done")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 101)
+	for _, line := range lines[:100] {
+		var logged map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(line), &logged))
+		if logged["message"] == "handled" {
+			// a request logger only ever carries its own request id
+			assert.Equal(t, "req-"+logged["n"].(string), logged["requestID"])
+		}
+	}
+	assert.NotContains(t, lines[100], "requestID")
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseProxies([]string{"10.0.0.0/8", "192.0.2.1", "2001:db8::/32"})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor string
+		realIP       string
+		expectedIP   string
+	}{
+		{
+			name:       "direct",
+			remoteAddr: "203.0.113.7:51000",
+			expectedIP: "203.0.113.7",
+		},
+		{
+			name:         "forwarded by an untrusted peer",
+			remoteAddr:   "203.0.113.7:51000",
+			forwardedFor: "198.51.100.1",
+			realIP:       "198.51.100.2",
+			expectedIP:   "203.0.113.7",
+		},
+		{
+			name:         "forwarded by a trusted proxy",
+			remoteAddr:   "10.1.2.3:51000",
+			forwardedFor: "203.0.113.7",
+			expectedIP:   "203.0.113.7",
+		},
+		{
+			name:         "spoofed hops before the last untrusted one",
+			remoteAddr:   "192.0.2.1:51000",
+			forwardedFor: "198.51.100.1, 203.0.113.7, 10.0.0.1",
+			expectedIP:   "203.0.113.7",
+		},
+		{
+			name:         "every hop trusted",
+			remoteAddr:   "10.1.2.3:51000",
+			forwardedFor: "10.0.0.2, 10.0.0.1",
+			expectedIP:   "10.0.0.2",
+		},
+		{
+			name:       "real ip from a trusted proxy",
+			remoteAddr: "[2001:db8::1]:51000",
+			realIP:     "203.0.113.7",
+			expectedIP: "203.0.113.7",
+		},
+		{
+			name:       "trusted proxy without headers",
+			remoteAddr: "10.1.2.3:51000",
+			expectedIP: "10.1.2.3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/planets", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			assert.Equal(t, tt.expectedIP, clientIP(req, proxies))
+		})
+	}
+}
+
+func TestParseProxies(t *testing.T) {
+	_, err := ParseProxies([]string{"10.0.0.0/8", "192.0.2.1", "::1"})
+	assert.NoError(t, err)
+
+	_, err = ParseProxies([]string{"proxy.local"})
+	assert.Error(t, err)
+
+	_, err = ParseProxies([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+}
+
+func TestConnDeadline(t *testing.T) {
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+
+	tests := []struct {
+		name     string
+		handler  http.Handler
+		expected bool
+	}{
+		{name: "server write timeout", handler: slow, expected: false},
+		{name: "route deadline", handler: ConnDeadline(5 * time.Second)(slow), expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewUnstartedServer(tt.handler)
+			server.Config.WriteTimeout = 100 * time.Millisecond
+			server.Config.ConnContext = ConnContext
+			server.Start()
+			defer server.Close()
+
+			res, err := http.Get(server.URL)
+			if !tt.expected {
+				// the connection is closed once the response is written past the deadline
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			defer res.Body.Close()
+			body, _ := ioutil.ReadAll(res.Body)
+			assert.Equal(t, "done", string(body))
+		})
+	}
+}
+
+func TestMaxBodySize(t *testing.T) {
+	tests := []struct {
+		name           string
+		body           string
+		chunked        bool
+		expectedStatus int
+	}{
+		{name: "under the limit", body: `[{"name":"Hoth"}]`, expectedStatus: http.StatusOK},
+		{name: "declared over the limit", body: strings.Repeat(" ", 64) + `[]`, expectedStatus: http.StatusRequestEntityTooLarge},
+		{name: "streamed over the limit", body: strings.Repeat(" ", 64) + `[]`, chunked: true, expectedStatus: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := MaxBodySize(32)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var planets []map[string]string
+				if err := decodeBody(r, &planets); err != nil {
+					http.Error(w, err.Error(), errorStatus(err))
+				}
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/planets/create", strings.NewReader(tt.body))
+			if tt.chunked {
+				req.ContentLength = -1
+			}
+			rec := httptest.NewRecorder()
+			route.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+		})
+	}
+}
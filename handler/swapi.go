@@ -3,6 +3,7 @@ package handler
 import (
 	"github.com/gugabfigueiredo/star-wars-api/log"
 	"github.com/gugabfigueiredo/star-wars-api/service"
+	"net/http"
 )
 
 type SwapiHandler struct {
@@ -10,3 +11,20 @@ type SwapiHandler struct {
 	Logger *log.Logger
 }
 
+func (h *SwapiHandler) GetUpstreamHealth(w http.ResponseWriter, r *http.Request) {
+	codec, ok := negotiate(w, r)
+	if !ok {
+		return
+	}
+
+	health := h.Health()
+	if health.State == service.CircuitOpen {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	if err := codec.encode(w, health); err != nil {
+		h.Logger.E("Error on marshal swapi health", "err", err)
+		http.Error(w, "Error on marshal swapi health", http.StatusInternalServerError)
+		return
+	}
+}
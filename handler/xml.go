@@ -0,0 +1,258 @@
+package handler
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// xml documents are the json form of a value: object keys are elements, array items are item elements,
+// under a response root. Going through json keeps the field names, ids and times of the other formats,
+// and maps that encoding/xml cannot marshal.
+const (
+	xmlRoot  = "response"
+	xmlItem  = "item"
+	xmlEntry = "entry"
+)
+
+// validXMLName matches the keys that can be element names, others are entry elements with a key attribute
+var validXMLName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+func xmlElement(key string) xml.StartElement {
+	if validXMLName.MatchString(key) && !strings.HasPrefix(strings.ToLower(key), "xml") {
+		return xml.StartElement{Name: xml.Name{Local: key}}
+	}
+	return xml.StartElement{Name: xml.Name{Local: xmlEntry}, Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: key}}}
+}
+
+func encodeXML(w io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	if err := writeXML(encoder, decoder, xmlElement(xmlRoot)); err != nil {
+		return err
+	}
+	if err := encoder.Flush(); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// writeXML writes the next json value of d as the element start
+func writeXML(e *xml.Encoder, d *json.Decoder, start xml.StartElement) error {
+	token, err := d.Token()
+	if err != nil {
+		return err
+	}
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+
+	switch token := token.(type) {
+	case json.Delim:
+		for d.More() {
+			element := xml.StartElement{Name: xml.Name{Local: xmlItem}}
+			if token == '{' {
+				key, err := d.Token()
+				if err != nil {
+					return err
+				}
+				element = xmlElement(key.(string))
+			}
+			if err := writeXML(e, d, element); err != nil {
+				return err
+			}
+		}
+		// the closing delimiter
+		if _, err := d.Token(); err != nil {
+			return err
+		}
+	case nil:
+	default:
+		if err := e.EncodeToken(xml.CharData(fmt.Sprint(token))); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// xmlNode is an element of a decoded document, keyed by its name or its key attribute
+type xmlNode struct {
+	key      string
+	text     string
+	children []*xmlNode
+}
+
+// maxXMLDepth caps the nesting of decoded documents, planets are a few levels deep and every level is a call
+// of readXMLNode on the stack
+const maxXMLDepth = 64
+
+// errXMLTooDeep is returned for documents nested deeper than maxXMLDepth
+var errXMLTooDeep = fmt.Errorf("xml document nested deeper than %d elements", maxXMLDepth)
+
+// decodeXML reads a document written like encodeXML into v. Elements are typed by the fields of v,
+// so they are turned back into the json v would be read from.
+func decodeXML(r io.Reader, v interface{}) error {
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			root, err := readXMLNode(decoder, start, 1)
+			if err != nil {
+				return err
+			}
+			data, err := json.Marshal(root.jsonValue(reflect.TypeOf(v)))
+			if err != nil {
+				return err
+			}
+			return json.Unmarshal(data, v)
+		}
+	}
+}
+
+func readXMLNode(d *xml.Decoder, start xml.StartElement, depth int) (*xmlNode, error) {
+	if depth > maxXMLDepth {
+		return nil, errXMLTooDeep
+	}
+	node := &xmlNode{key: start.Name.Local}
+	for _, attr := range start.Attr {
+		if attr.Name.Local == "key" {
+			node.key = attr.Value
+		}
+	}
+
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			child, err := readXMLNode(d, token, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			node.children = append(node.children, child)
+		case xml.CharData:
+			node.text += string(token)
+		case xml.EndElement:
+			return node, nil
+		}
+	}
+}
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// jsonValue is the node as the json value of type t
+func (n *xmlNode) jsonValue(t reflect.Type) interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	text := strings.TrimSpace(n.text)
+
+	// ids and times read themselves from json strings
+	if reflect.PtrTo(t).Implements(jsonUnmarshalerType) || reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		if text == "" {
+			return nil
+		}
+		return text
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return n.text
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		if text == "" {
+			return nil
+		}
+		return json.RawMessage(text)
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return text
+		}
+		items := make([]interface{}, 0, len(n.children))
+		for _, child := range n.children {
+			items = append(items, child.jsonValue(t.Elem()))
+		}
+		return items
+	case reflect.Map:
+		entries := make(map[string]interface{}, len(n.children))
+		for _, child := range n.children {
+			entries[child.key] = child.jsonValue(t.Elem())
+		}
+		return entries
+	case reflect.Struct:
+		fields := make(map[string]interface{}, len(n.children))
+		for _, child := range n.children {
+			if field, ok := jsonField(t, child.key); ok {
+				fields[child.key] = child.jsonValue(field.Type)
+			}
+		}
+		return fields
+	default:
+		if len(n.children) == 0 {
+			return n.text
+		}
+		entries := make(map[string]interface{}, len(n.children))
+		for _, child := range n.children {
+			entries[child.key] = child.jsonValue(t)
+		}
+		return entries
+	}
+}
+
+// jsonField is the field of struct t that json reads key into, matched without case like encoding/json does
+func jsonField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				if found, ok := jsonField(embedded, key); ok {
+					return found, true
+				}
+			}
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if strings.EqualFold(name, key) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
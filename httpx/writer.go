@@ -0,0 +1,34 @@
+// Package httpx holds the http helpers shared by the api handlers and the metrics and tracing middlewares
+package httpx
+
+import "net/http"
+
+// StatusWriter remembers the status and body size written by a handler, for the middlewares that report on
+// responses. It forwards Flush, so streamed responses still go out as they are written.
+type StatusWriter struct {
+	http.ResponseWriter
+	Status int
+	Bytes  int
+}
+
+// NewStatusWriter wraps w, the status is 200 until the handler writes another one
+func NewStatusWriter(w http.ResponseWriter) *StatusWriter {
+	return &StatusWriter{ResponseWriter: w, Status: http.StatusOK}
+}
+
+func (w *StatusWriter) WriteHeader(status int) {
+	w.Status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *StatusWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.Bytes += n
+	return n, err
+}
+
+func (w *StatusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,24 @@
+package httpx
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatusWriter(t *testing.T) {
+
+	rec := httptest.NewRecorder()
+	sw := NewStatusWriter(rec)
+	assert.Equal(t, http.StatusOK, sw.Status)
+
+	sw.WriteHeader(http.StatusAccepted)
+	sw.Write([]byte("first chunk"))
+	// streamed responses reach the client through the wrapper
+	sw.Flush()
+
+	assert.Equal(t, http.StatusAccepted, sw.Status)
+	assert.Equal(t, 11, sw.Bytes)
+	assert.True(t, rec.Flushed)
+}
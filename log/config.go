@@ -6,19 +6,24 @@ import "io"
 // mostly taken from https://gist.github.com/panta/2530672ca641d953ae452ecb5ef79d7d
 
 import (
+	"fmt"
 	"github.com/rs/zerolog"
-	"github.com/rs/zerolog/log"
 	"gopkg.in/natefinch/lumberjack.v2"
 	"os"
 	"path"
+	"strings"
+	"time"
 )
 
 // Config - Configuration for logging
 type Config struct {
  	Context string `default:"sw-api"`
+	// Level is the minimum level logged: trace, debug, info, warn, error, fatal or disabled.
+	// It can be changed at runtime with SetLevel.
+	Level string `default:"info" reload:"true"`
 	// Enable console logging
 	ConsoleLoggingEnabled bool `default:"true"`
-	// EncodeLogsAsJson makes the log framework log JSON
+	// EncodeLogsAsJson makes the console log JSON instead of pretty printed text, files always get JSON
 	EncodeLogsAsJson bool `default:"true"`
 	// FileLoggingEnabled makes the framework log to a file
 	// the fields below can be skipped if this value is false!
@@ -33,6 +38,16 @@ type Config struct {
 	MaxBackups int `default:"10"`
 	// MaxAge the max age in days to keep a logfile
 	MaxAge int `default:"2"`
+	// RedactKeys are field names logged as [REDACTED], matched case-insensitively anywhere in the key and
+	// inside logged structs and maps. Left unset it redacts password, secret, token and authorization.
+	// Values given to them in the text of errors and Stringers are redacted too.
+	RedactKeys []string `default:"password,secret,token,authorization"`
+	// MaxValueSize caps the bytes logged per field value, 0 logs values whole
+	MaxValueSize int `default:"2048"`
+	// SampleBurst is how many debug and info lines are logged per SamplePeriod, 0 logs them all.
+	// Warnings and errors are never sampled.
+	SampleBurst  uint32        `default:"0"`
+	SamplePeriod time.Duration `default:"1s"`
 }
 
 // New sets up the logging framework
@@ -42,48 +57,123 @@ type Config struct {
 // problems in the containerized pipeline
 //
 // The output log file will be located at /var/log/service-xyz/service-xyz.log and
-// will be rolled according to configuration set.
+// will be rolled according to configuration set. If it cannot be written, logging carries on
+// to the console only.
 func New(config *Config) *Logger {
 	var writers []io.Writer
 
 	if config.ConsoleLoggingEnabled {
-		writers = append(writers, zerolog.ConsoleWriter{Out: os.Stderr})
+		if config.EncodeLogsAsJson {
+			writers = append(writers, os.Stderr)
+		} else {
+			writers = append(writers, zerolog.ConsoleWriter{Out: os.Stderr})
+		}
 	}
+
+	var fileErr error
 	if config.FileLoggingEnabled {
-		writers = append(writers, newRollingFile(config))
+		var file io.Writer
+		if file, fileErr = newRollingFile(config); fileErr == nil {
+			writers = append(writers, file)
+		}
 	}
 	mw := io.MultiWriter(writers...)
 
-	// zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	levelErr := SetLevel(config.Level)
+	if levelErr != nil {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	}
+
 	logger := zerolog.New(mw).With().Timestamp().Logger()
 
 	logger.Info().
 		Str("context", config.Context).
-		Bool("fileLogging", config.FileLoggingEnabled).
+		Str("level", Level()).
+		Bool("fileLogging", config.FileLoggingEnabled && fileErr == nil).
 		Bool("jsonLogOutput", config.EncodeLogsAsJson).
 		Str("logDirectory", config.Directory).
 		Str("fileName", config.Filename).
 		Int("maxSizeMB", config.MaxSize).
 		Int("maxBackups", config.MaxBackups).
 		Int("maxAgeInDays", config.MaxAge).
+		Int("maxValueSize", config.MaxValueSize).
+		Uint32("sampleBurst", config.SampleBurst).
 		Msg("logging configured")
 
-	return &Logger{
-		Logger: &logger,
-		context: map[string]interface{}{"context": config.Context},
+	if fileErr != nil {
+		logger.Warn().Err(fileErr).Str("path", config.Directory).Msg("can't write log file, logging to console only")
+	}
+	if levelErr != nil {
+		logger.Warn().Err(levelErr).Msg("logging at info level")
+	}
+
+	if config.SampleBurst > 0 {
+		sampler := &zerolog.BurstSampler{Burst: config.SampleBurst, Period: config.SamplePeriod}
+		logger = logger.Sample(zerolog.LevelSampler{DebugSampler: sampler, InfoSampler: sampler})
+	}
+
+	logger = logger.With().Str("context", config.Context).Logger()
+	return &Logger{Logger: &logger, rules: newRules(config)}
+}
+
+// SetLevel changes the minimum level logged by every logger, e.g. to debug a running server.
+// An empty level means info, an unknown one leaves the level unchanged.
+func SetLevel(level string) error {
+	parsed, err := parseLevel(level)
+	if err != nil {
+		return err
+	}
+	zerolog.SetGlobalLevel(parsed)
+	return nil
+}
+
+// CheckLevel reports whether level is one SetLevel accepts
+func CheckLevel(level string) error {
+	_, err := parseLevel(level)
+	return err
+}
+
+func parseLevel(level string) (zerolog.Level, error) {
+	if level == "" {
+		return zerolog.InfoLevel, nil
 	}
+	parsed, err := zerolog.ParseLevel(strings.ToLower(level))
+	if err != nil || parsed == zerolog.NoLevel {
+		return zerolog.NoLevel, fmt.Errorf("unknown log level %q", level)
+	}
+	return parsed, nil
+}
+
+// Level is the minimum level currently logged
+func Level() string {
+	return zerolog.GlobalLevel().String()
 }
 
-func newRollingFile(config *Config) io.Writer {
-	if err := os.MkdirAll(config.Directory, 0744); err != nil {
-		log.Error().Err(err).Str("path", config.Directory).Msg("can't create log directory")
-		return nil
+// CheckFile reports whether the log file can be written, New falls back to the console otherwise
+func (c *Config) CheckFile() error {
+	_, err := newRollingFile(c)
+	return err
+}
+
+// newRollingFile checks the log file can be written before handing it to lumberjack,
+// which would otherwise fail on every write
+func newRollingFile(config *Config) (io.Writer, error) {
+	dir := path.Join(config.Directory, config.Context)
+	if err := os.MkdirAll(dir, 0744); err != nil {
+		return nil, err
+	}
+
+	filename := path.Join(dir, config.Filename)
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
 	}
+	file.Close()
 
 	return &lumberjack.Logger{
-		Filename:   path.Join(config.Directory, config.Context, config.Filename),
+		Filename:   filename,
 		MaxBackups: config.MaxBackups,		// files
 		MaxSize:    config.MaxSize,			// megabytes
 		MaxAge:     config.MaxAge,			// days
-	}
-}
\ No newline at end of file
+	}, nil
+}
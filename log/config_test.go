@@ -0,0 +1,50 @@
+package log
+
+import (
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestSetLevel(t *testing.T) {
+	defer zerolog.SetGlobalLevel(zerolog.GlobalLevel())
+
+	assert.NoError(t, SetLevel("DEBUG"))
+	assert.Equal(t, "debug", Level())
+
+	assert.Error(t, SetLevel("verbose"))
+	assert.Equal(t, "debug", Level())
+
+	assert.NoError(t, SetLevel(""))
+	assert.Equal(t, "info", Level())
+}
+
+func TestNew_FileLogging(t *testing.T) {
+	defer zerolog.SetGlobalLevel(zerolog.GlobalLevel())
+
+	dir, err := ioutil.TempDir("", "sw-api-log")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := &Config{Context: "sw-api-test", Level: "warn", FileLoggingEnabled: true, Directory: dir, Filename: "test.log"}
+	logger := New(config)
+	logger.I("dropped")
+	logger.W("kept")
+	assert.Equal(t, "warn", Level())
+
+	data, err := ioutil.ReadFile(path.Join(dir, "sw-api-test", "test.log"))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "dropped")
+	assert.Contains(t, string(data), `"message":"kept"`)
+
+	// a directory that cannot be created falls back to console logging
+	blocker := path.Join(dir, "blocker")
+	assert.NoError(t, ioutil.WriteFile(blocker, nil, 0644))
+
+	config = &Config{Context: "sw-api-test", Level: "loud", FileLoggingEnabled: true, Directory: blocker, Filename: "test.log"}
+	assert.NotPanics(t, func() { New(config).W("console only") })
+	assert.Equal(t, "info", Level())
+}
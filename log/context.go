@@ -0,0 +1,18 @@
+package log
+
+import "context"
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying logger, e.g. a logger tagged with the request id
+func NewContext(ctx context.Context, logger *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx, or fallback if there is none
+func FromContext(ctx context.Context, fallback *Logger) *Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*Logger); ok && logger != nil {
+		return logger
+	}
+	return fallback
+}
@@ -5,35 +5,22 @@ import (
 	"github.com/rs/zerolog"
 )
 
+// Logger is immutable: C returns a child with more fields and never changes the logger it is called on,
+// so children can be made and used from concurrent requests.
 type Logger struct {
 	*zerolog.Logger
-	context map[string]interface{}
-	
+	rules *rules
 }
 
+// C returns a child logger that adds tags, as key value pairs, to every line
 func (l *Logger) C(tags ...interface{}) *Logger {
 
 	if len(tags) == 0 {
 		return l
 	}
 
-	if len(tags)%2 == 1 {
-		panic("logger.C: odd argument count")
-	}
-
-	logger := &Logger{
-		Logger: l.Logger,
-		context: l.context,
-	}
-	for i := 0; i < len(tags); i +=2 {
-		tag, ok := tags[i].(string)
-		if !ok {
-			panic(fmt.Sprintf("logging tag is not a string. tag: %v", tag))
-		}
-		logger.context[tag] = tags[i+1]
-	}
-
-	return logger
+	logger := l.With().Fields(l.fields(tags)).Logger()
+	return &Logger{Logger: &logger, rules: l.rules}
 }
 
 func (l *Logger) D(message string, tags ...interface{}) {
@@ -62,13 +49,37 @@ func (l *Logger) I(message string, tags ...interface{}) {
 
 func (l *Logger) chainLog(e *zerolog.Event, message string, tags ...interface{}) {
 
-	if tags != nil {
-		logger := l.C(tags...)
+	// disabled levels and sampled out lines come as nil events, skip redacting their fields
+	if e == nil {
+		return
+	}
 
-		for key, value := range logger.context {
-			e = e.Str(key, fmt.Sprintf("%v", value))
-		}
+	if len(tags) > 0 {
+		e = e.Fields(l.fields(tags))
 	}
 
 	e.Msg(message)
-}
\ No newline at end of file
+}
+
+// fields pairs up tags, keeping the values typed: numbers, booleans, durations and times are logged as such,
+// errors by their message and anything else as json. Values are redacted and truncated by the logger rules.
+func (l *Logger) fields(tags []interface{}) map[string]interface{} {
+
+	if len(tags)%2 == 1 {
+		panic("logger.C: odd argument count")
+	}
+
+	fields := make(map[string]interface{}, len(tags)/2)
+	for i := 0; i < len(tags); i += 2 {
+		tag, ok := tags[i].(string)
+		if !ok {
+			panic(fmt.Sprintf("logging tag is not a string. tag: %v", tags[i]))
+		}
+		if l.rules != nil {
+			fields[tag] = l.rules.apply(tag, tags[i+1])
+			continue
+		}
+		fields[tag] = tags[i+1]
+	}
+	return fields
+}
@@ -0,0 +1,101 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestLogger(out *bytes.Buffer) *Logger {
+	logger := zerolog.New(zerolog.SyncWriter(out)).With().Str("context", "sw-api-test").Logger()
+	return &Logger{Logger: &logger}
+}
+
+func lines(t *testing.T, out *bytes.Buffer) []map[string]interface{} {
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var fields map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(line), &fields))
+		lines = append(lines, fields)
+	}
+	return lines
+}
+
+func TestLogger_C(t *testing.T) {
+
+	var out bytes.Buffer
+	root := newTestLogger(&out)
+
+	child := root.C("name", "Hoth")
+	child.I("child")
+	root.I("root")
+
+	logged := lines(t, &out)
+	assert.Equal(t, "Hoth", logged[0]["name"])
+	assert.Equal(t, "sw-api-test", logged[0]["context"])
+	assert.NotContains(t, logged[1], "name")
+
+	assert.Same(t, root, root.C())
+	assert.Panics(t, func() { root.C("name") })
+	assert.Panics(t, func() { root.C(1, "Hoth") })
+}
+
+func TestLogger_TypedFields(t *testing.T) {
+
+	var out bytes.Buffer
+	logger := newTestLogger(&out)
+
+	logger.I("typed",
+		"status", 200,
+		"ok", true,
+		"ratio", 0.5,
+		"err", errors.New("boom"),
+		"planet", struct {
+			Name string `json:"name"`
+		}{"Hoth"},
+	)
+
+	logged := lines(t, &out)[0]
+	assert.Equal(t, float64(200), logged["status"])
+	assert.Equal(t, true, logged["ok"])
+	assert.Equal(t, 0.5, logged["ratio"])
+	assert.Equal(t, "boom", logged["err"])
+	assert.Equal(t, map[string]interface{}{"name": "Hoth"}, logged["planet"])
+}
+
+// run with -race, children used to write to the map of their parent
+func TestLogger_ConcurrentChildren(t *testing.T) {
+
+	var out bytes.Buffer
+	root := newTestLogger(&out)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			child := root.C("request", i)
+			for j := 0; j < 10; j++ {
+				child.C("step", j).I("working", "n", i, "at", time.Duration(j))
+			}
+		}(i)
+	}
+	wg.Wait()
+	root.I("done")
+
+	logged := lines(t, &out)
+	assert.Len(t, logged, 1001)
+	for _, line := range logged[:1000] {
+		// a child only ever carries its own request
+		assert.Equal(t, line["n"], line["request"])
+		assert.Contains(t, line, "step")
+	}
+	assert.NotContains(t, logged[1000], "request")
+	assert.NotContains(t, logged[1000], "step")
+}
@@ -0,0 +1,263 @@
+// Package metrics exposes prometheus metrics for http requests, repository operations, the planet cache
+// and the swapi sync
+package metrics
+
+import (
+	"github.com/go-chi/chi"
+	"github.com/gugabfigueiredo/star-wars-api/cache"
+	"github.com/gugabfigueiredo/star-wars-api/httpx"
+	"github.com/gugabfigueiredo/star-wars-api/model"
+	"github.com/gugabfigueiredo/star-wars-api/service"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const namespace = "swapi"
+
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	httpRequests *prometheus.CounterVec
+	httpDuration *prometheus.HistogramVec
+
+	repoDuration *prometheus.HistogramVec
+	repoErrors   *prometheus.CounterVec
+
+	upstreamDuration *prometheus.HistogramVec
+
+	syncDuration    *prometheus.HistogramVec
+	syncPlanets     prometheus.Counter
+	syncConflicts   prometheus.Counter
+	syncLastSuccess prometheus.Gauge
+
+	cacheRequests *prometheus.CounterVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "HTTP requests by method, chi route pattern and status code.",
+		}, []string{"method", "route", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "HTTP request latency by method, chi route pattern and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		repoDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "repository",
+			Name:      "operation_duration_seconds",
+			Help:      "Repository operation latency by method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method"}),
+		repoErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "repository",
+			Name:      "operation_errors_total",
+			Help:      "Failed repository operations by method.",
+		}, []string{"method"}),
+		upstreamDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "upstream",
+			Name:      "call_duration_seconds",
+			Help:      "Latency of calls to swapi by result.",
+			Buckets:   []float64{.1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+		}, []string{"result"}),
+		syncDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "sync",
+			Name:      "duration_seconds",
+			Help:      "Duration of swapi syncs by result.",
+			Buckets:   []float64{.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+		}, []string{"result"}),
+		syncPlanets: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "sync",
+			Name:      "planets_written_total",
+			Help:      "Planets upserted or modified by swapi syncs.",
+		}),
+		syncConflicts: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "sync",
+			Name:      "field_conflicts_total",
+			Help:      "Planet fields where manual edits and swapi disagreed during syncs.",
+		}),
+		syncLastSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "sync",
+			Name:      "last_success_timestamp_seconds",
+			Help:      "Unix time of the last successful swapi sync.",
+		}),
+		cacheRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "cache",
+			Name:      "requests_total",
+			Help:      "Cached repository reads by method and result: hit, miss, or shared with a concurrent miss.",
+		}, []string{"method", "result"}),
+	}
+
+	m.Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests, m.httpDuration,
+		m.repoDuration, m.repoErrors,
+		m.upstreamDuration,
+		m.syncDuration, m.syncPlanets, m.syncConflicts, m.syncLastSuccess,
+		m.cacheRequests,
+	)
+	return m
+}
+
+// Handler serves the registry in the prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{})
+}
+
+// Middleware records request counts and latency by the matched chi route pattern, so path parameters
+// do not blow up label cardinality
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		sw := httpx.NewStatusWriter(w)
+
+		next.ServeHTTP(sw, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := strconv.Itoa(sw.Status)
+		m.httpRequests.WithLabelValues(r.Method, route, status).Inc()
+		m.httpDuration.WithLabelValues(r.Method, route, status).Observe(time.Since(started).Seconds())
+	})
+}
+
+// ObserveSync implements service.ISyncObserver
+func (m *Metrics) ObserveSync(report *model.SyncReport, duration time.Duration, err error) {
+	if err != nil {
+		m.syncDuration.WithLabelValues("error").Observe(duration.Seconds())
+		return
+	}
+	m.syncDuration.WithLabelValues("success").Observe(duration.Seconds())
+	m.syncPlanets.Add(float64(report.Modified + report.Upserted))
+	m.syncConflicts.Add(float64(len(report.Conflicts)))
+	m.syncLastSuccess.Set(float64(report.FinishedAt.Unix()))
+}
+
+// ObserveCache implements cache.IObserver
+func (m *Metrics) ObserveCache(method, result string) {
+	m.cacheRequests.WithLabelValues(method, result).Inc()
+}
+
+// WatchCache exposes how many reads the planet cache holds
+func (m *Metrics) WatchCache(c *cache.Repo) {
+	m.Registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "cache",
+			Name:      "entries",
+			Help:      "Reads held by the planet cache.",
+		}, func() float64 { return float64(c.Len()) }),
+	)
+}
+
+// WatchScheduler exposes whether the scheduled sync is running and whether a sync is in progress
+func (m *Metrics) WatchScheduler(api *service.APIService) {
+	m.Registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "sync",
+			Name:      "scheduler_running",
+			Help:      "Whether the scheduled swapi sync is running on this replica.",
+		}, func() float64 { return boolFloat(api.Scheduled()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "sync",
+			Name:      "in_progress",
+			Help:      "Whether a swapi sync is in progress on this replica.",
+		}, func() float64 { return boolFloat(api.Syncing()) }),
+	)
+}
+
+// WatchLease exposes who holds the sync lease, read from the lease service on every scrape
+func (m *Metrics) WatchLease(lease *service.LeaseService) {
+	m.Registry.MustRegister(&leaseCollector{lease: lease})
+}
+
+// WatchSwapi exposes the state of the swapi circuit breaker
+func (m *Metrics) WatchSwapi(swapi *service.SwapiService) {
+	m.Registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "upstream",
+			Name:      "circuit_open",
+			Help:      "Whether the swapi circuit breaker is open (1), half-open (0.5) or closed (0).",
+		}, func() float64 {
+			switch swapi.Health().State {
+			case service.CircuitOpen:
+				return 1
+			case service.CircuitHalfOpen:
+				return 0.5
+			}
+			return 0
+		}),
+	)
+}
+
+type leaseCollector struct {
+	lease *service.LeaseService
+}
+
+var (
+	leaseLeaderDesc = prometheus.NewDesc(namespace+"_lease_leader",
+		"Whether this replica holds the sync lease.", []string{"lease", "holder"}, nil)
+	leaseHolderDesc = prometheus.NewDesc(namespace+"_lease_current_holder",
+		"Replica currently holding the sync lease, as seen by this replica.", []string{"lease", "current_holder"}, nil)
+	leaseExpiryDesc = prometheus.NewDesc(namespace+"_lease_expiry_timestamp_seconds",
+		"Unix time the known sync lease expires.", []string{"lease"}, nil)
+	leaseEventsDesc = prometheus.NewDesc(namespace+"_lease_events_total",
+		"Sync lease acquisitions, renewals, losses and failures of this replica.", []string{"lease", "event"}, nil)
+)
+
+func (c *leaseCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- leaseLeaderDesc
+	ch <- leaseHolderDesc
+	ch <- leaseExpiryDesc
+	ch <- leaseEventsDesc
+}
+
+func (c *leaseCollector) Collect(ch chan<- prometheus.Metric) {
+	status := c.lease.Status()
+	ch <- prometheus.MustNewConstMetric(leaseLeaderDesc, prometheus.GaugeValue, boolFloat(status.Leader), status.Name, status.Holder)
+	if status.CurrentHolder != "" {
+		ch <- prometheus.MustNewConstMetric(leaseHolderDesc, prometheus.GaugeValue, 1, status.Name, status.CurrentHolder)
+	}
+	if !status.ExpiresAt.IsZero() {
+		ch <- prometheus.MustNewConstMetric(leaseExpiryDesc, prometheus.GaugeValue, float64(status.ExpiresAt.Unix()), status.Name)
+	}
+	for event, count := range map[string]int64{
+		"acquisition": status.Acquisitions,
+		"renewal":     status.Renewals,
+		"loss":        status.Losses,
+		"failure":     status.Failures,
+	} {
+		ch <- prometheus.MustNewConstMetric(leaseEventsDesc, prometheus.CounterValue, float64(count), status.Name, event)
+	}
+}
+
+func boolFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
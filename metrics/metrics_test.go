@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/chi"
+	"github.com/gugabfigueiredo/star-wars-api/cache"
+	"github.com/gugabfigueiredo/star-wars-api/model"
+	"github.com/gugabfigueiredo/star-wars-api/test"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMetrics_Middleware(t *testing.T) {
+
+	m := New()
+
+	router := chi.NewRouter()
+	router.Use(m.Middleware)
+	router.Get("/planets/name/{name}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	})
+	router.Get("/planets/id/{planetID}", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	})
+
+	for _, path := range []string{"/planets/name/Hoth", "/planets/name/Naboo", "/planets/id/1", "/nowhere"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.httpRequests.WithLabelValues("GET", "/planets/name/{name}", "200")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.httpRequests.WithLabelValues("GET", "/planets/id/{planetID}", "500")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.httpRequests.WithLabelValues("GET", "unmatched", "404")))
+}
+
+func TestMetrics_Repo(t *testing.T) {
+
+	m := New()
+
+	stub := &test.Stub{}
+	repo := m.Repo(stub)
+
+	var planet model.Planet
+	repo.GetPlanet(context.Background(), nil, &planet)
+
+	stub.Error = mongo.ErrNoDocuments
+	repo.GetPlanet(context.Background(), nil, &planet)
+
+	stub.Error = errors.New("connection reset")
+	repo.GetPlanet(context.Background(), nil, &planet)
+	repo.DeletePlanets(context.Background(), nil)
+
+	assert.Equal(t, 2, testutil.CollectAndCount(m.repoDuration))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.repoErrors.WithLabelValues("GetPlanet")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.repoErrors.WithLabelValues("DeletePlanets")))
+}
+
+func TestMetrics_Cache(t *testing.T) {
+
+	m := New()
+
+	stub := &test.Stub{Planet: &model.Planet{Name: "Hoth"}}
+	repo := cache.New(stub, &cache.Config{Size: 10, TTL: time.Minute}, m)
+	m.WatchCache(repo)
+
+	var planet model.Planet
+	repo.GetPlanet(context.Background(), bson.M{"name": "Hoth"}, &planet)
+	repo.GetPlanet(context.Background(), bson.M{"name": "Hoth"}, &planet)
+	repo.GetPlanet(context.Background(), bson.M{"name": "Naboo"}, &planet)
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.cacheRequests.WithLabelValues("GetPlanet", cache.ResultHit)))
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.cacheRequests.WithLabelValues("GetPlanet", cache.ResultMiss)))
+	assert.Equal(t, 2, repo.Len())
+}
@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"context"
+	"github.com/gugabfigueiredo/star-wars-api/model"
+	"github.com/gugabfigueiredo/star-wars-api/repository"
+	"github.com/gugabfigueiredo/swapi"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
+)
+
+// Repo decorates an IRepo with latency and error metrics per method
+func (m *Metrics) Repo(repo repository.IRepo) repository.IRepo {
+	return &instrumentedRepo{IRepo: repo, metrics: m}
+}
+
+type instrumentedRepo struct {
+	repository.IRepo
+	metrics *Metrics
+}
+
+func (r *instrumentedRepo) observe(method string, started time.Time, err error) {
+	r.metrics.repoDuration.WithLabelValues(method).Observe(time.Since(started).Seconds())
+	// a planet that is not found is an answer, not a failure
+	if err != nil && err != mongo.ErrNoDocuments {
+		r.metrics.repoErrors.WithLabelValues(method).Inc()
+	}
+}
+
+func (r *instrumentedRepo) GetPlanet(ctx context.Context, filter interface{}, planet *model.Planet) (err error) {
+	started := time.Now()
+	defer func() { r.observe("GetPlanet", started, err) }()
+	return r.IRepo.GetPlanet(ctx, filter, planet)
+}
+
+func (r *instrumentedRepo) GetAllPlanets(ctx context.Context) (planets []*model.Planet, err error) {
+	started := time.Now()
+	defer func() { r.observe("GetAllPlanets", started, err) }()
+	return r.IRepo.GetAllPlanets(ctx)
+}
+
+func (r *instrumentedRepo) FindPlanets(ctx context.Context, query model.PlanetQuery) (planets []*model.Planet, err error) {
+	started := time.Now()
+	defer func() { r.observe("FindPlanets", started, err) }()
+	return r.IRepo.FindPlanets(ctx, query)
+}
+
+func (r *instrumentedRepo) InsertPlanets(ctx context.Context, planets []model.Planet) (res *mongo.InsertManyResult, err error) {
+	started := time.Now()
+	defer func() { r.observe("InsertPlanets", started, err) }()
+	return r.IRepo.InsertPlanets(ctx, planets)
+}
+
+func (r *instrumentedRepo) UpdatePlanets(ctx context.Context, planets []model.Planet) (res *mongo.BulkWriteResult, err error) {
+	started := time.Now()
+	defer func() { r.observe("UpdatePlanets", started, err) }()
+	return r.IRepo.UpdatePlanets(ctx, planets)
+}
+
+func (r *instrumentedRepo) UpsertPlanets(ctx context.Context, planets []model.Planet) (res *mongo.BulkWriteResult, err error) {
+	started := time.Now()
+	defer func() { r.observe("UpsertPlanets", started, err) }()
+	return r.IRepo.UpsertPlanets(ctx, planets)
+}
+
+func (r *instrumentedRepo) StreamPlanets(ctx context.Context, fn func(*model.Planet) error) (err error) {
+	started := time.Now()
+	defer func() { r.observe("StreamPlanets", started, err) }()
+	return r.IRepo.StreamPlanets(ctx, fn)
+}
+
+func (r *instrumentedRepo) UpdateMovieRefs(ctx context.Context, planets []swapi.Planet, policy model.MergePolicy) (res *mongo.BulkWriteResult, conflicts []model.FieldConflict, err error) {
+	started := time.Now()
+	defer func() { r.observe("UpdateMovieRefs", started, err) }()
+	return r.IRepo.UpdateMovieRefs(ctx, planets, policy)
+}
+
+func (r *instrumentedRepo) DeletePlanets(ctx context.Context, planets []model.Planet) (res *mongo.DeleteResult, err error) {
+	started := time.Now()
+	defer func() { r.observe("DeletePlanets", started, err) }()
+	return r.IRepo.DeletePlanets(ctx, planets)
+}
+
+func (r *instrumentedRepo) LastModified(ctx context.Context) (modified time.Time, err error) {
+	started := time.Now()
+	defer func() { r.observe("LastModified", started, err) }()
+	return r.IRepo.LastModified(ctx)
+}
@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"context"
+	"github.com/gugabfigueiredo/star-wars-api/service"
+	"github.com/gugabfigueiredo/swapi"
+	"time"
+)
+
+// Swapi decorates an ISwapi with call latency metrics, wrap the raw upstream to observe every retry
+func (m *Metrics) Swapi(upstream service.ISwapi) service.ISwapi {
+	return &instrumentedSwapi{ISwapi: upstream, metrics: m}
+}
+
+type instrumentedSwapi struct {
+	service.ISwapi
+	metrics *Metrics
+}
+
+func (s *instrumentedSwapi) AllPlanets(ctx context.Context) ([]swapi.Planet, error) {
+	started := time.Now()
+	planets, err := s.ISwapi.AllPlanets(ctx)
+	s.metrics.observeUpstream(started, err)
+	return planets, err
+}
+
+// Related decorates the source of films and people with the same call latency metrics as planets
+func (m *Metrics) Related(upstream service.IRelated) service.IRelated {
+	return &instrumentedRelated{IRelated: upstream, metrics: m}
+}
+
+type instrumentedRelated struct {
+	service.IRelated
+	metrics *Metrics
+}
+
+func (s *instrumentedRelated) Films(ctx context.Context, urls []string) (map[string]swapi.Film, error) {
+	started := time.Now()
+	films, err := s.IRelated.Films(ctx, urls)
+	s.metrics.observeUpstream(started, err)
+	return films, err
+}
+
+func (s *instrumentedRelated) People(ctx context.Context, urls []string) (map[string]swapi.Person, error) {
+	started := time.Now()
+	people, err := s.IRelated.People(ctx, urls)
+	s.metrics.observeUpstream(started, err)
+	return people, err
+}
+
+func (m *Metrics) observeUpstream(started time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	m.upstreamDuration.WithLabelValues(result).Observe(time.Since(started).Seconds())
+}
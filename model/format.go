@@ -0,0 +1,365 @@
+package model
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Format is a file format for planets, used to import and export them in bulk
+type Format string
+
+const (
+	FormatJSON   Format = "json"
+	FormatNDJSON Format = "ndjson"
+	FormatCSV    Format = "csv"
+)
+
+// csvHeader are the columns of a planet csv file, in the order they are written
+var csvHeader = []string{"id", "name", "climate", "terrain", "refs"}
+
+// ErrMalformed is wrapped by decoding errors that stop the rest of the planets from being read
+var ErrMalformed = errors.New("malformed planets")
+
+// RowError is a planet that could not be decoded or written, the other rows carry on
+type RowError struct {
+	// Row is the line of an ndjson file, the record after the header of a csv file or the index from 1 of a json array
+	Row     int    `json:"row"`
+	Message string `json:"error"`
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("row %d: %s", e.Row, e.Message)
+}
+
+// ImportReport sums up a bulk import, rows listed in Errors were skipped
+type ImportReport struct {
+	Format   Format `json:"format"`
+	Upsert   bool   `json:"upsert"`
+	Rows     int    `json:"rows"`
+	Inserted int64  `json:"inserted"`
+	Matched  int64  `json:"matched"`
+	Modified int64  `json:"modified"`
+	Upserted int64  `json:"upserted"`
+	Failed   int    `json:"failed"`
+	// Errors lists the first failed rows, Failed counts them all
+	Errors []RowError `json:"errors"`
+	// Error stopped the import before every row was read, rows until then were imported
+	Error string `json:"error,omitempty"`
+}
+
+func ParseFormat(value string) (Format, error) {
+	switch format := Format(strings.ToLower(value)); format {
+	case FormatJSON, FormatNDJSON, FormatCSV:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown planet format %q, use json, ndjson or csv", value)
+	}
+}
+
+// FormatOf picks the format of a file from its extension: .json, .ndjson, .jsonl or .csv
+func FormatOf(path string) (Format, error) {
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".jsonl":
+		return FormatNDJSON, nil
+	case "":
+		return "", fmt.Errorf("cannot tell the planet format of %s, give it a .json, .ndjson or .csv extension", path)
+	default:
+		return ParseFormat(strings.TrimPrefix(ext, "."))
+	}
+}
+
+// PlanetDecoder reads planets one at a time, so a large file is never held in memory
+type PlanetDecoder struct {
+	format Format
+	row    int
+
+	lines *bufio.Reader
+	json  *json.Decoder
+	csv   *csv.Reader
+	// columns maps csv column names to their index in a record
+	columns map[string]int
+}
+
+// NewPlanetDecoder starts reading planets in format from r, checking the csv header or the opening of a json array
+func NewPlanetDecoder(r io.Reader, format Format) (*PlanetDecoder, error) {
+	d := &PlanetDecoder{format: format}
+	switch format {
+	case FormatJSON:
+		d.json = json.NewDecoder(r)
+		if token, err := d.json.Token(); err != nil || token != json.Delim('[') {
+			return nil, fmt.Errorf("%w: json planets must be an array", ErrMalformed)
+		}
+	case FormatNDJSON:
+		d.lines = bufio.NewReader(r)
+	case FormatCSV:
+		d.csv = csv.NewReader(r)
+		d.csv.TrimLeadingSpace = true
+		d.csv.FieldsPerRecord = -1
+		if err := d.readHeader(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown planet format %q", format)
+	}
+	return d, nil
+}
+
+func (d *PlanetDecoder) readHeader() error {
+	header, err := d.csv.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%w: error reading csv header: %s", ErrMalformed, err)
+	}
+	d.columns = map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		known := false
+		for _, column := range csvHeader {
+			known = known || name == column
+		}
+		if !known {
+			return fmt.Errorf("%w: unknown csv column %q, use %s", ErrMalformed, name, strings.Join(csvHeader, ", "))
+		}
+		d.columns[name] = i
+	}
+	if _, ok := d.columns["name"]; !ok {
+		return fmt.Errorf("%w: csv header has no name column", ErrMalformed)
+	}
+	return nil
+}
+
+func (d *PlanetDecoder) Format() Format {
+	return d.format
+}
+
+// Row is the row of the planet last decoded
+func (d *PlanetDecoder) Row() int {
+	return d.row
+}
+
+// Decode returns the next planet, or io.EOF after the last one. A row that cannot be decoded returns
+// a *RowError and decoding can carry on with the next row, any other error ends it.
+func (d *PlanetDecoder) Decode() (Planet, error) {
+	var planet Planet
+	var err error
+	switch d.format {
+	case FormatJSON:
+		planet, err = d.decodeJSON()
+	case FormatNDJSON:
+		planet, err = d.decodeNDJSON()
+	case FormatCSV:
+		planet, err = d.decodeCSV()
+	}
+	if err != nil {
+		return planet, err
+	}
+	if planet.Name == "" {
+		return planet, &RowError{Row: d.row, Message: "planet has no name"}
+	}
+	return planet, nil
+}
+
+func (d *PlanetDecoder) decodeJSON() (Planet, error) {
+	var planet Planet
+	if !d.json.More() {
+		if token, err := d.json.Token(); err != nil || token != json.Delim(']') {
+			return planet, fmt.Errorf("%w: json array is not closed", ErrMalformed)
+		}
+		return planet, io.EOF
+	}
+	d.row++
+	err := d.json.Decode(&planet)
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &typeErr):
+		// the value was read whole, the next one can still be decoded
+		return planet, &RowError{Row: d.row, Message: err.Error()}
+	case err != nil:
+		return planet, fmt.Errorf("%w: row %d: %s", ErrMalformed, d.row, err)
+	}
+	return planet, nil
+}
+
+func (d *PlanetDecoder) decodeNDJSON() (Planet, error) {
+	var planet Planet
+	for {
+		line, err := d.lines.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return planet, err
+		}
+		if len(line) == 0 && err == io.EOF {
+			return planet, io.EOF
+		}
+		d.row++
+		if line = bytes.TrimSpace(line); len(line) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(line, &planet); err != nil {
+			return planet, &RowError{Row: d.row, Message: err.Error()}
+		}
+		return planet, nil
+	}
+}
+
+func (d *PlanetDecoder) decodeCSV() (Planet, error) {
+	var planet Planet
+	if d.columns == nil {
+		return planet, io.EOF
+	}
+	record, err := d.csv.Read()
+	if err == io.EOF {
+		return planet, io.EOF
+	}
+	d.row++
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return planet, &RowError{Row: d.row, Message: parseErr.Err.Error()}
+	}
+	if err != nil {
+		return planet, err
+	}
+
+	value := func(column string) string {
+		if i, ok := d.columns[column]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+
+	planet = Planet{Name: value("name"), Climate: value("climate"), Terrain: value("terrain")}
+	if id := value("id"); id != "" {
+		if planet.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+			return planet, &RowError{Row: d.row, Message: fmt.Sprintf("invalid id %q", id)}
+		}
+	}
+	if refs := value("refs"); refs != "" {
+		if planet.Refs, err = strconv.Atoi(refs); err != nil {
+			return planet, &RowError{Row: d.row, Message: fmt.Sprintf("invalid refs %q", refs)}
+		}
+	}
+	return planet, nil
+}
+
+// DecodePlanets reads all planets written by EncodePlanets, or by hand, stopping at the first row that
+// cannot be decoded. Csv files need a header row naming their columns, in any order.
+func DecodePlanets(r io.Reader, format Format) ([]Planet, error) {
+	decoder, err := NewPlanetDecoder(r, format)
+	if err != nil {
+		return nil, err
+	}
+	var planets []Planet
+	for {
+		planet, err := decoder.Decode()
+		if err == io.EOF {
+			return planets, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		planets = append(planets, planet)
+	}
+}
+
+// PlanetEncoder writes planets one at a time, so a large listing is never held in memory.
+// Close must be called to end the document.
+type PlanetEncoder struct {
+	format Format
+	w      *bufio.Writer
+	csv    *csv.Writer
+	count  int
+}
+
+func NewPlanetEncoder(w io.Writer, format Format) (*PlanetEncoder, error) {
+	e := &PlanetEncoder{format: format, w: bufio.NewWriter(w)}
+	switch format {
+	case FormatJSON, FormatNDJSON:
+	case FormatCSV:
+		e.csv = csv.NewWriter(e.w)
+	default:
+		return nil, fmt.Errorf("unknown planet format %q", format)
+	}
+	return e, nil
+}
+
+// Count is how many planets were encoded so far
+func (e *PlanetEncoder) Count() int {
+	return e.count
+}
+
+// Encode writes planet. Nothing reaches the underlying writer before the first planet, or Close,
+// so an error found before any planet can still be reported instead.
+func (e *PlanetEncoder) Encode(planet *Planet) error {
+	e.count++
+	switch e.format {
+	case FormatCSV:
+		if e.count == 1 {
+			if err := e.csv.Write(csvHeader); err != nil {
+				return err
+			}
+		}
+		id := ""
+		if !planet.ID.IsZero() {
+			id = planet.ID.Hex()
+		}
+		if err := e.csv.Write([]string{id, planet.Name, planet.Climate, planet.Terrain, strconv.Itoa(planet.Refs)}); err != nil {
+			return err
+		}
+		e.csv.Flush()
+		return e.csv.Error()
+	default:
+		data, err := json.Marshal(planet)
+		if err != nil {
+			return err
+		}
+		switch {
+		case e.format == FormatNDJSON:
+			data = append(data, '\n')
+		case e.count == 1:
+			e.w.WriteByte('[')
+		default:
+			e.w.WriteByte(',')
+		}
+		_, err = e.w.Write(data)
+		return err
+	}
+}
+
+// Close ends the document, the closing bracket of a json array or the header of an empty csv file,
+// and flushes what is buffered
+func (e *PlanetEncoder) Close() error {
+	switch {
+	case e.format == FormatJSON && e.count == 0:
+		e.w.WriteString("[]\n")
+	case e.format == FormatJSON:
+		e.w.WriteString("]\n")
+	case e.format == FormatCSV && e.count == 0:
+		e.csv.Write(csvHeader)
+		e.csv.Flush()
+	}
+	return e.w.Flush()
+}
+
+// EncodePlanets writes planets in format. Json is a single array, ndjson a planet per line and csv has a header row.
+func EncodePlanets(w io.Writer, format Format, planets []*Planet) error {
+	encoder, err := NewPlanetEncoder(w, format)
+	if err != nil {
+		return err
+	}
+	for _, planet := range planets {
+		if err := encoder.Encode(planet); err != nil {
+			return err
+		}
+	}
+	return encoder.Close()
+}
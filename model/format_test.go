@@ -0,0 +1,116 @@
+package model
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestEncodeDecodePlanets(t *testing.T) {
+
+	planets := []*Planet{
+		{ID: primitive.NewObjectID(), Name: "Hoth", Climate: "frozen", Terrain: "tundra, ice caves", Refs: 1},
+		{Name: "Naboo", Climate: "temperate", Refs: 4},
+	}
+
+	for _, format := range []Format{FormatJSON, FormatNDJSON, FormatCSV} {
+		t.Run(string(format), func(t *testing.T) {
+			var buf bytes.Buffer
+			assert.NoError(t, EncodePlanets(&buf, format, planets))
+
+			decoded, err := DecodePlanets(&buf, format)
+			assert.NoError(t, err)
+			assert.Len(t, decoded, 2)
+			for i := range decoded {
+				assert.Equal(t, *planets[i], decoded[i])
+			}
+		})
+	}
+}
+
+func TestDecodePlanets_CSV(t *testing.T) {
+
+	planets, err := DecodePlanets(strings.NewReader("Name, Refs\nTatooine, 5\nDagobah,\n"), FormatCSV)
+	assert.NoError(t, err)
+	assert.Equal(t, []Planet{{Name: "Tatooine", Refs: 5}, {Name: "Dagobah"}}, planets)
+
+	_, err = DecodePlanets(strings.NewReader("name,population\nTatooine,200000\n"), FormatCSV)
+	assert.EqualError(t, err, `malformed planets: unknown csv column "population", use id, name, climate, terrain, refs`)
+
+	_, err = DecodePlanets(strings.NewReader("name,refs\nTatooine,5\nHoth,many\n"), FormatCSV)
+	assert.EqualError(t, err, `row 2: invalid refs "many"`)
+}
+
+func TestPlanetDecoder_RowErrors(t *testing.T) {
+
+	tests := []struct {
+		format   Format
+		body     string
+		expected []string
+		errors   []RowError
+	}{
+		{
+			format:   FormatNDJSON,
+			body:     "{\"Name\":\"Hoth\"}\n{\"Name\":\n\n{\"Climate\":\"arid\"}\n{\"Name\":\"Naboo\"}",
+			expected: []string{"Hoth", "Naboo"},
+			errors:   []RowError{{Row: 2, Message: "unexpected end of JSON input"}, {Row: 4, Message: "planet has no name"}},
+		},
+		{
+			format:   FormatCSV,
+			body:     "name,refs\nHoth,1\nBespin,lots\n\"Endor,2\nNaboo,4\n",
+			expected: []string{"Hoth"},
+			errors:   []RowError{{Row: 2, Message: `invalid refs "lots"`}, {Row: 3, Message: "extraneous or missing \" in quoted-field"}},
+		},
+		{
+			format:   FormatJSON,
+			body:     `[{"Name":"Hoth"},{"Name":7},{"Name":"Naboo"}]`,
+			expected: []string{"Hoth", "Naboo"},
+			errors:   []RowError{{Row: 2, Message: "json: cannot unmarshal number into Go struct field Planet.Name of type string"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			decoder, err := NewPlanetDecoder(strings.NewReader(tt.body), tt.format)
+			assert.NoError(t, err)
+
+			var names []string
+			var rowErrors []RowError
+			for {
+				planet, err := decoder.Decode()
+				if err == io.EOF {
+					break
+				}
+				if rowErr, ok := err.(*RowError); ok {
+					rowErrors = append(rowErrors, *rowErr)
+					continue
+				}
+				assert.NoError(t, err)
+				names = append(names, planet.Name)
+			}
+			assert.Equal(t, tt.expected, names)
+			assert.Equal(t, tt.errors, rowErrors)
+		})
+	}
+
+	_, err := DecodePlanets(strings.NewReader(`[{"Name":"Hoth"},{"Name"`), FormatJSON)
+	assert.True(t, errors.Is(err, ErrMalformed))
+}
+
+func TestFormatOf(t *testing.T) {
+
+	for path, expected := range map[string]Format{"planets.json": FormatJSON, "dump.JSONL": FormatNDJSON, "a/b.ndjson": FormatNDJSON, "x.csv": FormatCSV} {
+		format, err := FormatOf(path)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, format)
+	}
+
+	_, err := FormatOf("planets.xml")
+	assert.Error(t, err)
+	_, err = FormatOf("planets")
+	assert.Error(t, err)
+}
@@ -0,0 +1,12 @@
+package model
+
+import "time"
+
+// Lease is a time bound ownership record, used to elect a single replica to run a job
+type Lease struct {
+	Name       string    `bson:"_id" json:"name"`
+	Holder     string    `bson:"holder" json:"holder"`
+	AcquiredAt time.Time `bson:"acquiredAt" json:"acquiredAt"`
+	RenewedAt  time.Time `bson:"renewedAt" json:"renewedAt"`
+	ExpiresAt  time.Time `bson:"expiresAt" json:"expiresAt"`
+}
@@ -0,0 +1,177 @@
+package model
+
+import (
+	"fmt"
+	"github.com/gugabfigueiredo/swapi"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"reflect"
+	"time"
+)
+
+// MergePolicy decides which value wins when swapi and a manual edit disagree on a planet field
+type MergePolicy string
+
+const (
+	// UpstreamWins overwrites every field with swapi data
+	UpstreamWins MergePolicy = "upstream-wins"
+	// LocalWins keeps fields that were edited manually
+	LocalWins MergePolicy = "local-wins"
+	// FillEmpty only writes fields that have no value yet
+	FillEmpty MergePolicy = "fill-empty"
+)
+
+// Provenance of a planet field, stored in Planet.Sources by bson field name
+const (
+	SourceManual = "manual"
+	SourceSwapi  = "swapi"
+)
+
+const (
+	ResolutionOverridden = "overridden"
+	ResolutionKept       = "kept"
+)
+
+// FieldConflict is a field where the stored value and swapi disagree during a sync
+type FieldConflict struct {
+	Planet     string `json:"planet"`
+	Field      string `json:"field"`
+	Source     string `json:"source"`
+	Local      string `json:"local"`
+	Upstream   string `json:"upstream"`
+	Resolution string `json:"resolution"`
+}
+
+// SyncReport sums up a swapi sync
+type SyncReport struct {
+	Policy     MergePolicy     `json:"policy"`
+	StartedAt  time.Time       `json:"startedAt"`
+	FinishedAt time.Time       `json:"finishedAt"`
+	Planets    int             `json:"planets"`
+	Matched    int64           `json:"matched"`
+	Modified   int64           `json:"modified"`
+	Upserted   int64           `json:"upserted"`
+	Conflicts  []FieldConflict `json:"conflicts"`
+}
+
+func ParseMergePolicy(value string) (MergePolicy, error) {
+	switch policy := MergePolicy(value); policy {
+	case UpstreamWins, LocalWins, FillEmpty:
+		return policy, nil
+	case "":
+		return UpstreamWins, nil
+	default:
+		return "", fmt.Errorf("unknown merge policy %q", value)
+	}
+}
+
+type mergeField struct {
+	name     string
+	local    interface{}
+	upstream interface{}
+	empty    bool
+}
+
+// MergeSwapiPlanet builds the sync write for an upstream planet under policy.
+// existing is the stored planet with the same name, or nil if there is none. The write only applies to the planet
+// as existing saw it: a planet edited or created since it was read is not matched, and must be read and merged again.
+func MergeSwapiPlanet(existing *Planet, planet *swapi.Planet, policy MergePolicy) (mongo.WriteModel, []FieldConflict) {
+	if existing == nil {
+		// a planet created since is a duplicate name, instead of being overwritten
+		model := SwapiWritePlanetModel(planet).(*mongo.UpdateOneModel)
+		model.SetFilter(bson.M{"name": planet.Name, "_id": bson.M{"$exists": false}})
+		return model, nil
+	}
+
+	fields := []mergeField{
+		{"weather", existing.Climate, planet.Climate, existing.Climate == ""},
+		{"terrain", existing.Terrain, planet.Terrain, existing.Terrain == ""},
+		{"references", existing.Refs, len(planet.FilmURLs), existing.Refs == 0},
+	}
+
+	set := bson.M{}
+	changed := false
+	var conflicts []FieldConflict
+	for _, f := range fields {
+		source := existing.Sources[f.name]
+
+		write := true
+		switch policy {
+		case LocalWins:
+			write = source != SourceManual
+		case FillEmpty:
+			write = f.empty
+		}
+
+		if f.local != f.upstream && (!write || source == SourceManual) {
+			conflict := FieldConflict{
+				Planet:     planet.Name,
+				Field:      f.name,
+				Source:     source,
+				Local:      fmt.Sprintf("%v", f.local),
+				Upstream:   fmt.Sprintf("%v", f.upstream),
+				Resolution: ResolutionKept,
+			}
+			if write {
+				conflict.Resolution = ResolutionOverridden
+			}
+			conflicts = append(conflicts, conflict)
+		}
+
+		if write {
+			set[f.name] = f.upstream
+			set["sources."+f.name] = SourceSwapi
+			changed = changed || f.local != f.upstream
+		}
+	}
+
+	// links to other swapi entities cannot be edited, they follow swapi under every policy
+	links := []mergeField{
+		{"url", existing.URL, planet.URL, false},
+		{"films", existing.Films, planet.FilmURLs, false},
+		{"residents", existing.Residents, planet.ResidentURLs, false},
+	}
+	for _, f := range links {
+		if !reflect.DeepEqual(nonNil(f.local), nonNil(f.upstream)) {
+			set[f.name] = f.upstream
+			changed = true
+		}
+	}
+
+	if len(set) == 0 {
+		return nil, conflicts
+	}
+
+	// the policy decided on the values and sources of the fields as read
+	filter := bson.M{"name": planet.Name}
+	for _, f := range fields {
+		filter[f.name] = observed(f.local, f.empty)
+		filter["sources."+f.name] = observed(existing.Sources[f.name], existing.Sources[f.name] == "")
+	}
+
+	model := mongo.NewUpdateOneModel()
+	model.SetFilter(filter)
+	update := bson.M{"$set": set}
+	// a sync that brings nothing new leaves the planet as last modified
+	if changed {
+		update["$currentDate"] = touched
+	}
+	model.SetUpdate(update)
+	return model, conflicts
+}
+
+// nonNil is v with nil string slices made empty, stored links read back as nil when there are none
+func nonNil(v interface{}) interface{} {
+	if urls, ok := v.([]string); ok && urls == nil {
+		return []string{}
+	}
+	return v
+}
+
+// observed matches a stored field holding value, empty values are also matched by fields that are not stored
+func observed(value interface{}, empty bool) interface{} {
+	if empty {
+		return bson.M{"$in": bson.A{nil, value}}
+	}
+	return value
+}
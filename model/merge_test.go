@@ -0,0 +1,157 @@
+package model
+
+import (
+	"github.com/gugabfigueiredo/swapi"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"testing"
+)
+
+func TestMergeSwapiPlanet(t *testing.T) {
+
+	upstream := &swapi.Planet{Name: "Hoth", Climate: "frozen", Terrain: "tundra", FilmURLs: []string{"1", "2"}, URL: "planets/4"}
+
+	edited := &Planet{
+		Name:    "Hoth",
+		Climate: "chilly",
+		Terrain: "tundra",
+		Refs:    1,
+		URL:     "planets/4",
+		Films:   []string{"1", "2"},
+		Sources: map[string]string{"weather": SourceManual, "terrain": SourceSwapi, "references": SourceSwapi},
+	}
+
+	tests := []struct {
+		name              string
+		existing          *Planet
+		policy            MergePolicy
+		expectedSet       bson.M
+		expectedTouched   bool
+		expectedConflicts []FieldConflict
+	}{
+		{
+			name:     "new planet is upserted",
+			existing: nil,
+			policy:   LocalWins,
+			expectedSet: bson.M{
+				"name": "Hoth", "weather": "frozen", "terrain": "tundra", "references": 2,
+				"url": "planets/4", "films": []string{"1", "2"}, "residents": []string(nil),
+				"sources.weather": SourceSwapi, "sources.terrain": SourceSwapi, "sources.references": SourceSwapi,
+			},
+			expectedTouched: true,
+		},
+		{
+			name:     "upstream wins overrides manual edits",
+			existing: edited,
+			policy:   UpstreamWins,
+			expectedSet: bson.M{
+				"weather": "frozen", "terrain": "tundra", "references": 2,
+				"sources.weather": SourceSwapi, "sources.terrain": SourceSwapi, "sources.references": SourceSwapi,
+			},
+			expectedTouched: true,
+			expectedConflicts: []FieldConflict{
+				{Planet: "Hoth", Field: "weather", Source: SourceManual, Local: "chilly", Upstream: "frozen", Resolution: ResolutionOverridden},
+			},
+		},
+		{
+			name:     "local wins keeps manual edits",
+			existing: edited,
+			policy:   LocalWins,
+			expectedSet: bson.M{
+				"terrain": "tundra", "references": 2,
+				"sources.terrain": SourceSwapi, "sources.references": SourceSwapi,
+			},
+			expectedTouched: true,
+			expectedConflicts: []FieldConflict{
+				{Planet: "Hoth", Field: "weather", Source: SourceManual, Local: "chilly", Upstream: "frozen", Resolution: ResolutionKept},
+			},
+		},
+		{
+			name:     "fill empty only writes missing fields",
+			existing: &Planet{Name: "Hoth", Climate: "chilly", Sources: map[string]string{"weather": SourceManual}},
+			policy:   FillEmpty,
+			expectedSet: bson.M{
+				"terrain": "tundra", "references": 2,
+				"sources.terrain": SourceSwapi, "sources.references": SourceSwapi,
+				"url": "planets/4", "films": []string{"1", "2"},
+			},
+			expectedTouched: true,
+			expectedConflicts: []FieldConflict{
+				{Planet: "Hoth", Field: "weather", Source: SourceManual, Local: "chilly", Upstream: "frozen", Resolution: ResolutionKept},
+			},
+		},
+		{
+			name:     "unchanged planet is not touched",
+			existing: &Planet{Name: "Hoth", Climate: "frozen", Terrain: "tundra", Refs: 2, URL: "planets/4", Films: []string{"1", "2"}},
+			policy:   UpstreamWins,
+			expectedSet: bson.M{
+				"weather": "frozen", "terrain": "tundra", "references": 2,
+				"sources.weather": SourceSwapi, "sources.terrain": SourceSwapi, "sources.references": SourceSwapi,
+			},
+		},
+		{
+			name:     "nothing to fill",
+			existing: &Planet{Name: "Hoth", Climate: "frozen", Terrain: "tundra", Refs: 2, URL: "planets/4", Films: []string{"1", "2"}},
+			policy:   FillEmpty,
+		},
+		{
+			name:            "links follow swapi under every policy",
+			existing:        &Planet{Name: "Hoth", Climate: "frozen", Terrain: "tundra", Refs: 2, URL: "planets/4", Films: []string{"1"}},
+			policy:          FillEmpty,
+			expectedSet:     bson.M{"films": []string{"1", "2"}},
+			expectedTouched: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			write, conflicts := MergeSwapiPlanet(tt.existing, upstream, tt.policy)
+			assert.Equal(t, tt.expectedConflicts, conflicts)
+
+			if tt.expectedSet == nil {
+				assert.Nil(t, write)
+				return
+			}
+
+			update := write.(*mongo.UpdateOneModel).Update.(bson.M)
+			assert.Equal(t, tt.expectedSet, update["$set"])
+			_, touched := update["$currentDate"]
+			assert.Equal(t, tt.expectedTouched, touched)
+		})
+	}
+}
+
+func TestMergeSwapiPlanet_Filter(t *testing.T) {
+
+	upstream := &swapi.Planet{Name: "Hoth", Climate: "frozen", Terrain: "tundra", FilmURLs: []string{"1", "2"}}
+
+	write, _ := MergeSwapiPlanet(nil, upstream, LocalWins)
+	assert.Equal(t, bson.M{"name": "Hoth", "_id": bson.M{"$exists": false}}, write.(*mongo.UpdateOneModel).Filter)
+
+	// a manual edit made after the planet was read does not match
+	existing := &Planet{Name: "Hoth", Climate: "chilly", Refs: 2, Sources: map[string]string{"weather": SourceManual, "references": SourceSwapi}}
+	write, _ = MergeSwapiPlanet(existing, upstream, LocalWins)
+	assert.Equal(t, bson.M{
+		"name":               "Hoth",
+		"weather":            "chilly",
+		"terrain":            bson.M{"$in": bson.A{nil, ""}},
+		"references":         2,
+		"sources.weather":    SourceManual,
+		"sources.terrain":    bson.M{"$in": bson.A{nil, ""}},
+		"sources.references": SourceSwapi,
+	}, write.(*mongo.UpdateOneModel).Filter)
+}
+
+func TestParseMergePolicy(t *testing.T) {
+	policy, err := ParseMergePolicy("")
+	assert.NoError(t, err)
+	assert.Equal(t, UpstreamWins, policy)
+
+	policy, err = ParseMergePolicy("local-wins")
+	assert.NoError(t, err)
+	assert.Equal(t, LocalWins, policy)
+
+	_, err = ParseMergePolicy("remote-wins")
+	assert.Error(t, err)
+}
@@ -2,6 +2,7 @@ package model
 
 import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
 )
 
 type Planet struct {
@@ -10,4 +11,13 @@ type Planet struct {
 	Climate string             `bson:"weather,omitempty"`
 	Terrain string             `bson:"terrain,omitempty"`
 	Refs    int                `bson:"references,omitempty"`
-}
\ No newline at end of file
+	// URL, Films and Residents are the swapi urls of the planet, of the films it appears in and of its residents,
+	// written by the swapi sync
+	URL       string   `bson:"url,omitempty" json:",omitempty"`
+	Films     []string `bson:"films,omitempty" json:",omitempty"`
+	Residents []string `bson:"residents,omitempty" json:",omitempty"`
+	// Sources records whether each field was last written manually or by the swapi sync
+	Sources map[string]string `bson:"sources,omitempty" json:",omitempty"`
+	// UpdatedAt is when the planet was last inserted or changed, nil for planets stored before it was recorded
+	UpdatedAt *time.Time `bson:"updatedAt,omitempty" json:",omitempty"`
+}
@@ -0,0 +1,67 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"regexp"
+)
+
+// PlanetQuery selects planets by the fields that are set, in the order of their ids.
+// A zero query selects every planet.
+type PlanetQuery struct {
+	IDs   []primitive.ObjectID
+	Names []string
+	// URLs are swapi urls, as in Planet.URL
+	URLs []string
+	// Search, Climate and Terrain match names, climates and terrains containing them, ignoring case
+	Search  string
+	Climate string
+	Terrain string
+
+	// After starts the selection past the planet with this id, the last one of the previous page
+	After primitive.ObjectID
+	// Limit is the most planets selected, 0 for all of them
+	Limit int
+}
+
+// Filter is the database filter of the query
+func (q *PlanetQuery) Filter() bson.M {
+	filter := bson.M{}
+	if q.IDs != nil {
+		filter["_id"] = bson.M{"$in": q.IDs}
+	}
+	if !q.After.IsZero() {
+		if ids, ok := filter["_id"].(bson.M); ok {
+			ids["$gt"] = q.After
+		} else {
+			filter["_id"] = bson.M{"$gt": q.After}
+		}
+	}
+	if q.Names != nil {
+		filter["name"] = bson.M{"$in": q.Names}
+	}
+	if q.URLs != nil {
+		filter["url"] = bson.M{"$in": q.URLs}
+	}
+
+	contains := []struct {
+		field string
+		value string
+	}{
+		{"name", q.Search},
+		{"weather", q.Climate},
+		{"terrain", q.Terrain},
+	}
+	var and []bson.M
+	for _, c := range contains {
+		if c.value == "" {
+			continue
+		}
+		and = append(and, bson.M{c.field: primitive.Regex{Pattern: regexp.QuoteMeta(c.value), Options: "i"}})
+	}
+	if and != nil {
+		// names can be both listed and searched
+		filter["$and"] = and
+	}
+	return filter
+}
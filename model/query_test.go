@@ -0,0 +1,47 @@
+package model
+
+import (
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"testing"
+)
+
+func TestPlanetQuery_Filter(t *testing.T) {
+	id, _ := primitive.ObjectIDFromHex("5f1b2c3d4e5f6a7b8c9d0e1f")
+
+	tests := []struct {
+		name           string
+		query          PlanetQuery
+		expectedFilter bson.M
+	}{
+		{name: "every planet", query: PlanetQuery{Limit: 10}, expectedFilter: bson.M{}},
+		{
+			name:           "by names and urls",
+			query:          PlanetQuery{Names: []string{"Hoth"}, URLs: []string{"planets/4"}},
+			expectedFilter: bson.M{"name": bson.M{"$in": []string{"Hoth"}}, "url": bson.M{"$in": []string{"planets/4"}}},
+		},
+		{
+			name:           "next page of ids",
+			query:          PlanetQuery{IDs: []primitive.ObjectID{id}, After: id},
+			expectedFilter: bson.M{"_id": bson.M{"$in": []primitive.ObjectID{id}, "$gt": id}},
+		},
+		{
+			name:  "containing, with special characters quoted",
+			query: PlanetQuery{Search: "h.th", Climate: "frozen", After: id},
+			expectedFilter: bson.M{
+				"_id": bson.M{"$gt": id},
+				"$and": []bson.M{
+					{"name": primitive.Regex{Pattern: `h\.th`, Options: "i"}},
+					{"weather": primitive.Regex{Pattern: "frozen", Options: "i"}},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expectedFilter, tt.query.Filter())
+		})
+	}
+}
@@ -6,27 +6,72 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// touched sets updatedAt to the database time of the write
+var touched = bson.M{"updatedAt": true}
+
 func SwapiWritePlanetModel(planet *swapi.Planet) mongo.WriteModel {
 	model := mongo.NewUpdateOneModel()
 	model.SetFilter(bson.M{"name": planet.Name})
 	model.SetUpdate(bson.M{"$set": bson.M{
-		"name":       planet.Name,
-		"weather":    planet.Climate,
-		"terrain":    planet.Terrain,
-		"references": len(planet.FilmURLs),
-	}})
+		"name":               planet.Name,
+		"weather":            planet.Climate,
+		"terrain":            planet.Terrain,
+		"references":         len(planet.FilmURLs),
+		"url":                planet.URL,
+		"films":              planet.FilmURLs,
+		"residents":          planet.ResidentURLs,
+		"sources.weather":    SourceSwapi,
+		"sources.terrain":    SourceSwapi,
+		"sources.references": SourceSwapi,
+	}, "$currentDate": touched})
 	model.SetUpsert(true)
 	return model
 }
 
 func WritePlanetModel(planet *Planet) mongo.WriteModel {
+	return writePlanetModel(planet)
+}
+
+// UpsertPlanetModel is WritePlanetModel inserting the planet when none has its name, imports only write the
+// fields their rows have
+func UpsertPlanetModel(planet *Planet) mongo.WriteModel {
+	return writePlanetModel(planet).SetUpsert(true)
+}
+
+// writePlanetModel sets the fields planet has a value for and marks them as manual edits, fields left empty
+// keep their stored value and source
+func writePlanetModel(planet *Planet) *mongo.UpdateOneModel {
+	set := bson.M{"name": planet.Name}
+	for field := range ManualSources(planet) {
+		set["sources."+field] = SourceManual
+	}
+	if planet.Climate != "" {
+		set["weather"] = planet.Climate
+	}
+	if planet.Terrain != "" {
+		set["terrain"] = planet.Terrain
+	}
+	if planet.Refs != 0 {
+		set["references"] = planet.Refs
+	}
+
 	model := mongo.NewUpdateOneModel()
 	model.SetFilter(bson.M{"name": planet.Name})
-	model.SetUpdate(bson.M{"$set": bson.M{
-		"name":       planet.Name,
-		"weather":    planet.Climate,
-		"terrain":    planet.Terrain,
-		"references": planet.Refs,
-	}})
+	model.SetUpdate(bson.M{"$set": set, "$currentDate": touched})
 	return model
-}
\ No newline at end of file
+}
+
+// ManualSources marks the fields set on a planet created through the api as manual edits
+func ManualSources(planet *Planet) map[string]string {
+	sources := map[string]string{}
+	if planet.Climate != "" {
+		sources["weather"] = SourceManual
+	}
+	if planet.Terrain != "" {
+		sources["terrain"] = SourceManual
+	}
+	if planet.Refs != 0 {
+		sources["references"] = SourceManual
+	}
+	return sources
+}
@@ -0,0 +1,48 @@
+package model
+
+import (
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"testing"
+)
+
+func TestWritePlanetModel(t *testing.T) {
+
+	tests := []struct {
+		name        string
+		planet      *Planet
+		expectedSet bson.M
+	}{
+		{
+			name:   "every field",
+			planet: &Planet{Name: "Hoth", Climate: "frozen", Terrain: "tundra", Refs: 1},
+			expectedSet: bson.M{
+				"name": "Hoth", "weather": "frozen", "terrain": "tundra", "references": 1,
+				"sources.weather": SourceManual, "sources.terrain": SourceManual, "sources.references": SourceManual,
+			},
+		},
+		{
+			name:        "fields left out keep their value and source",
+			planet:      &Planet{Name: "Hoth", Climate: "chilly"},
+			expectedSet: bson.M{"name": "Hoth", "weather": "chilly", "sources.weather": SourceManual},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			update := WritePlanetModel(tt.planet).(*mongo.UpdateOneModel).Update.(bson.M)
+			assert.Equal(t, tt.expectedSet, update["$set"])
+			assert.Equal(t, touched, update["$currentDate"])
+		})
+	}
+}
+
+func TestUpsertPlanetModel(t *testing.T) {
+
+	// imported rows without a value for a field leave the stored one alone
+	write := UpsertPlanetModel(&Planet{Name: "Hoth", Terrain: "tundra"}).(*mongo.UpdateOneModel)
+	assert.True(t, *write.Upsert)
+	assert.Equal(t, bson.M{"name": "Hoth"}, write.Filter)
+	assert.Equal(t, bson.M{"name": "Hoth", "terrain": "tundra", "sources.terrain": SourceManual}, write.Update.(bson.M)["$set"])
+}
@@ -2,61 +2,137 @@ package repository
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/gugabfigueiredo/star-wars-api/log"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+	"time"
 )
 
-type Context struct {
-	context.Context
-}
-
-func (ctx *Context) Decode(value string) error {
-	switch value {
-	default:
-		ctx.Context = context.TODO()
-	}
-	return nil
-}
-
-// Config - Configuration for logging
+// Config - Configuration for the database connection
 type Config struct {
 	Username string `default:"mongo_user"`
-	Password string `default:"mongo_pass"`
+	Password string `default:"mongo_pass" secret:"true"`
 	Host string `default:"localhost"`
 	Port string `default:"27017"`
 	AuthDB string `default:"test"`
-	Context Context `default:"TODO"`
+	// URI is a full connection string, e.g. for replica sets, tls or authSource.
+	// When set, the fields above are ignored.
+	URI string `secret:"uri"`
+	// UsernameFile, PasswordFile and URIFile are read in place of the plain variables,
+	// for secrets mounted as files by docker or kubernetes
+	UsernameFile string `envconfig:"USERNAME_FILE"`
+	PasswordFile string `envconfig:"PASSWORD_FILE"`
+	URIFile      string `envconfig:"URI_FILE"`
+	// ConnectTimeout bounds connecting to and pinging the database on start
+	ConnectTimeout time.Duration `default:"10s"`
+	// AllowUnreachableOnStart starts the server even if the database cannot be reached yet,
+	// readiness reports it as down until it is back
+	AllowUnreachableOnStart bool `default:"false"`
 }
 
-var Repo Repository
+// a config logged as a field never shows its credentials, see log.RedactType
+func init() {
+	log.RedactType(Config{})
+}
+
+// New connects to the database of config. With AllowUnreachableOnStart, a database that cannot be pinged
+// yet is not an error and the repository is returned to retry on use.
+func New(config *Config, logger *log.Logger) (*Repository, error) {
+	repo := &Repository{
+		Logger: logger,
+	}
 
-func MustInit(config *Config, logger *log.Logger) error {
-	Repo = Repository{
-		Logger:  logger,
-		Context: config.Context,
+	if err := config.LoadSecrets(); err != nil {
+		repo.Logger.E("failed to read database secrets", "err", err)
+		return nil, err
 	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.ConnectTimeout)
+	defer cancel()
+
 	// Set client options
-	mongoURI := fmt.Sprintf("mongodb://%s:%s@%s:%s/%s",
-		config.Username, config.Password, config.Host, config.Port, config.AuthDB)
-	clientOptions := options.Client().ApplyURI(mongoURI)
+	clientOptions := options.Client().ApplyURI(config.ConnectionURI()).
+		// a span per mongo command, under the span of the request or sync that ran it
+		SetMonitor(otelmongo.NewMonitor())
 
 	// Connect to MongoDB
-	client, err := mongo.Connect(Repo.Context, clientOptions)
+	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
-		Repo.Logger.F("failed to connect to database", "err", err)
-		return err
+		repo.Logger.E("failed to connect to database", "err", err)
+		return nil, err
 	}
+	repo.Client = client
 
 	// Check the connection
-	err = client.Ping(context.TODO(), nil)
-	if err != nil {
-		Repo.Logger.F("failed to ping database connection", "err", err)
-		return err
+	if err := repo.Ping(ctx); err != nil {
+		if config.AllowUnreachableOnStart {
+			repo.Logger.W("database unreachable on start, serving until it is back", "err", err)
+			return repo, nil
+		}
+		repo.Logger.E("failed to ping database connection", "err", err)
+		client.Disconnect(context.Background())
+		return nil, err
 	}
 
-	Repo.Client = client
-	Repo.Logger.I("connected to database successfully")
-	return err
+	repo.Logger.I("connected to database successfully")
+	return repo, nil
+}
+
+// LoadSecrets reads the credentials of the *_FILE variables, a file wins over its plain variable
+func (c *Config) LoadSecrets() error {
+	for _, secret := range []struct {
+		file  string
+		value *string
+	}{
+		{c.UsernameFile, &c.Username},
+		{c.PasswordFile, &c.Password},
+		{c.URIFile, &c.URI},
+	} {
+		if secret.file == "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(secret.file)
+		if err != nil {
+			return fmt.Errorf("error reading secret file: %s", err)
+		}
+		*secret.value = strings.TrimRight(string(data), "\r\n")
+	}
+	return nil
+}
+
+// ConnectionURI is URI when set, or one built from the separate fields otherwise
+func (c *Config) ConnectionURI() string {
+	if c.URI != "" {
+		return c.URI
+	}
+	uri := url.URL{
+		Scheme: "mongodb",
+		User:   url.UserPassword(c.Username, c.Password),
+		Host:   net.JoinHostPort(c.Host, c.Port),
+		Path:   "/" + c.AuthDB,
+	}
+	return uri.String()
+}
+
+// MarshalJSON masks the password, also inside URI, so printed settings never show it
+func (c Config) MarshalJSON() ([]byte, error) {
+	type config Config
+	masked := config(c)
+	if masked.Password != "" {
+		masked.Password = log.Redacted
+	}
+	if masked.URI != "" {
+		masked.URI = log.Redacted
+		if uri, err := url.Parse(c.URI); err == nil {
+			masked.URI = uri.Redacted()
+		}
+	}
+	return json.Marshal(masked)
 }
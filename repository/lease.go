@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/gugabfigueiredo/star-wars-api/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+var ErrLeaseHeld = errors.New("lease is held by another holder")
+
+type ILeaseRepo interface {
+	AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (*model.Lease, error)
+	ReleaseLease(ctx context.Context, name, holder string) error
+	GetLease(ctx context.Context, name string) (*model.Lease, error)
+}
+
+func (r *Repository) Leases() *mongo.Collection {
+	return r.Database("sw-api").Collection("leases")
+}
+
+// EnsureLeaseIndexes lets mongo reap leases that were never released, e.g. by a crashed replica
+func (r *Repository) EnsureLeaseIndexes(ctx context.Context) error {
+	_, err := r.Leases().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"expiresAt": 1},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+// AcquireLease renews the lease if holder already owns it, or takes it over when it is missing or expired.
+// ErrLeaseHeld is returned when another holder owns a lease that is still valid.
+func (r *Repository) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (*model.Lease, error) {
+	now := time.Now().UTC()
+	after := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var lease model.Lease
+	err := r.Leases().FindOneAndUpdate(ctx,
+		bson.M{"_id": name, "holder": holder},
+		bson.M{"$set": bson.M{"renewedAt": now, "expiresAt": now.Add(ttl)}},
+		after,
+	).Decode(&lease)
+	if err == nil {
+		return &lease, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	err = r.Leases().FindOneAndUpdate(ctx,
+		bson.M{"_id": name, "expiresAt": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"holder": holder, "acquiredAt": now, "renewedAt": now, "expiresAt": now.Add(ttl)}},
+		after.SetUpsert(true),
+	).Decode(&lease)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrLeaseHeld
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &lease, nil
+}
+
+func (r *Repository) ReleaseLease(ctx context.Context, name, holder string) error {
+	_, err := r.Leases().DeleteOne(ctx, bson.M{"_id": name, "holder": holder})
+	return err
+}
+
+func (r *Repository) GetLease(ctx context.Context, name string) (*model.Lease, error) {
+	var lease model.Lease
+	if err := r.Leases().FindOne(ctx, bson.M{"_id": name}).Decode(&lease); err != nil {
+		return nil, err
+	}
+	return &lease, nil
+}
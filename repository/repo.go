@@ -2,54 +2,140 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/gugabfigueiredo/star-wars-api/log"
 	"github.com/gugabfigueiredo/star-wars-api/model"
 	"github.com/gugabfigueiredo/swapi"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type IRepo interface {
-	GetPlanet(interface{}, *model.Planet) error
-	GetAllPlanets() ([]*model.Planet, error)
-	InsertPlanets([]model.Planet) (*mongo.InsertManyResult, error)
-	UpdatePlanets([]model.Planet) (*mongo.BulkWriteResult, error)
-	UpdateMovieRefs([]swapi.Planet) (*mongo.BulkWriteResult, error)
-	DeletePlanets([]model.Planet) (*mongo.DeleteResult, error)
+	GetPlanet(context.Context, interface{}, *model.Planet) error
+	GetAllPlanets(context.Context) ([]*model.Planet, error)
+	FindPlanets(context.Context, model.PlanetQuery) ([]*model.Planet, error)
+	InsertPlanets(context.Context, []model.Planet) (*mongo.InsertManyResult, error)
+	UpdatePlanets(context.Context, []model.Planet) (*mongo.BulkWriteResult, error)
+	UpsertPlanets(context.Context, []model.Planet) (*mongo.BulkWriteResult, error)
+	StreamPlanets(context.Context, func(*model.Planet) error) error
+	UpdateMovieRefs(context.Context, []swapi.Planet, model.MergePolicy) (*mongo.BulkWriteResult, []model.FieldConflict, error)
+	DeletePlanets(context.Context, []model.Planet) (*mongo.DeleteResult, error)
+	LastModified(context.Context) (time.Time, error)
 }
 
 type Repository struct {
 	*mongo.Client
-	Context context.Context
 	Logger *log.Logger
+
+	// indexed is set once the read indexes exist, indexTried is when they were last tried
+	indexed    int32
+	indexMu    sync.Mutex
+	indexTried time.Time
 }
 
-func (r *Repository) Disconnect() error {
-	return r.Client.Disconnect(r.Context)
+// indexRetryInterval spaces out attempts to create the read indexes while they fail
+const indexRetryInterval = time.Minute
+
+// Ping checks the database is reachable. The first time it is, the read indexes are set up.
+func (r *Repository) Ping(ctx context.Context) error {
+	if err := r.Client.Ping(ctx, nil); err != nil {
+		return err
+	}
+	r.ensureReadIndexes(ctx)
+	return nil
+}
+
+// ensureReadIndexes sets up the lease indexes and the index of LastModified, retried at most every
+// indexRetryInterval until they exist. The unique index on planet names can fail on stored duplicates,
+// it is left to Migrate.
+func (r *Repository) ensureReadIndexes(ctx context.Context) {
+	if atomic.LoadInt32(&r.indexed) == 1 {
+		return
+	}
+	r.indexMu.Lock()
+	if time.Since(r.indexTried) < indexRetryInterval {
+		r.indexMu.Unlock()
+		return
+	}
+	r.indexTried = time.Now()
+	r.indexMu.Unlock()
+
+	if err := r.EnsureLeaseIndexes(ctx); err != nil {
+		r.Logger.E("failed to create lease indexes, retrying later", "err", err, "retryIn", indexRetryInterval)
+		return
+	}
+	if _, err := r.Planets().Indexes().CreateOne(ctx, updatedAtIndex); err != nil {
+		r.Logger.E("failed to create planet indexes, retrying later", "err", err, "retryIn", indexRetryInterval)
+		return
+	}
+	atomic.StoreInt32(&r.indexed, 1)
+}
+
+func (r *Repository) Disconnect(ctx context.Context) error {
+	return r.Client.Disconnect(ctx)
 }
 
 func (r *Repository) Planets() *mongo.Collection {
 	return r.Database("sw-api").Collection("planets")
 }
 
-func (r *Repository) GetPlanet(filter interface{}, model *model.Planet) error {
-	return r.Planets().FindOne(r.Context, filter).Decode(model)
+// Changes holds when planets were last deleted, which no remaining planet records
+func (r *Repository) Changes() *mongo.Collection {
+	return r.Database("sw-api").Collection("changes")
 }
 
-func (r *Repository) GetAllPlanets() ([]*model.Planet, error) {
+// IMigrator sets up the indexes the repository relies on
+type IMigrator interface {
+	Migrate(context.Context) error
+}
+
+// Migrate creates the planet and lease indexes, indexes that exist already are left as they are
+func (r *Repository) Migrate(ctx context.Context) error {
+	if err := r.EnsurePlanetIndexes(ctx); err != nil {
+		return err
+	}
+	return r.EnsureLeaseIndexes(ctx)
+}
+
+// EnsurePlanetIndexes makes planet names unique, planets are found, synced and deleted by name,
+// and indexes when they were updated for LastModified. It fails while duplicate names are stored.
+func (r *Repository) EnsurePlanetIndexes(ctx context.Context) error {
+	_, err := r.Planets().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.M{"name": 1},
+			Options: options.Index().SetUnique(true),
+		},
+		updatedAtIndex,
+	})
+	return err
+}
+
+// updatedAtIndex finds the latest planet update for LastModified without scanning every planet
+var updatedAtIndex = mongo.IndexModel{Keys: bson.M{"updatedAt": -1}}
+
+func (r *Repository) GetPlanet(ctx context.Context, filter interface{}, model *model.Planet) error {
+	return r.Planets().FindOne(ctx, filter).Decode(model)
+}
+
+func (r *Repository) GetAllPlanets(ctx context.Context) ([]*model.Planet, error) {
 
 	cur, err := r.Planets().
-		Find(r.Context, bson.D{})
+		Find(ctx, bson.D{})
 	if err != nil {
 		r.Logger.E("failed to query for planets", "err", err)
 		return nil, err
 	}
 
-	defer cur.Close(r.Context)
+	defer cur.Close(ctx)
 
 	var results []*model.Planet
-	for cur.Next(r.Context) {
+	for cur.Next(ctx) {
 
 		var planet *model.Planet
 		if err := cur.Decode(&planet); err != nil {
@@ -68,44 +154,249 @@ func (r *Repository) GetAllPlanets() ([]*model.Planet, error) {
 	return results, nil
 }
 
-func (r *Repository) UpdateMovieRefs(planets []swapi.Planet) (*mongo.BulkWriteResult, error) {
-	var writes []mongo.WriteModel
+// FindPlanets returns the planets selected by query, in the order of their ids
+func (r *Repository) FindPlanets(ctx context.Context, query model.PlanetQuery) ([]*model.Planet, error) {
+
+	opts := options.Find().SetSort(bson.M{"_id": 1})
+	if query.Limit > 0 {
+		opts.SetLimit(int64(query.Limit))
+	}
+
+	cur, err := r.Planets().Find(ctx, query.Filter(), opts)
+	if err != nil {
+		r.Logger.E("failed to query for planets", "err", err)
+		return nil, err
+	}
+
+	var results []*model.Planet
+	if err := cur.All(ctx, &results); err != nil {
+		r.Logger.E("failed to decode planets", "err", err)
+		return nil, err
+	}
+	return results, nil
+}
+
+// StreamPlanets calls fn with every planet as it is decoded from the cursor, stopping at the first error fn returns
+func (r *Repository) StreamPlanets(ctx context.Context, fn func(*model.Planet) error) error {
+
+	cur, err := r.Planets().Find(ctx, bson.D{})
+	if err != nil {
+		r.Logger.E("failed to query for planets", "err", err)
+		return err
+	}
+
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var planet model.Planet
+		if err := cur.Decode(&planet); err != nil {
+			r.Logger.E("failed to decode planet", "err", err)
+			return err
+		}
+		if err := fn(&planet); err != nil {
+			return err
+		}
+	}
+
+	return cur.Err()
+}
+
+// mergeAttempts bounds how many times a sync reads and merges again planets that were edited while it merged them
+const mergeAttempts = 3
+
+// UpdateMovieRefs merges planets into the stored ones under policy. Writes only apply to planets as they were read,
+// planets edited or created in between are read and merged again. The result counts the planets matched by the
+// last attempt, and the planets modified and upserted by all of them.
+func (r *Repository) UpdateMovieRefs(ctx context.Context, planets []swapi.Planet, policy model.MergePolicy) (*mongo.BulkWriteResult, []model.FieldConflict, error) {
+
+	var names []string
 	for _, planet := range planets {
-		writes = append(writes, model.SwapiWritePlanetModel(&planet))
+		names = append(names, planet.Name)
+	}
+
+	total := &mongo.BulkWriteResult{}
+	var conflicts []model.FieldConflict
+	for attempt := 1; ; attempt++ {
+		cur, err := r.Planets().Find(ctx, bson.M{"name": bson.M{"$in": names}})
+		if err != nil {
+			r.Logger.E("failed to query for planets to merge", "err", err)
+			return nil, nil, err
+		}
+
+		var stored []*model.Planet
+		if err := cur.All(ctx, &stored); err != nil {
+			r.Logger.E("failed to decode planets to merge", "err", err)
+			return nil, nil, err
+		}
+
+		existing := map[string]*model.Planet{}
+		for _, planet := range stored {
+			existing[planet.Name] = planet
+		}
+
+		var writes []mongo.WriteModel
+		var merged []model.FieldConflict
+		for i := range planets {
+			write, planetConflicts := model.MergeSwapiPlanet(existing[planets[i].Name], &planets[i], policy)
+			if write != nil {
+				writes = append(writes, write)
+			}
+			merged = append(merged, planetConflicts...)
+		}
+		// planets merged again report their latest conflicts
+		conflicts = mergeConflicts(conflicts, merged)
+
+		if len(writes) == 0 {
+			return total, conflicts, nil
+		}
+
+		res, err := r.Planets().BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+		if err != nil && !onlyDuplicates(err) {
+			return res, conflicts, err
+		}
+		total.MatchedCount = res.MatchedCount
+		total.ModifiedCount += res.ModifiedCount
+		total.UpsertedCount += res.UpsertedCount
+
+		missed := int64(len(writes)) - res.MatchedCount - res.UpsertedCount
+		if missed == 0 {
+			return total, conflicts, nil
+		}
+		if attempt == mergeAttempts {
+			r.Logger.W("planets kept changing during the sync, they are merged by the next one", "planets", missed)
+			return total, conflicts, nil
+		}
 	}
-	return r.Planets().BulkWrite(r.Context, writes, options.BulkWrite().SetOrdered(false))
 }
 
-func (r *Repository) InsertPlanets(planets []model.Planet) (*mongo.InsertManyResult, error) {
+// onlyDuplicates reports whether err only failed writes of planets created by someone else in the meantime
+func onlyDuplicates(err error) bool {
+	var bulk mongo.BulkWriteException
+	if !errors.As(err, &bulk) || bulk.WriteConcernError != nil {
+		return false
+	}
+	for _, writeErr := range bulk.WriteErrors {
+		if writeErr.Code != duplicateKeyCode {
+			return false
+		}
+	}
+	return true
+}
+
+// duplicateKeyCode is the mongo error of a write breaking a unique index
+const duplicateKeyCode = 11000
+
+// mergeConflicts adds the conflicts of a merge to the ones of earlier attempts, replacing those of the same field
+func mergeConflicts(earlier, latest []model.FieldConflict) []model.FieldConflict {
+	type field struct{ planet, name string }
+	index := map[field]int{}
+	for i, c := range earlier {
+		index[field{c.Planet, c.Field}] = i
+	}
+	for _, c := range latest {
+		if i, ok := index[field{c.Planet, c.Field}]; ok {
+			earlier[i] = c
+			continue
+		}
+		index[field{c.Planet, c.Field}] = len(earlier)
+		earlier = append(earlier, c)
+	}
+	return earlier
+}
+
+// InsertPlanets inserts planets with the database time as updatedAt, like every other write. Planets are upserted
+// by id so $currentDate can set it, a planet with a name already stored fails on the unique name index and one
+// with an id already stored fails as a duplicate without being written.
+func (r *Repository) InsertPlanets(ctx context.Context, planets []model.Planet) (*mongo.InsertManyResult, error) {
 
-	var docs []interface{}
+	var writes []mongo.WriteModel
+	var ids []primitive.ObjectID
 	for _, planet := range planets {
+		planet.Sources = model.ManualSources(&planet)
+		planet.UpdatedAt = nil
+		id := planet.ID
+		if id.IsZero() {
+			id = primitive.NewObjectID()
+		}
+		ids = append(ids, id)
+		planet.ID = primitive.NilObjectID
+
 		data, err := bson.Marshal(planet)
 		if err != nil {
 			r.Logger.E("failed to marshal planet", "err", err, "planet", planet)
 			return nil, err
 		}
-
-		var doc bson.D
+		var doc bson.M
 		if err := bson.Unmarshal(data, &doc); err != nil {
-			r.Logger.E("failed to unmarshal data into planet bson.D", "err", err, "data", data)
+			r.Logger.E("failed to unmarshal data into planet bson.M", "err", err, "data", data)
+			return nil, err
 		}
-		docs = append(docs, data)
+
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": id}).
+			SetUpdate(bson.M{"$setOnInsert": doc, "$currentDate": bson.M{"updatedAt": true}}).
+			SetUpsert(true))
 	}
 
-	return r.Planets().InsertMany(r.Context, docs, options.InsertMany().SetOrdered(false))
+	res, err := r.Planets().BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	return insertResult(ids, writes, res, err)
 }
 
-func (r *Repository) UpdatePlanets(planets []model.Planet) (*mongo.BulkWriteResult, error) {
+// insertResult lists the ids of the planets upserted by InsertPlanets. An upsert that matched a stored planet
+// wrote nothing, it is reported as a duplicate key write error like InsertMany would.
+func insertResult(ids []primitive.ObjectID, writes []mongo.WriteModel, res *mongo.BulkWriteResult, err error) (*mongo.InsertManyResult, error) {
+	var bulk mongo.BulkWriteException
+	if res == nil || (err != nil && !errors.As(err, &bulk)) {
+		return nil, err
+	}
+
+	failed := map[int]bool{}
+	for _, writeErr := range bulk.WriteErrors {
+		failed[writeErr.Index] = true
+	}
+	inserted := &mongo.InsertManyResult{}
+	for i, id := range ids {
+		if upserted, ok := res.UpsertedIDs[int64(i)]; ok {
+			inserted.InsertedIDs = append(inserted.InsertedIDs, upserted)
+			continue
+		}
+		if !failed[i] {
+			bulk.WriteErrors = append(bulk.WriteErrors, mongo.BulkWriteError{
+				WriteError: mongo.WriteError{
+					Index:   i,
+					Code:    duplicateKeyCode,
+					Message: fmt.Sprintf("E11000 duplicate key error: a planet with _id %s exists already", id.Hex()),
+				},
+				Request: writes[i],
+			})
+		}
+	}
+	if len(bulk.WriteErrors) > 0 || bulk.WriteConcernError != nil {
+		return inserted, bulk
+	}
+	return inserted, nil
+}
+
+func (r *Repository) UpdatePlanets(ctx context.Context, planets []model.Planet) (*mongo.BulkWriteResult, error) {
 
 	var writes []mongo.WriteModel
 	for _, planet := range planets {
 		writes = append(writes, model.WritePlanetModel(&planet))
 	}
-	return r.Planets().BulkWrite(r.Context, writes, options.BulkWrite().SetOrdered(false))
+	return r.Planets().BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
 }
 
-func (r *Repository) DeletePlanets(planets []model.Planet) (*mongo.DeleteResult, error) {
+// UpsertPlanets is UpdatePlanets inserting the planets that do not exist yet
+func (r *Repository) UpsertPlanets(ctx context.Context, planets []model.Planet) (*mongo.BulkWriteResult, error) {
+
+	var writes []mongo.WriteModel
+	for _, planet := range planets {
+		writes = append(writes, model.UpsertPlanetModel(&planet))
+	}
+	return r.Planets().BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+}
+
+func (r *Repository) DeletePlanets(ctx context.Context, planets []model.Planet) (*mongo.DeleteResult, error) {
 
 	var names []string
 	for _, planet := range planets {
@@ -115,5 +406,40 @@ func (r *Repository) DeletePlanets(planets []model.Planet) (*mongo.DeleteResult,
 		"name": bson.M{"$in": names},
 	}
 
-	return r.Planets().DeleteMany(r.Context, filter, options.Delete())
+	res, err := r.Planets().DeleteMany(ctx, filter, options.Delete())
+	if err != nil || res.DeletedCount == 0 {
+		return res, err
+	}
+
+	// the planets are gone, a failure here only leaves LastModified behind until the next write
+	_, err = r.Changes().UpdateOne(ctx, bson.M{"_id": "planets"},
+		bson.M{"$currentDate": bson.M{"deletedAt": true}}, options.Update().SetUpsert(true))
+	if err != nil {
+		r.Logger.E("failed to record planets deletion", "err", err)
+	}
+	return res, nil
+}
+
+// LastModified is when planets last changed, the latest of their updates and deletions.
+// It is zero when no change was recorded.
+func (r *Repository) LastModified(ctx context.Context) (time.Time, error) {
+	var latest model.Planet
+	err := r.Planets().FindOne(ctx, bson.M{"updatedAt": bson.M{"$exists": true}},
+		options.FindOne().SetSort(bson.M{"updatedAt": -1}).SetProjection(bson.M{"updatedAt": 1})).Decode(&latest)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return time.Time{}, err
+	}
+
+	var changes struct {
+		DeletedAt time.Time `bson:"deletedAt"`
+	}
+	err = r.Changes().FindOne(ctx, bson.M{"_id": "planets"}).Decode(&changes)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return time.Time{}, err
+	}
+
+	if latest.UpdatedAt != nil && latest.UpdatedAt.After(changes.DeletedAt) {
+		return *latest.UpdatedAt, nil
+	}
+	return changes.DeletedAt, nil
 }
\ No newline at end of file
@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/gugabfigueiredo/star-wars-api/model"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"testing"
+	"time"
+)
+
+func TestMergeConflicts(t *testing.T) {
+
+	earlier := []model.FieldConflict{
+		{Planet: "Hoth", Field: "weather", Local: "chilly", Resolution: model.ResolutionOverridden},
+		{Planet: "Tatooine", Field: "terrain", Local: "sand", Resolution: model.ResolutionKept},
+	}
+	latest := []model.FieldConflict{
+		{Planet: "Tatooine", Field: "terrain", Local: "dunes", Resolution: model.ResolutionKept},
+		{Planet: "Tatooine", Field: "weather", Local: "hot", Resolution: model.ResolutionKept},
+	}
+
+	assert.Equal(t, []model.FieldConflict{
+		{Planet: "Hoth", Field: "weather", Local: "chilly", Resolution: model.ResolutionOverridden},
+		{Planet: "Tatooine", Field: "terrain", Local: "dunes", Resolution: model.ResolutionKept},
+		{Planet: "Tatooine", Field: "weather", Local: "hot", Resolution: model.ResolutionKept},
+	}, mergeConflicts(earlier, latest))
+}
+
+func TestOnlyDuplicates(t *testing.T) {
+
+	duplicate := mongo.BulkWriteError{WriteError: mongo.WriteError{Code: duplicateKeyCode}}
+	other := mongo.BulkWriteError{WriteError: mongo.WriteError{Code: 121}}
+
+	assert.True(t, onlyDuplicates(mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{duplicate, duplicate}}))
+	assert.False(t, onlyDuplicates(mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{duplicate, other}}))
+	assert.False(t, onlyDuplicates(mongo.BulkWriteException{WriteConcernError: &mongo.WriteConcernError{}}))
+	assert.False(t, onlyDuplicates(errors.New("connection reset")))
+}
+
+func TestInsertResult(t *testing.T) {
+
+	ids := []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()}
+	writes := []mongo.WriteModel{mongo.NewUpdateOneModel(), mongo.NewUpdateOneModel(), mongo.NewUpdateOneModel()}
+
+	t.Run("existing id", func(t *testing.T) {
+		// the second planet matched a stored one, so its upsert wrote nothing
+		res := &mongo.BulkWriteResult{MatchedCount: 1, UpsertedCount: 2, UpsertedIDs: map[int64]interface{}{0: ids[0], 2: ids[2]}}
+
+		inserted, err := insertResult(ids, writes, res, nil)
+
+		var bulk mongo.BulkWriteException
+		assert.True(t, errors.As(err, &bulk))
+		assert.Len(t, bulk.WriteErrors, 1)
+		assert.Equal(t, 1, bulk.WriteErrors[0].Index)
+		assert.True(t, onlyDuplicates(err))
+		assert.Equal(t, []interface{}{ids[0], ids[2]}, inserted.InsertedIDs)
+	})
+
+	t.Run("existing id and taken name", func(t *testing.T) {
+		res := &mongo.BulkWriteResult{MatchedCount: 1, UpsertedCount: 1, UpsertedIDs: map[int64]interface{}{0: ids[0]}}
+		taken := mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{
+			{WriteError: mongo.WriteError{Index: 2, Code: duplicateKeyCode, Message: "E11000 duplicate key error: name"}},
+		}}
+
+		inserted, err := insertResult(ids, writes, res, taken)
+
+		var bulk mongo.BulkWriteException
+		assert.True(t, errors.As(err, &bulk))
+		assert.Len(t, bulk.WriteErrors, 2)
+		assert.Equal(t, []interface{}{ids[0]}, inserted.InsertedIDs)
+	})
+
+	t.Run("all inserted", func(t *testing.T) {
+		res := &mongo.BulkWriteResult{UpsertedCount: 3, UpsertedIDs: map[int64]interface{}{0: ids[0], 1: ids[1], 2: ids[2]}}
+
+		inserted, err := insertResult(ids, writes, res, nil)
+
+		assert.NoError(t, err)
+		assert.Len(t, inserted.InsertedIDs, 3)
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		inserted, err := insertResult(ids, writes, nil, errors.New("connection reset"))
+
+		assert.EqualError(t, err, "connection reset")
+		assert.Nil(t, inserted)
+	})
+}
+
+func TestEnsureReadIndexes_RateLimited(t *testing.T) {
+
+	// without a client, any attempt to create the indexes would panic
+	r := &Repository{indexTried: time.Now()}
+	r.ensureReadIndexes(context.Background())
+
+	r = &Repository{indexed: 1}
+	r.ensureReadIndexes(context.Background())
+}
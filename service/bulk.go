@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/gugabfigueiredo/star-wars-api/model"
+	"github.com/gugabfigueiredo/star-wars-api/repository"
+	"go.mongodb.org/mongo-driver/mongo"
+	"io"
+)
+
+const (
+	// DefaultImportBatchSize is how many planets an import writes at once unless told otherwise
+	DefaultImportBatchSize = 500
+	// maxImportErrors caps the failed rows listed in an import report, all of them are counted
+	maxImportErrors = 100
+)
+
+// ImportOptions - how ImportPlanets writes planets
+type ImportOptions struct {
+	// Upsert updates the planets that exist already, by name, and inserts the others.
+	// Without it every planet is inserted, and names that are taken fail if the unique index is set up.
+	Upsert bool
+	// BatchSize is how many planets are written at once, DefaultImportBatchSize when 0
+	BatchSize int
+}
+
+// ImportPlanets writes the planets of decoder in batches, so only a batch is held in memory at a time.
+// Rows that cannot be decoded or written are listed in the report and skipped. An error that stops the import
+// is returned with the report of the batches written before it.
+func ImportPlanets(ctx context.Context, repo repository.IRepo, decoder *model.PlanetDecoder, options ImportOptions) (*model.ImportReport, error) {
+	if options.BatchSize <= 0 {
+		options.BatchSize = DefaultImportBatchSize
+	}
+	report := &model.ImportReport{Format: decoder.Format(), Upsert: options.Upsert, Errors: []model.RowError{}}
+	fail := func(row int, message string) {
+		report.Failed++
+		if len(report.Errors) < maxImportErrors {
+			report.Errors = append(report.Errors, model.RowError{Row: row, Message: message})
+		}
+	}
+
+	var batch []model.Planet
+	var rows []int
+	write := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		written, err := writeBatch(ctx, repo, batch, options.Upsert, report)
+		var bulk mongo.BulkWriteException
+		if errors.As(err, &bulk) && bulk.WriteConcernError == nil {
+			for _, writeErr := range bulk.WriteErrors {
+				fail(rows[writeErr.Index], writeErr.Message)
+			}
+			written, err = written-len(bulk.WriteErrors), nil
+		}
+		if err == nil && !options.Upsert {
+			report.Inserted += int64(written)
+		}
+		// the repository may keep the slices, start new ones
+		batch, rows = nil, nil
+		return err
+	}
+
+	for {
+		planet, err := decoder.Decode()
+		if err == io.EOF {
+			break
+		}
+		var rowErr *model.RowError
+		switch {
+		case errors.As(err, &rowErr):
+			report.Rows++
+			fail(rowErr.Row, rowErr.Message)
+			continue
+		case err != nil:
+			report.Error = err.Error()
+			return report, err
+		}
+
+		report.Rows++
+		batch = append(batch, planet)
+		rows = append(rows, decoder.Row())
+		if len(batch) == options.BatchSize {
+			if err := write(); err != nil {
+				report.Error = err.Error()
+				return report, err
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			report.Error = err.Error()
+			return report, err
+		}
+	}
+
+	if err := write(); err != nil {
+		report.Error = err.Error()
+		return report, err
+	}
+	return report, nil
+}
+
+// writeBatch inserts or upserts batch, returning how many planets were sent
+func writeBatch(ctx context.Context, repo repository.IRepo, batch []model.Planet, upsert bool, report *model.ImportReport) (int, error) {
+	if !upsert {
+		_, err := repo.InsertPlanets(ctx, batch)
+		return len(batch), err
+	}
+	res, err := repo.UpsertPlanets(ctx, batch)
+	if res != nil {
+		report.Matched += res.MatchedCount
+		report.Modified += res.ModifiedCount
+		report.Upserted += res.UpsertedCount
+	}
+	return len(batch), err
+}
+
+// ExportPlanets streams every planet from the repository to encoder as it is read from the cursor, and closes it.
+// Only one planet is held in memory at a time. It returns how many planets were written.
+func ExportPlanets(ctx context.Context, repo repository.IRepo, encoder *model.PlanetEncoder) (int, error) {
+	if err := repo.StreamPlanets(ctx, encoder.Encode); err != nil {
+		return encoder.Count(), err
+	}
+	return encoder.Count(), encoder.Close()
+}
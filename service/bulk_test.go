@@ -0,0 +1,103 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/gugabfigueiredo/star-wars-api/model"
+	"github.com/gugabfigueiredo/star-wars-api/test"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+	"strings"
+	"testing"
+)
+
+// batchRepo records the batches it is given and fails them with errs, in order
+type batchRepo struct {
+	*test.Stub
+	batches [][]model.Planet
+	errs    []error
+}
+
+func (r *batchRepo) next(planets []model.Planet) error {
+	r.batches = append(r.batches, planets)
+	if len(r.errs) == 0 {
+		return nil
+	}
+	err := r.errs[0]
+	r.errs = r.errs[1:]
+	return err
+}
+
+func (r *batchRepo) InsertPlanets(_ context.Context, planets []model.Planet) (*mongo.InsertManyResult, error) {
+	return &mongo.InsertManyResult{}, r.next(planets)
+}
+
+func (r *batchRepo) UpsertPlanets(_ context.Context, planets []model.Planet) (*mongo.BulkWriteResult, error) {
+	return &mongo.BulkWriteResult{MatchedCount: 1, ModifiedCount: 1, UpsertedCount: int64(len(planets) - 1)}, r.next(planets)
+}
+
+func TestImportPlanets(t *testing.T) {
+
+	csv := "name,refs\nHoth,1\nBespin,lots\nNaboo,4\nEndor,\nKamino,2\nDagobah,3\n"
+
+	t.Run("insert in batches", func(t *testing.T) {
+		repo := &batchRepo{Stub: &test.Stub{}, errs: []error{
+			nil,
+			mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{{WriteError: mongo.WriteError{Index: 1, Message: "duplicate key"}}}},
+		}}
+		decoder, err := model.NewPlanetDecoder(strings.NewReader(csv), model.FormatCSV)
+		assert.NoError(t, err)
+
+		report, err := ImportPlanets(context.Background(), repo, decoder, ImportOptions{BatchSize: 2})
+		assert.NoError(t, err)
+
+		assert.Len(t, repo.batches, 3)
+		assert.Equal(t, []string{"Endor", "Kamino"}, []string{repo.batches[1][0].Name, repo.batches[1][1].Name})
+		assert.Equal(t, &model.ImportReport{
+			Format:   model.FormatCSV,
+			Rows:     6,
+			Inserted: 4,
+			Failed:   2,
+			Errors:   []model.RowError{{Row: 2, Message: `invalid refs "lots"`}, {Row: 5, Message: "duplicate key"}},
+		}, report)
+	})
+
+	t.Run("upsert", func(t *testing.T) {
+		repo := &batchRepo{Stub: &test.Stub{}}
+		decoder, err := model.NewPlanetDecoder(strings.NewReader(`{"Name":"Hoth"}`+"\n"+`{"Name":"Naboo"}`), model.FormatNDJSON)
+		assert.NoError(t, err)
+
+		report, err := ImportPlanets(context.Background(), repo, decoder, ImportOptions{Upsert: true})
+		assert.NoError(t, err)
+		assert.Len(t, repo.batches, 1)
+		assert.Equal(t, int64(1), report.Matched)
+		assert.Equal(t, int64(1), report.Upserted)
+		assert.Equal(t, int64(0), report.Inserted)
+	})
+
+	t.Run("stops on database error", func(t *testing.T) {
+		failure := errors.New("connection reset")
+		repo := &batchRepo{Stub: &test.Stub{}, errs: []error{nil, failure}}
+		decoder, err := model.NewPlanetDecoder(strings.NewReader(csv), model.FormatCSV)
+		assert.NoError(t, err)
+
+		report, err := ImportPlanets(context.Background(), repo, decoder, ImportOptions{BatchSize: 2})
+		assert.Equal(t, failure, err)
+		assert.Equal(t, int64(2), report.Inserted)
+		assert.Equal(t, "connection reset", report.Error)
+	})
+}
+
+func TestExportPlanets(t *testing.T) {
+
+	stub := &test.Stub{Planets: []*model.Planet{{Name: "Hoth"}, {Name: "Naboo"}}}
+	var buf bytes.Buffer
+	encoder, err := model.NewPlanetEncoder(&buf, model.FormatNDJSON)
+	assert.NoError(t, err)
+
+	count, err := ExportPlanets(context.Background(), stub, encoder)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, "{\"ID\":\"000000000000000000000000\",\"Name\":\"Hoth\",\"Climate\":\"\",\"Terrain\":\"\",\"Refs\":0}\n", strings.SplitAfter(buf.String(), "\n")[0])
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// HealthConfig - Configuration for readiness checks
+type HealthConfig struct {
+	// PingTimeout bounds the database ping of a readiness check
+	PingTimeout time.Duration `default:"2s"`
+	// SyncMaxAge is how old the last successful swapi sync may be before it is reported as degraded
+	SyncMaxAge time.Duration `default:"8h"`
+}
+
+const (
+	StatusOK       = "ok"
+	StatusDegraded = "degraded"
+	StatusDown     = "down"
+)
+
+type IPinger interface {
+	Ping(ctx context.Context) error
+}
+
+type CheckResult struct {
+	Status   string      `json:"status"`
+	Critical bool        `json:"critical"`
+	Message  string      `json:"message,omitempty"`
+	Details  interface{} `json:"details,omitempty"`
+}
+
+type Readiness struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// Ready reports whether the service can take traffic. Only the database is critical,
+// a stale sync or an open swapi circuit leave the service degraded but ready to serve stored planets.
+// The sync is only checked on the replica holding the sync lease.
+type HealthService struct {
+	Database IPinger
+	Sync     *APIService
+	Swapi    *SwapiService
+	Config   *HealthConfig
+
+	StartedAt time.Time
+}
+
+func (s *HealthService) Ready(ctx context.Context) Readiness {
+	readiness := Readiness{
+		Status: StatusOK,
+		Checks: map[string]CheckResult{
+			"database": s.checkDatabase(ctx),
+		},
+	}
+	if s.Sync != nil {
+		readiness.Checks["sync"] = s.checkSync()
+	}
+	if s.Swapi != nil {
+		readiness.Checks["swapi"] = s.checkSwapi()
+	}
+
+	for _, check := range readiness.Checks {
+		switch {
+		case check.Status == StatusOK:
+		case check.Critical:
+			readiness.Status = StatusDown
+		case readiness.Status == StatusOK:
+			readiness.Status = StatusDegraded
+		}
+	}
+	return readiness
+}
+
+func (s *HealthService) checkDatabase(ctx context.Context) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, s.Config.PingTimeout)
+	defer cancel()
+
+	started := time.Now()
+	if err := s.Database.Ping(ctx); err != nil {
+		return CheckResult{Status: StatusDown, Critical: true, Message: err.Error()}
+	}
+	return CheckResult{Status: StatusOK, Critical: true, Details: map[string]string{"latency": time.Since(started).String()}}
+}
+
+func (s *HealthService) checkSync() CheckResult {
+	status := s.Sync.SyncStatus()
+	result := CheckResult{Status: StatusOK, Details: status}
+
+	// only the lease holder syncs, followers have nothing to report on
+	if s.Sync.Leader != nil && !s.Sync.Leader.IsLeader() {
+		result.Message = "skipped, sync runs on the lease holder"
+		return result
+	}
+
+	switch {
+	case !status.LastSuccess.IsZero():
+		if age := time.Since(status.LastSuccess); age > s.Config.SyncMaxAge {
+			result.Status = StatusDegraded
+			result.Message = fmt.Sprintf("last successful sync was %s ago", age.Round(time.Second))
+		}
+	case time.Since(s.StartedAt) > s.Config.SyncMaxAge:
+		result.Status = StatusDegraded
+		result.Message = "no successful sync since start"
+	}
+	return result
+}
+
+func (s *HealthService) checkSwapi() CheckResult {
+	health := s.Swapi.Health()
+	result := CheckResult{Status: StatusOK, Details: health}
+	if health.State != CircuitClosed {
+		result.Status = StatusDegraded
+		result.Message = fmt.Sprintf("circuit is %s", health.State)
+	}
+	return result
+}
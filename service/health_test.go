@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type leaderStub bool
+
+func (l leaderStub) IsLeader() bool {
+	return bool(l)
+}
+
+type PingerStub struct {
+	Error error
+}
+
+func (p *PingerStub) Ping(_ context.Context) error {
+	return p.Error
+}
+
+func TestHealthService_Ready(t *testing.T) {
+
+	tests := []struct {
+		name           string
+		pinger         *PingerStub
+		sync           SyncStatus
+		leader         ILeader
+		startedAt      time.Time
+		circuit        string
+		expectedStatus string
+		expectedChecks map[string]string
+	}{
+		{
+			name:           "all dependencies healthy",
+			pinger:         &PingerStub{},
+			sync:           SyncStatus{LastSuccess: time.Now().Add(-time.Hour)},
+			startedAt:      time.Now().Add(-2 * time.Hour),
+			circuit:        CircuitClosed,
+			expectedStatus: StatusOK,
+			expectedChecks: map[string]string{"database": StatusOK, "sync": StatusOK, "swapi": StatusOK},
+		},
+		{
+			name:           "waiting for first sync",
+			pinger:         &PingerStub{},
+			startedAt:      time.Now(),
+			circuit:        CircuitClosed,
+			expectedStatus: StatusOK,
+			expectedChecks: map[string]string{"database": StatusOK, "sync": StatusOK, "swapi": StatusOK},
+		},
+		{
+			name:           "stale sync and open circuit degrade",
+			pinger:         &PingerStub{},
+			sync:           SyncStatus{LastSuccess: time.Now().Add(-10 * time.Hour)},
+			startedAt:      time.Now().Add(-12 * time.Hour),
+			circuit:        CircuitOpen,
+			expectedStatus: StatusDegraded,
+			expectedChecks: map[string]string{"database": StatusOK, "sync": StatusDegraded, "swapi": StatusDegraded},
+		},
+		{
+			name:           "follower never syncs",
+			pinger:         &PingerStub{},
+			leader:         leaderStub(false),
+			startedAt:      time.Now().Add(-12 * time.Hour),
+			circuit:        CircuitClosed,
+			expectedStatus: StatusOK,
+			expectedChecks: map[string]string{"database": StatusOK, "sync": StatusOK, "swapi": StatusOK},
+		},
+		{
+			name:           "leader never synced",
+			pinger:         &PingerStub{},
+			leader:         leaderStub(true),
+			startedAt:      time.Now().Add(-12 * time.Hour),
+			circuit:        CircuitClosed,
+			expectedStatus: StatusDegraded,
+			expectedChecks: map[string]string{"database": StatusOK, "sync": StatusDegraded, "swapi": StatusOK},
+		},
+		{
+			name:           "database down",
+			pinger:         &PingerStub{Error: errors.New("server selection timeout")},
+			startedAt:      time.Now(),
+			circuit:        CircuitOpen,
+			expectedStatus: StatusDown,
+			expectedChecks: map[string]string{"database": StatusDown, "sync": StatusOK, "swapi": StatusDegraded},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &HealthService{
+				Database:  tt.pinger,
+				Sync:      &APIService{syncStat: tt.sync, Leader: tt.leader},
+				Swapi:     &SwapiService{health: SwapiHealth{State: tt.circuit}},
+				Config:    &HealthConfig{PingTimeout: time.Second, SyncMaxAge: 8 * time.Hour},
+				StartedAt: tt.startedAt,
+			}
+
+			readiness := s.Ready(context.Background())
+			assert.Equal(t, tt.expectedStatus, readiness.Status)
+
+			checks := map[string]string{}
+			for name, check := range readiness.Checks {
+				checks[name] = check.Status
+			}
+			assert.Equal(t, tt.expectedChecks, checks)
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"github.com/gugabfigueiredo/star-wars-api/log"
+	"github.com/gugabfigueiredo/star-wars-api/repository"
+	"sync"
+	"time"
+)
+
+// LeaseConfig - Configuration for the scheduled sync lease
+type LeaseConfig struct {
+	// Name of the lease document shared by all replicas
+	Name string `default:"planet-sync"`
+	// Holder identifies this replica, defaults to the hostname
+	Holder string
+	// TTL is how long a lease is valid without being renewed
+	TTL time.Duration `default:"30s"`
+	// RenewInterval is how often the lease is renewed or contended, must be shorter than TTL
+	RenewInterval time.Duration `default:"10s"`
+}
+
+type ILeader interface {
+	IsLeader() bool
+}
+
+type LeaseStatus struct {
+	Name           string    `json:"name"`
+	Holder         string    `json:"holder"`
+	Leader         bool      `json:"leader"`
+	CurrentHolder  string    `json:"currentHolder"`
+	ExpiresAt      time.Time `json:"expiresAt"`
+	LastTransition time.Time `json:"lastTransition"`
+	Acquisitions   int64     `json:"acquisitions"`
+	Renewals       int64     `json:"renewals"`
+	Losses         int64     `json:"losses"`
+	Failures       int64     `json:"failures"`
+}
+
+// LeaseService campaigns for a lease so that only one replica owns the scheduled planet sync
+type LeaseService struct {
+	repository.ILeaseRepo
+	Config *LeaseConfig
+	Logger *log.Logger
+
+	mu     sync.RWMutex
+	status LeaseStatus
+}
+
+func (s *LeaseService) IsLeader() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.status.Leader && time.Now().Before(s.status.ExpiresAt)
+}
+
+func (s *LeaseService) Status() LeaseStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	status := s.status
+	status.Name = s.Config.Name
+	status.Holder = s.Config.Holder
+	status.Leader = status.Leader && time.Now().Before(status.ExpiresAt)
+	return status
+}
+
+// Campaign tries to acquire the lease right away and then renews or contends for it every RenewInterval.
+// The lease is released when ctx is done, and the returned channel is closed after that.
+func (s *LeaseService) Campaign(ctx context.Context) <-chan struct{} {
+	s.TryAcquire(ctx)
+
+	ticker := time.NewTicker(s.Config.RenewInterval)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-ticker.C:
+				s.TryAcquire(ctx)
+			case <-ctx.Done():
+				ticker.Stop()
+				// ctx is done already, give the release a short deadline of its own
+				release, cancel := context.WithTimeout(context.Background(), s.Config.RenewInterval)
+				s.Release(release)
+				cancel()
+				return
+			}
+		}
+	}()
+
+	return done
+}
+
+// TryAcquire makes a single attempt to acquire or renew the lease and reports whether this replica is the leader
+func (s *LeaseService) TryAcquire(ctx context.Context) bool {
+	logger := s.Logger.C("lease", s.Config.Name, "holder", s.Config.Holder)
+
+	lease, err := s.AcquireLease(ctx, s.Config.Name, s.Config.Holder, s.Config.TTL)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	wasLeader := s.status.Leader && now.Before(s.status.ExpiresAt)
+
+	switch {
+	case err == nil:
+		if wasLeader {
+			s.status.Renewals++
+		} else {
+			s.status.Acquisitions++
+			s.status.LastTransition = now
+			logger.I("acquired sync lease", "expiresAt", lease.ExpiresAt)
+		}
+		s.status.Leader = true
+		s.status.CurrentHolder = lease.Holder
+		s.status.ExpiresAt = lease.ExpiresAt
+	case err == repository.ErrLeaseHeld:
+		if s.status.Leader {
+			s.status.Losses++
+			s.status.LastTransition = now
+			logger.W("lost sync lease to another holder")
+		}
+		s.status.Leader = false
+		if current, err := s.GetLease(ctx, s.Config.Name); err == nil {
+			s.status.CurrentHolder = current.Holder
+			s.status.ExpiresAt = current.ExpiresAt
+		}
+	default:
+		// keep leading until the last known expiry, another replica cannot take over before that
+		s.status.Failures++
+		logger.E("failed to acquire sync lease", "err", err)
+		if s.status.Leader && !now.Before(s.status.ExpiresAt) {
+			s.status.Leader = false
+			s.status.Losses++
+			s.status.LastTransition = now
+			logger.W("sync lease expired while failing to renew")
+		}
+	}
+
+	return s.status.Leader
+}
+
+// Release gives the lease away so another replica can take over without waiting for it to expire
+func (s *LeaseService) Release(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.status.Leader {
+		return
+	}
+
+	if err := s.ReleaseLease(ctx, s.Config.Name, s.Config.Holder); err != nil {
+		s.Logger.E("failed to release sync lease", "err", err, "lease", s.Config.Name)
+	}
+
+	s.status.Leader = false
+	s.status.CurrentHolder = ""
+	s.status.LastTransition = time.Now()
+}
@@ -0,0 +1,105 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/gugabfigueiredo/star-wars-api/log"
+	"github.com/gugabfigueiredo/star-wars-api/test"
+	"github.com/gugabfigueiredo/star-wars-api/test/fakeswapi"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestLeaseService_SingleLeader(t *testing.T) {
+
+	logger := log.New(&log.Config{
+		Context:               "sw-api-test",
+		ConsoleLoggingEnabled: false,
+		EncodeLogsAsJson:      true,
+	})
+
+	leases := &test.LeaseStub{}
+	replica := func(holder string) *LeaseService {
+		return &LeaseService{
+			ILeaseRepo: leases,
+			Config:     &LeaseConfig{Name: "planet-sync", Holder: holder, TTL: 200 * time.Millisecond, RenewInterval: 50 * time.Millisecond},
+			Logger:     logger,
+		}
+	}
+
+	a, b := replica("a"), replica("b")
+
+	assert.True(t, a.TryAcquire(context.Background()))
+	assert.False(t, b.TryAcquire(context.Background()))
+	assert.True(t, a.TryAcquire(context.Background()))
+	assert.Equal(t, "a", b.Status().CurrentHolder)
+	assert.Equal(t, int64(1), a.Status().Acquisitions)
+	assert.Equal(t, int64(1), a.Status().Renewals)
+
+	// a releases on shutdown, b takes over right away
+	a.Release(context.Background())
+	assert.False(t, a.IsLeader())
+	assert.True(t, b.TryAcquire(context.Background()))
+
+	// b stops renewing, a takes over after the ttl
+	time.Sleep(250 * time.Millisecond)
+	assert.False(t, b.IsLeader())
+	assert.True(t, a.TryAcquire(context.Background()))
+	assert.False(t, b.TryAcquire(context.Background()))
+	assert.Equal(t, int64(1), b.Status().Losses)
+}
+
+func TestLeaseService_RenewFailure(t *testing.T) {
+
+	logger := log.New(&log.Config{
+		Context:               "sw-api-test",
+		ConsoleLoggingEnabled: false,
+		EncodeLogsAsJson:      true,
+	})
+
+	leases := &test.LeaseStub{}
+	s := &LeaseService{
+		ILeaseRepo: leases,
+		Config:     &LeaseConfig{Name: "planet-sync", Holder: "a", TTL: 200 * time.Millisecond, RenewInterval: 50 * time.Millisecond},
+		Logger:     logger,
+	}
+
+	assert.True(t, s.TryAcquire(context.Background()))
+
+	// keeps leading while the lease it holds has not expired
+	leases.Error = errors.New("database unreachable")
+	assert.True(t, s.TryAcquire(context.Background()))
+
+	time.Sleep(250 * time.Millisecond)
+	assert.False(t, s.TryAcquire(context.Background()))
+	assert.Equal(t, int64(2), s.Status().Failures)
+	assert.Equal(t, int64(1), s.Status().Losses)
+}
+
+func TestAPIService_ScheduleOnlyOnLeader(t *testing.T) {
+
+	logger := log.New(&log.Config{
+		Context:               "sw-api-test",
+		ConsoleLoggingEnabled: false,
+		EncodeLogsAsJson:      true,
+	})
+
+	upstream := fakeswapi.New(fakeswapi.MustLoadFixture("planets.json"))
+	defer upstream.Close()
+
+	s := &APIService{
+		IRepo:       &test.Stub{},
+		SwapiClient: NewSwapiClient(&SwapiConfig{BaseURL: upstream.URL, Timeout: time.Second}),
+		Leader:      &LeaseService{ILeaseRepo: &test.LeaseStub{}, Config: &LeaseConfig{}, Logger: logger},
+		Logger:      logger,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	schedule := s.SchedulePlanetUpdate(ctx, 100*time.Millisecond)
+
+	time.Sleep(350 * time.Millisecond)
+	cancel()
+	<-schedule
+	assert.Equal(t, 0, upstream.Requests(1))
+}
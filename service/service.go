@@ -1,60 +1,215 @@
 package service
 
 import (
+	"context"
 	"github.com/gugabfigueiredo/star-wars-api/log"
+	"github.com/gugabfigueiredo/star-wars-api/model"
 	"github.com/gugabfigueiredo/star-wars-api/repository"
 	"github.com/gugabfigueiredo/swapi"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+var tracer = otel.Tracer("github.com/gugabfigueiredo/star-wars-api/service")
+
 type IService interface {
 	repository.IRepo
-	UpdatePlanetRefs() error
+	UpdatePlanetRefs(ctx context.Context) (*model.SyncReport, error)
 }
 
 type ISwapi interface {
-	AllPlanets() ([]swapi.Planet, error)
+	AllPlanets(ctx context.Context) ([]swapi.Planet, error)
+}
+
+// ISyncObserver is told about every swapi sync, e.g. to record metrics
+type ISyncObserver interface {
+	ObserveSync(report *model.SyncReport, duration time.Duration, err error)
 }
 
 type APIService struct {
 	repository.IRepo
 	SwapiClient	ISwapi
+	Leader      ILeader
+	MergePolicy model.MergePolicy
+	Observer    ISyncObserver
 	Logger      *log.Logger
+
+	mu         sync.RWMutex
+	syncStat   SyncStatus
+	scheduled  int32
+	syncing    int32
+	reschedule chan time.Duration
+}
+
+type SyncStatus struct {
+	LastSuccess time.Time `json:"lastSuccess"`
+	LastFailure time.Time `json:"lastFailure"`
+	LastError   string    `json:"lastError,omitempty"`
+}
+
+func (api *APIService) SyncStatus() SyncStatus {
+	api.mu.RLock()
+	defer api.mu.RUnlock()
+	return api.syncStat
+}
+
+// Scheduled reports whether the scheduled update is running
+func (api *APIService) Scheduled() bool {
+	return atomic.LoadInt32(&api.scheduled) == 1
 }
 
-func (api *APIService) UpdatePlanetRefs() error {
+// Syncing reports whether an update is in progress
+func (api *APIService) Syncing() bool {
+	return atomic.LoadInt32(&api.syncing) > 0
+}
+
+func (api *APIService) recordSync(report *model.SyncReport, started time.Time, err error) {
+	atomic.AddInt32(&api.syncing, -1)
+	if api.Observer != nil {
+		api.Observer.ObserveSync(report, time.Since(started), err)
+	}
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if err != nil {
+		api.syncStat.LastFailure = time.Now()
+		api.syncStat.LastError = err.Error()
+		return
+	}
+	api.syncStat.LastSuccess = time.Now()
+}
+
+func (api *APIService) UpdatePlanetRefs(ctx context.Context) (report *model.SyncReport, err error) {
+	ctx, span := tracer.Start(ctx, "APIService.UpdatePlanetRefs")
+	defer span.End()
+
+	started := time.Now()
+	atomic.AddInt32(&api.syncing, 1)
+	defer func() { api.recordSync(report, started, err) }()
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return
+		}
+		span.SetAttributes(
+			attribute.Int("sync.planets", report.Planets),
+			attribute.Int64("sync.modified", report.Modified),
+			attribute.Int64("sync.upserted", report.Upserted),
+			attribute.Int("sync.conflicts", len(report.Conflicts)),
+		)
+	}()
+
+	api.mu.RLock()
+	policy := api.MergePolicy
+	api.mu.RUnlock()
+
+	report = &model.SyncReport{
+		Policy:    policy,
+		StartedAt: started,
+	}
+	if report.Policy == "" {
+		report.Policy = model.UpstreamWins
+	}
+
 	// get all planets from swapi
-	planets, err := api.SwapiClient.AllPlanets()
+	planets, err := api.SwapiClient.AllPlanets(ctx)
 	if err != nil {
 		api.Logger.E("failed to query swapi for planet data", "err", err)
-		return err
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		api.Logger.W("planet update cancelled before writing to database", "err", err)
+		return nil, err
 	}
 	// update planets
-	res, err := api.UpdateMovieRefs(planets)
+	res, conflicts, err := api.UpdateMovieRefs(ctx, planets, report.Policy)
 	if err != nil {
 		api.Logger.E("failed to write planets to database", "err", err, "result", res)
-		return err
+		return nil, err
+	}
+
+	report.FinishedAt = time.Now()
+	report.Planets = len(planets)
+	report.Conflicts = conflicts
+	if res != nil {
+		report.Matched = res.MatchedCount
+		report.Modified = res.ModifiedCount
+		report.Upserted = res.UpsertedCount
+	}
+
+	if len(conflicts) > 0 {
+		api.Logger.W("planet fields conflicted with swapi during sync", "policy", report.Policy, "conflicts", len(conflicts))
+	}
+	return report, nil
+}
+
+// SetMergePolicy changes the merge policy of the next syncs
+func (api *APIService) SetMergePolicy(policy model.MergePolicy) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.MergePolicy = policy
+}
+
+// Reschedule changes the interval of a running schedule, the next update is one interval from now.
+// It reports false if no schedule is running.
+func (api *APIService) Reschedule(interval time.Duration) bool {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if api.reschedule == nil {
+		return false
+	}
+	// drop a pending interval that was not picked up yet, the latest one wins
+	select {
+	case <-api.reschedule:
+	default:
 	}
-	return nil
+	api.reschedule <- interval
+	return true
 }
 
-func (api *APIService) SchedulePlanetUpdate(interval time.Duration) chan bool {
+// SchedulePlanetUpdate updates planets every interval until ctx is done, cancelling a running update.
+// The returned channel is closed once the schedule has stopped.
+func (api *APIService) SchedulePlanetUpdate(ctx context.Context, interval time.Duration) <-chan struct{} {
 	// start ticker to update database
 	ticker := time.NewTicker(interval)
-	quit := make(chan bool)
+	done := make(chan struct{})
+	reschedule := make(chan time.Duration, 1)
+
+	api.mu.Lock()
+	api.reschedule = reschedule
+	api.mu.Unlock()
+
+	atomic.StoreInt32(&api.scheduled, 1)
 	go func() {
+		defer close(done)
+		defer atomic.StoreInt32(&api.scheduled, 0)
 		for {
 			select {
 			case <- ticker.C:
-				if err := api.UpdatePlanetRefs(); err != nil {
+				if api.Leader != nil && !api.Leader.IsLeader() {
+					api.Logger.D("skipping scheduled planet update, this replica does not hold the sync lease")
+					continue
+				}
+				if _, err := api.UpdatePlanetRefs(ctx); err != nil {
 					api.Logger.E("failed to update planet references", "err", err)
 				}
-			case <- quit:
+			case interval := <-reschedule:
+				ticker.Reset(interval)
+				api.Logger.I("rescheduled planet updates", "interval", interval)
+			case <- ctx.Done():
 				ticker.Stop()
+				api.mu.Lock()
+				api.reschedule = nil
+				api.mu.Unlock()
 				return
 			}
 		}
 	}()
 
-	return quit
+	return done
 }
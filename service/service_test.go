@@ -1,30 +1,139 @@
 package service
 
 import (
+	"context"
 	"errors"
 	"github.com/gugabfigueiredo/star-wars-api/log"
+	"github.com/gugabfigueiredo/star-wars-api/model"
 	"github.com/gugabfigueiredo/star-wars-api/test"
+	"github.com/gugabfigueiredo/star-wars-api/test/fakeswapi"
 	"github.com/gugabfigueiredo/swapi"
 	"github.com/stretchr/testify/assert"
+	"net/http"
 	"testing"
 	"time"
 )
 
+func TestAPIService_UpdatePlanetRefs(t *testing.T) {
+
+	tests := []struct {
+		name            string
+		options         []fakeswapi.Option
+		stub            *test.Stub
+		expectErr       bool
+		expectedPlanets int
+		expectedPages   []int
+	}{
+		{
+			name:            "single page",
+			stub:            &test.Stub{},
+			options:         []fakeswapi.Option{fakeswapi.PageSize(20)},
+			expectedPlanets: 13,
+			expectedPages:   []int{1},
+		},
+		{
+			name:            "follows pagination",
+			stub:            &test.Stub{},
+			options:         []fakeswapi.Option{fakeswapi.PageSize(5)},
+			expectedPlanets: 13,
+			expectedPages:   []int{1, 1, 1},
+		},
+		{
+			name:          "malformed page",
+			stub:          &test.Stub{},
+			options:       []fakeswapi.Option{fakeswapi.PageSize(5), fakeswapi.Malformed(2)},
+			expectErr:     true,
+			expectedPages: []int{1, 1, 0},
+		},
+		{
+			name:          "slow upstream",
+			stub:          &test.Stub{},
+			options:       []fakeswapi.Option{fakeswapi.Latency(300 * time.Millisecond)},
+			expectErr:     true,
+			expectedPages: []int{4},
+		},
+		{
+			name:            "retries server errors",
+			stub:            &test.Stub{},
+			options:         []fakeswapi.Option{fakeswapi.PageSize(5), fakeswapi.Errors(http.StatusBadGateway, 2)},
+			expectedPlanets: 13,
+			expectedPages:   []int{3, 1, 1},
+		},
+		{
+			name:          "retries rate limited requests",
+			stub:          &test.Stub{},
+			options:       []fakeswapi.Option{fakeswapi.PageSize(5), fakeswapi.RateLimit(2, time.Hour)},
+			expectErr:     true,
+			expectedPages: []int{4, 1, 1},
+		},
+		{
+			name:          "fail to write planets",
+			stub:          &test.Stub{Error: errors.New("failed to write planets")},
+			expectErr:     true,
+			expectedPages: []int{1, 1},
+		},
+	}
+
+	logger := log.New(&log.Config{
+		Context:               "sw-api-test",
+		ConsoleLoggingEnabled: false,
+		EncodeLogsAsJson:      true,
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := fakeswapi.New(fakeswapi.MustLoadFixture("planets.json"), tt.options...)
+			defer upstream.Close()
+
+			config := &SwapiConfig{
+				BaseURL:          upstream.URL,
+				Timeout:          200 * time.Millisecond,
+				MaxRetries:       3,
+				BackoffBase:      50 * time.Millisecond,
+				BackoffMax:       100 * time.Millisecond,
+				FailureThreshold: 5,
+				OpenTimeout:      time.Minute,
+			}
+
+			s := &APIService{
+				IRepo:       tt.stub,
+				SwapiClient: &SwapiService{ISwapi: NewSwapiClient(config), Config: config, Logger: logger},
+				Logger:      logger,
+			}
+
+			report, err := s.UpdatePlanetRefs(context.Background())
+			assert.Equal(t, tt.expectErr, err != nil)
+
+			if tt.expectedPlanets > 0 {
+				planets, _ := tt.stub.CalledWith["planets"].([]swapi.Planet)
+				assert.Equal(t, tt.expectedPlanets, len(planets))
+				assert.Equal(t, tt.expectedPlanets, report.Planets)
+				assert.Equal(t, model.UpstreamWins, report.Policy)
+			}
+			for i, requests := range tt.expectedPages {
+				assert.Equal(t, requests, upstream.Requests(i+1), "requests to page %d", i+1)
+			}
+		})
+	}
+}
+
 func TestAPIHandler_SchedulePlanetRefsUpdate(t *testing.T) {
 
-	tests := []struct{
-		name               	string
-		stub               	*test.Stub
-		expectedUpdates		int
+	tests := []struct {
+		name            string
+		stub            *test.Stub
+		options         []fakeswapi.Option
+		expectedUpdates int
 	}{
 		{
-			name: "update planet refs",
-			stub: &test.Stub{},
+			name:            "update planet refs",
+			stub:            &test.Stub{},
 			expectedUpdates: 3,
 		},
 		{
-			name: "fail to get updated refs",
-			stub: &test.Stub{Error: errors.New("failed to get updated refs")},
+			name:    "fail to get updated refs",
+			stub:    &test.Stub{},
+			options: []fakeswapi.Option{fakeswapi.Errors(http.StatusInternalServerError, 10)},
 		},
 	}
 
@@ -36,34 +145,98 @@ func TestAPIHandler_SchedulePlanetRefsUpdate(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-
-			swapiStub := &SwapiStub{Error: tt.stub.Error}
+			upstream := fakeswapi.New(fakeswapi.MustLoadFixture("planets.json"), tt.options...)
+			defer upstream.Close()
 
 			s := &APIService{
-				IRepo: tt.stub,
-				SwapiClient: swapiStub,
-				Logger:   logger,
+				IRepo:       tt.stub,
+				SwapiClient: NewSwapiClient(&SwapiConfig{BaseURL: upstream.URL, Timeout: time.Second}),
+				Logger:      logger,
 			}
 
-			schedule := s.SchedulePlanetUpdate(time.Second)
+			ctx, cancel := context.WithCancel(context.Background())
+			schedule := s.SchedulePlanetUpdate(ctx, 100*time.Millisecond)
 
-			time.Sleep(3 * time.Second)
-			schedule <- false
-			close(schedule)
-			assert.Equal(t, tt.expectedUpdates, swapiStub.SwapiUpdates)
+			time.Sleep(350 * time.Millisecond)
+			cancel()
+			<-schedule
+			assert.Equal(t, tt.expectedUpdates, upstream.Requests(2))
+			assert.Equal(t, tt.expectedUpdates > 0, tt.stub.CalledWith != nil)
 		})
 	}
 }
 
-type SwapiStub struct {
-	Error	error
-	SwapiUpdates int
+func TestAPIService_CancelRunningUpdate(t *testing.T) {
+
+	logger := log.New(&log.Config{
+		Context:               "sw-api-test",
+		ConsoleLoggingEnabled: false,
+		EncodeLogsAsJson:      true,
+	})
+
+	upstream := fakeswapi.New(fakeswapi.MustLoadFixture("planets.json"), fakeswapi.Latency(time.Second))
+	defer upstream.Close()
+
+	stub := &test.Stub{}
+	config := &SwapiConfig{BaseURL: upstream.URL, Timeout: 5 * time.Second, MaxRetries: 3, BackoffBase: time.Second, BackoffMax: time.Second, FailureThreshold: 5}
+	swapiService := &SwapiService{ISwapi: NewSwapiClient(config), Config: config, Logger: logger}
+	s := &APIService{
+		IRepo:       stub,
+		SwapiClient: swapiService,
+		Logger:      logger,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	schedule := s.SchedulePlanetUpdate(ctx, 50*time.Millisecond)
+
+	// let the first update reach the slow upstream, then shut down
+	time.Sleep(200 * time.Millisecond)
+	started := time.Now()
+	cancel()
+
+	select {
+	case <-schedule:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("schedule did not stop after cancellation")
+	}
+	assert.True(t, time.Since(started) < 500*time.Millisecond)
+	assert.Nil(t, stub.CalledWith)
+	assert.Equal(t, 1, upstream.Requests(1))
+	assert.Equal(t, CircuitClosed, swapiService.Health().State)
+	assert.Equal(t, 0, swapiService.Health().ConsecutiveFailures)
 }
 
+func TestAPIService_Reschedule(t *testing.T) {
+
+	logger := log.New(&log.Config{
+		Context:               "sw-api-test",
+		ConsoleLoggingEnabled: false,
+		EncodeLogsAsJson:      true,
+	})
+
+	upstream := fakeswapi.New(fakeswapi.MustLoadFixture("planets.json"))
+	defer upstream.Close()
 
-func (s *SwapiStub) AllPlanets() ([]swapi.Planet, error) {
-	if s.Error == nil {
-		s.SwapiUpdates += 1
+	s := &APIService{
+		IRepo:       &test.Stub{},
+		SwapiClient: NewSwapiClient(&SwapiConfig{BaseURL: upstream.URL, Timeout: time.Second}),
+		Logger:      logger,
 	}
-	return []swapi.Planet{}, s.Error
-}
\ No newline at end of file
+	assert.False(t, s.Reschedule(time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	schedule := s.SchedulePlanetUpdate(ctx, time.Hour)
+
+	// nothing runs an hour out, until the interval is brought down
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 0, upstream.Requests(1))
+
+	assert.True(t, s.Reschedule(20*time.Millisecond))
+	s.SetMergePolicy(model.LocalWins)
+	assert.Eventually(t, func() bool { return upstream.Requests(1) > 0 }, time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool { return !s.SyncStatus().LastSuccess.IsZero() }, time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-schedule
+	assert.False(t, s.Reschedule(time.Millisecond))
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gugabfigueiredo/swapi"
+	"io"
+	"io/ioutil"
+)
+
+const (
+	SourceLive     = "live"
+	SourceSnapshot = "snapshot"
+)
+
+// snapshotPage has the shape of a paginated swapi response
+type snapshotPage struct {
+	Count    int            `json:"count"`
+	Next     *string        `json:"next"`
+	Previous *string        `json:"previous"`
+	Results  []swapi.Planet `json:"results"`
+}
+
+// SnapshotSwapi serves planets from a local file instead of swapi.
+// The file holds either a single swapi page or a list of pages, and is read again on every call.
+type SnapshotSwapi struct {
+	Path string
+}
+
+func (s *SnapshotSwapi) AllPlanets(ctx context.Context) ([]swapi.Planet, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var pages []snapshotPage
+	if data = bytes.TrimSpace(data); len(data) > 0 && data[0] == '[' {
+		err = json.Unmarshal(data, &pages)
+	} else {
+		pages = make([]snapshotPage, 1)
+		err = json.Unmarshal(data, &pages[0])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading snapshot %s: %s", s.Path, err)
+	}
+
+	var planets []swapi.Planet
+	for _, page := range pages {
+		planets = append(planets, page.Results...)
+	}
+	return planets, nil
+}
+
+// NewUpstream returns the bare planet source selected by config.Source, a SwapiClient for live swapi or a
+// SnapshotSwapi for the snapshot file. Retries and the circuit breaker are added by the SwapiService reading it.
+func NewUpstream(config *SwapiConfig) (ISwapi, error) {
+	switch config.Source {
+	case SourceLive, "":
+		return NewSwapiClient(config), nil
+	case SourceSnapshot:
+		return &SnapshotSwapi{Path: config.SnapshotFile}, nil
+	default:
+		return nil, fmt.Errorf("unknown swapi source %q", config.Source)
+	}
+}
+
+// ExportSnapshot writes all planets from client to w as a single swapi page, readable by SnapshotSwapi
+func ExportSnapshot(ctx context.Context, w io.Writer, client ISwapi) error {
+	planets, err := client.AllPlanets(ctx)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(snapshotPage{
+		Count:   len(planets),
+		Results: planets,
+	})
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshotSwapi_AllPlanets(t *testing.T) {
+
+	tests := []struct {
+		name            string
+		snapshot        string
+		expectedPlanets []string
+		expectErr       bool
+	}{
+		{
+			name:            "single page",
+			snapshot:        `{"count": 2, "next": null, "previous": null, "results": [{"name": "Tatooine"}, {"name": "Hoth"}]}`,
+			expectedPlanets: []string{"Tatooine", "Hoth"},
+		},
+		{
+			name: "list of pages",
+			snapshot: `[
+				{"count": 3, "next": "https://swapi.dev/api/planets/?page=2", "previous": null, "results": [{"name": "Tatooine"}, {"name": "Hoth"}]},
+				{"count": 3, "next": null, "previous": "https://swapi.dev/api/planets/?page=1", "results": [{"name": "Naboo"}]}
+			]`,
+			expectedPlanets: []string{"Tatooine", "Hoth", "Naboo"},
+		},
+		{
+			name:      "malformed snapshot",
+			snapshot:  `{"results": [{"name": 1}]}`,
+			expectErr: true,
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "swapi-snapshot")
+	if err != nil {
+		t.Fatalf("could not create temp dir. err %+v\n", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, string(rune('a'+i))+".json")
+			if err := ioutil.WriteFile(path, []byte(tt.snapshot), 0644); err != nil {
+				t.Fatalf("could not write snapshot. err %+v\n", err)
+			}
+
+			planets, err := (&SnapshotSwapi{Path: path}).AllPlanets(context.Background())
+			assert.Equal(t, tt.expectErr, err != nil)
+
+			var names []string
+			for _, planet := range planets {
+				names = append(names, planet.Name)
+			}
+			assert.Equal(t, tt.expectedPlanets, names)
+		})
+	}
+}
+
+func TestExportSnapshot(t *testing.T) {
+
+	stub := &FlakySwapiStub{}
+	var buf bytes.Buffer
+	assert.NoError(t, ExportSnapshot(context.Background(), &buf, stub))
+
+	path := filepath.Join(os.TempDir(), "swapi-export-test.json")
+	defer os.Remove(path)
+	assert.NoError(t, ioutil.WriteFile(path, buf.Bytes(), 0644))
+
+	planets, err := (&SnapshotSwapi{Path: path}).AllPlanets(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(planets))
+	assert.Equal(t, "Tatooine", planets[0].Name)
+
+	bundled, err := (&SnapshotSwapi{Path: "../database/swapi/planets.json"}).AllPlanets(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 10, len(bundled))
+}
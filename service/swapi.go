@@ -1,11 +1,422 @@
 package service
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/gugabfigueiredo/star-wars-api/log"
 	"github.com/gugabfigueiredo/swapi"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/errgroup"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
+var ErrCircuitOpen = errors.New("swapi circuit breaker is open")
+
+// SwapiConfig - Configuration for the swapi upstream client
+type SwapiConfig struct {
+	// Source of planet data, either live for swapi or snapshot for SnapshotFile
+	Source string `default:"live"`
+	// SnapshotFile is a json file in the shape of swapi responses, used when Source is snapshot
+	SnapshotFile string `default:"database/swapi/planets.json"`
+	// MergePolicy for fields edited manually: upstream-wins, local-wins or fill-empty
+	MergePolicy string `default:"upstream-wins" reload:"true"`
+	// BaseURL overrides the swapi location, e.g. http://localhost:9090
+	BaseURL string
+	// Timeout is the deadline for a single call to swapi, pagination included
+	Timeout time.Duration `default:"1m"`
+	// MaxRetries is how many times a failed call is retried on 5xx or network errors
+	MaxRetries int `default:"3"`
+	// BackoffBase is the wait before the first retry, doubled on each retry up to BackoffMax
+	BackoffBase time.Duration `default:"500ms"`
+	BackoffMax  time.Duration `default:"10s"`
+	// FailureThreshold is the number of consecutive failures that opens the circuit
+	FailureThreshold int `default:"5"`
+	// OpenTimeout is how long the circuit stays open before a trial call is let through
+	OpenTimeout time.Duration `default:"1m"`
+}
+
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// UpstreamError is returned for swapi responses with a status code that should not be decoded
+type UpstreamError struct {
+	StatusCode int
+}
+
+func (e *UpstreamError) Error() string {
+	return fmt.Sprintf("swapi responded with status %d", e.StatusCode)
+}
+
+// statusTransport binds requests to the caller context and turns 5xx and 429 responses into errors,
+// the swapi client would try to decode them otherwise. Binding the context also parents the span
+// of every page request to the caller's span.
+type statusTransport struct {
+	http.RoundTripper
+	ctx context.Context
+}
+
+func (t *statusTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req.WithContext(t.ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests {
+		resp.Body.Close()
+		return nil, &UpstreamError{StatusCode: resp.StatusCode}
+	}
+	return resp, nil
+}
+
+// SwapiClient is an ISwapi over swapi.Client whose requests are cancelled with the caller context
+type SwapiClient struct {
+	Config *SwapiConfig
+}
+
+func NewSwapiClient(config *SwapiConfig) *SwapiClient {
+	return &SwapiClient{Config: config}
+}
+
+func (c *SwapiClient) AllPlanets(ctx context.Context) ([]swapi.Planet, error) {
+	return c.client(ctx).AllPlanets()
+}
+
+// client is a swapi.Client whose requests are bound to ctx
+func (c *SwapiClient) client(ctx context.Context) *swapi.Client {
+	options := []swapi.Option{
+		swapi.HTTPClient(&http.Client{
+			Timeout:   c.Config.Timeout,
+			Transport: &statusTransport{RoundTripper: otelhttp.NewTransport(http.DefaultTransport), ctx: ctx},
+		}),
+	}
+	if c.Config.BaseURL != "" {
+		options = append(options, swapi.BaseURL(c.Config.BaseURL))
+	}
+	return swapi.NewClient(options...)
+}
+
+// relatedConcurrency is how many films or people are requested from swapi at once
+const relatedConcurrency = 4
+
+func (c *SwapiClient) Films(ctx context.Context, urls []string) (map[string]swapi.Film, error) {
+	films := make(map[string]swapi.Film, len(urls))
+	err := c.fetchEach(ctx, urls, func(client *swapi.Client, id int, url string, mu *sync.Mutex) error {
+		film, err := client.Film(id)
+		// swapi answers unknown ids with a body that decodes to an empty film
+		if err != nil || film.URL == "" {
+			return err
+		}
+		mu.Lock()
+		films[url] = film
+		mu.Unlock()
+		return nil
+	})
+	return films, err
+}
+
+func (c *SwapiClient) People(ctx context.Context, urls []string) (map[string]swapi.Person, error) {
+	people := make(map[string]swapi.Person, len(urls))
+	err := c.fetchEach(ctx, urls, func(client *swapi.Client, id int, url string, mu *sync.Mutex) error {
+		person, err := client.Person(id)
+		if err != nil || person.URL == "" {
+			return err
+		}
+		mu.Lock()
+		people[url] = person
+		mu.Unlock()
+		return nil
+	})
+	return people, err
+}
+
+// fetchEach calls fetch with the swapi id of every url, relatedConcurrency at a time, and returns the first error.
+// Urls without an id are skipped. Entities are fetched from the configured swapi whatever the host of their url.
+func (c *SwapiClient) fetchEach(ctx context.Context, urls []string,
+	fetch func(client *swapi.Client, id int, url string, mu *sync.Mutex) error) error {
+
+	group, ctx := errgroup.WithContext(ctx)
+	client := c.client(ctx)
+	slots := make(chan struct{}, relatedConcurrency)
+	var mu sync.Mutex
+	for _, url := range urls {
+		url := url
+		id, err := swapiID(url)
+		if err != nil {
+			continue
+		}
+		group.Go(func() error {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			defer func() { <-slots }()
+			return fetch(client, id, url, &mu)
+		})
+	}
+	return group.Wait()
+}
+
+// swapiID is the id at the end of a swapi url, like 1 in https://swapi.dev/api/films/1/
+func swapiID(url string) (int, error) {
+	parts := strings.Split(strings.TrimSuffix(url, "/"), "/")
+	return strconv.Atoi(parts[len(parts)-1])
+}
+
+// IRelated reads the films and people planets refer to by their swapi urls. Urls of entities it does not find
+// are left out of the results.
+type IRelated interface {
+	Films(ctx context.Context, urls []string) (map[string]swapi.Film, error)
+	People(ctx context.Context, urls []string) (map[string]swapi.Person, error)
+}
+
+type SwapiHealth struct {
+	State               string    `json:"state"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	LastError           string    `json:"lastError,omitempty"`
+	LastFailure         time.Time `json:"lastFailure"`
+	LastSuccess         time.Time `json:"lastSuccess"`
+	OpenedAt            time.Time `json:"openedAt"`
+}
+
+// SwapiService decorates an ISwapi with call deadlines, retries with exponential backoff and a circuit breaker
 type SwapiService struct {
-	*swapi.Client
-	Logger *log.Logger
-}
\ No newline at end of file
+	ISwapi
+	// Related is the source of the films and people planets refer to, nil when the upstream has none, like snapshots
+	Related IRelated
+	Config  *SwapiConfig
+	Logger  *log.Logger
+
+	mu     sync.Mutex
+	health SwapiHealth
+	trial  bool
+}
+
+func (s *SwapiService) AllPlanets(ctx context.Context) (planets []swapi.Planet, err error) {
+	ctx, span := tracer.Start(ctx, "SwapiService.AllPlanets")
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	for attempt := 0; attempt <= s.Config.MaxRetries; attempt++ {
+		if attempt > 0 {
+			wait := s.backoff(attempt)
+			s.Logger.W("retrying swapi call", "attempt", attempt, "wait", wait, "err", err)
+			span.AddEvent("retry", trace.WithAttributes(
+				attribute.Int("attempt", attempt),
+				attribute.String("wait", wait.String()),
+				attribute.String("error", err.Error()),
+			))
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		if err = s.allow(); err != nil {
+			return nil, err
+		}
+
+		planets, err = s.call(ctx)
+		if ctx.Err() != nil {
+			// the caller gave up, that says nothing about the upstream health
+			s.abandon()
+			return nil, ctx.Err()
+		}
+		s.record(err)
+		if err == nil {
+			return planets, nil
+		}
+
+		if !Retryable(err) {
+			return nil, err
+		}
+	}
+
+	return nil, err
+}
+
+// Films reads films from Related through the circuit breaker, under the call deadline and without retries.
+// Without Related there are no films.
+func (s *SwapiService) Films(ctx context.Context, urls []string) (films map[string]swapi.Film, err error) {
+	if s.Related == nil {
+		return nil, nil
+	}
+	err = s.related(ctx, "SwapiService.Films", len(urls), func(ctx context.Context) (err error) {
+		films, err = s.Related.Films(ctx, urls)
+		return err
+	})
+	return films, err
+}
+
+// People reads people like Films
+func (s *SwapiService) People(ctx context.Context, urls []string) (people map[string]swapi.Person, err error) {
+	if s.Related == nil {
+		return nil, nil
+	}
+	err = s.related(ctx, "SwapiService.People", len(urls), func(ctx context.Context) (err error) {
+		people, err = s.Related.People(ctx, urls)
+		return err
+	})
+	return people, err
+}
+
+// related runs a single call for films or people, recorded by the circuit breaker like planet calls
+func (s *SwapiService) related(ctx context.Context, name string, urls int, call func(context.Context) error) (err error) {
+	ctx, span := tracer.Start(ctx, name, trace.WithAttributes(attribute.Int("urls", urls)))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	if err := s.allow(); err != nil {
+		return err
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, s.Config.Timeout)
+	defer cancel()
+	err = call(callCtx)
+	if ctx.Err() != nil {
+		s.abandon()
+		return ctx.Err()
+	}
+	s.record(err)
+	return err
+}
+
+func (s *SwapiService) Health() SwapiHealth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	health := s.health
+	if health.State == "" {
+		health.State = CircuitClosed
+	}
+	return health
+}
+
+// call runs a single upstream call under the configured deadline
+func (s *SwapiService) call(ctx context.Context) ([]swapi.Planet, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.Config.Timeout)
+	defer cancel()
+
+	type result struct {
+		planets []swapi.Planet
+		err     error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		planets, err := s.ISwapi.AllPlanets(ctx)
+		done <- result{planets, err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.planets, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *SwapiService) backoff(attempt int) time.Duration {
+	wait := s.Config.BackoffBase << uint(attempt-1)
+	if wait <= 0 || wait > s.Config.BackoffMax {
+		wait = s.Config.BackoffMax
+	}
+	// jitter keeps replicas from retrying in lockstep
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+// allow reports whether the circuit lets a call through, moving from open to half-open after OpenTimeout
+func (s *SwapiService) allow() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch s.health.State {
+	case CircuitOpen:
+		if time.Since(s.health.OpenedAt) < s.Config.OpenTimeout {
+			return ErrCircuitOpen
+		}
+		s.health.State = CircuitHalfOpen
+		s.trial = true
+		s.Logger.I("swapi circuit half-open, letting a trial call through")
+		return nil
+	case CircuitHalfOpen:
+		// only one trial call at a time
+		if s.trial {
+			return ErrCircuitOpen
+		}
+		s.trial = true
+	}
+	return nil
+}
+
+// abandon ends a call without recording its outcome, letting the next call be the trial of a half-open circuit
+func (s *SwapiService) abandon() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trial = false
+}
+
+func (s *SwapiService) record(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.trial = false
+	now := time.Now()
+
+	if err == nil {
+		if s.health.State == CircuitHalfOpen {
+			s.Logger.I("swapi circuit closed")
+		}
+		s.health.State = CircuitClosed
+		s.health.ConsecutiveFailures = 0
+		s.health.LastSuccess = now
+		return
+	}
+
+	s.health.ConsecutiveFailures++
+	s.health.LastError = err.Error()
+	s.health.LastFailure = now
+
+	if s.health.State == CircuitHalfOpen || s.health.ConsecutiveFailures >= s.Config.FailureThreshold {
+		if s.health.State != CircuitOpen {
+			s.Logger.W("swapi circuit opened", "failures", s.health.ConsecutiveFailures, "err", err)
+		}
+		s.health.State = CircuitOpen
+		s.health.OpenedAt = now
+	}
+}
+
+// Retryable reports whether err is a transient upstream failure: a 5xx or 429 response, a network error or a timeout
+func Retryable(err error) bool {
+	var upstream *UpstreamError
+	if errors.As(err, &upstream) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,287 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/gugabfigueiredo/star-wars-api/log"
+	"github.com/gugabfigueiredo/swapi"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// FlakySwapiStub fails the first Failures calls with Error
+type FlakySwapiStub struct {
+	Error    error
+	Failures int
+	Delay    time.Duration
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (s *FlakySwapiStub) Calls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls
+}
+
+func (s *FlakySwapiStub) AllPlanets(_ context.Context) ([]swapi.Planet, error) {
+	s.mu.Lock()
+	s.calls++
+	call := s.calls
+	s.mu.Unlock()
+
+	time.Sleep(s.Delay)
+	if call <= s.Failures {
+		return nil, s.Error
+	}
+	return []swapi.Planet{{Name: "Tatooine"}}, nil
+}
+
+func TestSwapiService_AllPlanets(t *testing.T) {
+
+	tests := []struct {
+		name            string
+		stub            *FlakySwapiStub
+		expectedCalls   int
+		expectedErr     error
+		expectedState   string
+		expectedPlanets int
+	}{
+		{
+			name:            "succeeds on first call",
+			stub:            &FlakySwapiStub{},
+			expectedCalls:   1,
+			expectedState:   CircuitClosed,
+			expectedPlanets: 1,
+		},
+		{
+			name:            "retries upstream errors",
+			stub:            &FlakySwapiStub{Error: &UpstreamError{StatusCode: http.StatusBadGateway}, Failures: 2},
+			expectedCalls:   3,
+			expectedState:   CircuitClosed,
+			expectedPlanets: 1,
+		},
+		{
+			name:          "does not retry malformed payloads",
+			stub:          &FlakySwapiStub{Error: errors.New("error reading response"), Failures: 1},
+			expectedCalls: 1,
+			expectedErr:   errors.New("error reading response"),
+			expectedState: CircuitClosed,
+		},
+		{
+			name:          "opens circuit after repeated failures",
+			stub:          &FlakySwapiStub{Error: &UpstreamError{StatusCode: http.StatusServiceUnavailable}, Failures: 10},
+			expectedCalls: 3,
+			expectedErr:   ErrCircuitOpen,
+			expectedState: CircuitOpen,
+		},
+		{
+			name:          "times out slow calls",
+			stub:          &FlakySwapiStub{Delay: 100 * time.Millisecond},
+			expectedCalls: 3,
+			expectedErr:   ErrCircuitOpen,
+			expectedState: CircuitOpen,
+		},
+	}
+
+	logger := log.New(&log.Config{
+		Context:               "sw-api-test",
+		ConsoleLoggingEnabled: false,
+		EncodeLogsAsJson:      true,
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &SwapiService{
+				ISwapi: tt.stub,
+				Config: &SwapiConfig{
+					Timeout:          50 * time.Millisecond,
+					MaxRetries:       3,
+					BackoffBase:      time.Millisecond,
+					BackoffMax:       5 * time.Millisecond,
+					FailureThreshold: 3,
+					OpenTimeout:      time.Minute,
+				},
+				Logger: logger,
+			}
+
+			planets, err := s.AllPlanets(context.Background())
+			assert.Equal(t, tt.expectedErr, err)
+			assert.Equal(t, tt.expectedPlanets, len(planets))
+			assert.Equal(t, tt.expectedCalls, tt.stub.Calls())
+			assert.Equal(t, tt.expectedState, s.Health().State)
+		})
+	}
+}
+
+func TestSwapiService_HalfOpen(t *testing.T) {
+
+	logger := log.New(&log.Config{
+		Context:               "sw-api-test",
+		ConsoleLoggingEnabled: false,
+		EncodeLogsAsJson:      true,
+	})
+
+	stub := &FlakySwapiStub{Error: &UpstreamError{StatusCode: http.StatusInternalServerError}, Failures: 1}
+	s := &SwapiService{
+		ISwapi: stub,
+		Config: &SwapiConfig{Timeout: time.Second, FailureThreshold: 1, OpenTimeout: 50 * time.Millisecond},
+		Logger: logger,
+	}
+
+	_, err := s.AllPlanets(context.Background())
+	assert.Equal(t, stub.Error, err)
+	assert.Equal(t, CircuitOpen, s.Health().State)
+
+	_, err = s.AllPlanets(context.Background())
+	assert.Equal(t, ErrCircuitOpen, err)
+	assert.Equal(t, 1, stub.Calls())
+
+	time.Sleep(60 * time.Millisecond)
+	_, err = s.AllPlanets(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, CircuitClosed, s.Health().State)
+}
+
+func TestSwapiService_HalfOpenCancelled(t *testing.T) {
+
+	logger := log.New(&log.Config{
+		Context:               "sw-api-test",
+		ConsoleLoggingEnabled: false,
+		EncodeLogsAsJson:      true,
+	})
+
+	stub := &FlakySwapiStub{Error: &UpstreamError{StatusCode: http.StatusInternalServerError}, Failures: 1, Delay: 20 * time.Millisecond}
+	s := &SwapiService{
+		ISwapi: stub,
+		Config: &SwapiConfig{Timeout: time.Second, FailureThreshold: 1, OpenTimeout: 10 * time.Millisecond},
+		Logger: logger,
+	}
+
+	_, err := s.AllPlanets(context.Background())
+	assert.Equal(t, stub.Error, err)
+	time.Sleep(20 * time.Millisecond)
+
+	// the caller gives up during the trial call, which is neither a success nor a failure
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	_, err = s.AllPlanets(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, CircuitHalfOpen, s.Health().State)
+
+	// so the next call is the trial
+	_, err = s.AllPlanets(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, CircuitClosed, s.Health().State)
+}
+
+// blockingRelated waits for the context of its calls to be done
+type blockingRelated struct{}
+
+func (blockingRelated) Films(ctx context.Context, _ []string) (map[string]swapi.Film, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (blockingRelated) People(ctx context.Context, _ []string) (map[string]swapi.Person, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestSwapiService_RelatedCancelled(t *testing.T) {
+
+	logger := log.New(&log.Config{
+		Context:               "sw-api-test",
+		ConsoleLoggingEnabled: false,
+		EncodeLogsAsJson:      true,
+	})
+
+	stub := &FlakySwapiStub{Error: &UpstreamError{StatusCode: http.StatusInternalServerError}, Failures: 1}
+	s := &SwapiService{
+		ISwapi:  stub,
+		Related: blockingRelated{},
+		Config:  &SwapiConfig{Timeout: time.Second, FailureThreshold: 1, OpenTimeout: 10 * time.Millisecond},
+		Logger:  logger,
+	}
+
+	_, err := s.AllPlanets(context.Background())
+	assert.Equal(t, stub.Error, err)
+	time.Sleep(20 * time.Millisecond)
+
+	// a graphql request cancelled during the trial call leaves the circuit to the planet sync
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	_, err = s.Films(ctx, []string{"https://swapi.dev/api/films/1/"})
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, CircuitHalfOpen, s.Health().State)
+
+	_, err = s.AllPlanets(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, CircuitClosed, s.Health().State)
+}
+
+func TestNewSwapiClient_UpstreamStatus(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"detail": "unavailable"}`))
+	}))
+	defer server.Close()
+
+	client := NewSwapiClient(&SwapiConfig{BaseURL: server.URL, Timeout: time.Second})
+
+	_, err := client.AllPlanets(context.Background())
+	assert.True(t, Retryable(err))
+
+	var upstream *UpstreamError
+	assert.True(t, errors.As(err, &upstream))
+	assert.Equal(t, http.StatusServiceUnavailable, upstream.StatusCode)
+}
+
+func TestSwapiClient_Films(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/films/1":
+			w.Write([]byte(`{"title": "A New Hope", "episode_id": 4, "url": "https://swapi.dev/api/films/1/"}`))
+		case "/api/people/1":
+			w.Write([]byte(`{"name": "Luke Skywalker", "url": "https://swapi.dev/api/people/1/"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"detail": "Not found"}`))
+		}
+	}))
+	defer server.Close()
+
+	logger := log.New(&log.Config{
+		Context:               "sw-api-test",
+		ConsoleLoggingEnabled: false,
+		EncodeLogsAsJson:      true,
+	})
+	config := &SwapiConfig{BaseURL: server.URL, Timeout: time.Second, FailureThreshold: 1}
+	client := NewSwapiClient(config)
+	s := &SwapiService{ISwapi: client, Related: client, Config: config, Logger: logger}
+
+	films, err := s.Films(context.Background(), []string{
+		"https://swapi.dev/api/films/1/", "https://swapi.dev/api/films/9/", "not a swapi url",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]swapi.Film{
+		"https://swapi.dev/api/films/1/": {Title: "A New Hope", EpisodeID: 4, URL: "https://swapi.dev/api/films/1/"},
+	}, films)
+
+	people, err := s.People(context.Background(), []string{"https://swapi.dev/api/people/1/"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Luke Skywalker", people["https://swapi.dev/api/people/1/"].Name)
+
+	// snapshots have no films or people
+	s.ISwapi, s.Related = &SnapshotSwapi{}, nil
+	films, err = s.Films(context.Background(), []string{"https://swapi.dev/api/films/1/"})
+	assert.NoError(t, err)
+	assert.Empty(t, films)
+}
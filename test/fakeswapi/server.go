@@ -0,0 +1,250 @@
+// Package fakeswapi is an in-process stand-in for swapi.dev, serving planets from fixture files
+// with knobs for latency, error injection, malformed payloads and rate limiting.
+package fakeswapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gugabfigueiredo/swapi"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultPageSize = 10
+
+type Option func(*Server)
+
+// PageSize of the paginated planets responses
+func PageSize(size int) Option {
+	return func(s *Server) {
+		s.pageSize = size
+	}
+}
+
+// Latency added to every response
+func Latency(latency time.Duration) Option {
+	return func(s *Server) {
+		s.latency = latency
+	}
+}
+
+// Errors makes the next count requests respond with status
+func Errors(status, count int) Option {
+	return func(s *Server) {
+		s.errStatus, s.errCount = status, count
+	}
+}
+
+// Malformed makes page respond with a payload that is not valid json
+func Malformed(page int) Option {
+	return func(s *Server) {
+		s.malformed[page] = true
+	}
+}
+
+// RateLimit allows at most limit requests per window, answering 429 beyond that
+func RateLimit(limit int, window time.Duration) Option {
+	return func(s *Server) {
+		s.rateLimit, s.rateWindow = limit, window
+	}
+}
+
+// Films served at /api/films/N/, N being the id at the end of their url
+func Films(films ...swapi.Film) Option {
+	return func(s *Server) {
+		for _, film := range films {
+			parts := strings.Split(strings.TrimSuffix(film.URL, "/"), "/")
+			s.films[parts[len(parts)-1]] = film
+		}
+	}
+}
+
+// Server mimics the paginated swapi /api/planets/?page=N endpoint and the /api/films/N/ endpoint
+type Server struct {
+	*httptest.Server
+
+	planets  []swapi.Planet
+	films    map[string]swapi.Film
+	pageSize int
+	latency  time.Duration
+
+	mu          sync.Mutex
+	errStatus   int
+	errCount    int
+	malformed   map[int]bool
+	rateLimit   int
+	rateWindow  time.Duration
+	windowStart time.Time
+	windowCount int
+	requests    map[int]int
+}
+
+// New starts a fake swapi serving planets, close it when done
+func New(planets []swapi.Planet, options ...Option) *Server {
+	s := &Server{
+		planets:   planets,
+		films:     map[string]swapi.Film{},
+		pageSize:  DefaultPageSize,
+		malformed: map[int]bool{},
+		requests:  map[int]int{},
+	}
+	for _, option := range options {
+		option(s)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/planets/", s.servePlanets)
+	mux.HandleFunc("/api/films/", s.serveFilm)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// InjectErrors makes the next count requests respond with status
+func (s *Server) InjectErrors(status, count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errStatus, s.errCount = status, count
+}
+
+// Requests returns how many times page was requested, rejected requests included
+func (s *Server) Requests(page int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[page]
+}
+
+func (s *Server) servePlanets(w http.ResponseWriter, r *http.Request) {
+	page := 1
+	if p := r.URL.Query().Get("page"); p != "" {
+		var err error
+		if page, err = strconv.Atoi(p); err != nil || page < 1 {
+			http.Error(w, `{"detail": "Not found"}`, http.StatusNotFound)
+			return
+		}
+	}
+
+	status, malformed := s.admit(page)
+	time.Sleep(s.latency)
+
+	if status != http.StatusOK {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `{"detail": "%s"}`, http.StatusText(status))
+		return
+	}
+
+	start := (page - 1) * s.pageSize
+	if start > len(s.planets) || (start == len(s.planets) && page > 1) {
+		http.Error(w, `{"detail": "Not found"}`, http.StatusNotFound)
+		return
+	}
+	end := start + s.pageSize
+	if end > len(s.planets) {
+		end = len(s.planets)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if malformed {
+		w.Write([]byte(`{"count": 1, "next": null, "results": [{"name": "Tatoo`))
+		return
+	}
+
+	resp := planetsPage{
+		Count:   len(s.planets),
+		Results: s.planets[start:end],
+	}
+	if end < len(s.planets) {
+		next := s.pageURL(page + 1)
+		resp.Next = &next
+	}
+	if page > 1 {
+		previous := s.pageURL(page - 1)
+		resp.Previous = &previous
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) serveFilm(w http.ResponseWriter, r *http.Request) {
+	film, ok := s.films[strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/films/"), "/")]
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"detail": "Not found"}`))
+		return
+	}
+	json.NewEncoder(w).Encode(film)
+}
+
+// admit counts the request and decides whether it is rate limited, fails or gets a malformed payload
+func (s *Server) admit(page int) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests[page]++
+
+	if s.rateLimit > 0 {
+		now := time.Now()
+		if now.Sub(s.windowStart) >= s.rateWindow {
+			s.windowStart, s.windowCount = now, 0
+		}
+		s.windowCount++
+		if s.windowCount > s.rateLimit {
+			return http.StatusTooManyRequests, false
+		}
+	}
+
+	if s.errCount > 0 {
+		s.errCount--
+		return s.errStatus, false
+	}
+
+	return http.StatusOK, s.malformed[page]
+}
+
+func (s *Server) pageURL(page int) string {
+	return fmt.Sprintf("%s/api/planets/?page=%d", s.URL, page)
+}
+
+type planetsPage struct {
+	Count    int            `json:"count"`
+	Next     *string        `json:"next"`
+	Previous *string        `json:"previous"`
+	Results  []swapi.Planet `json:"results"`
+}
+
+// Fixture returns the path of a fixture file bundled with this package
+func Fixture(name string) string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "fixtures", name)
+}
+
+// LoadFixture reads the planets of a fixture file in the shape of a swapi response
+func LoadFixture(name string) ([]swapi.Planet, error) {
+	data, err := ioutil.ReadFile(Fixture(name))
+	if err != nil {
+		return nil, err
+	}
+
+	var p planetsPage
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+	return p.Results, nil
+}
+
+// MustLoadFixture is LoadFixture for tests, panicking on failure
+func MustLoadFixture(name string) []swapi.Planet {
+	planets, err := LoadFixture(name)
+	if err != nil {
+		panic(fmt.Sprintf("fakeswapi: could not load fixture %s: %s", name, err))
+	}
+	return planets
+}
@@ -0,0 +1,67 @@
+package test
+
+import (
+	"context"
+	"github.com/gugabfigueiredo/star-wars-api/model"
+	"github.com/gugabfigueiredo/star-wars-api/repository"
+	"go.mongodb.org/mongo-driver/mongo"
+	"sync"
+	"time"
+)
+
+// LeaseStub is an in memory lease table, share one between services to simulate several replicas
+type LeaseStub struct {
+	mu     sync.Mutex
+	Leases map[string]*model.Lease
+	Error  error
+}
+
+func (s *LeaseStub) AcquireLease(_ context.Context, name, holder string, ttl time.Duration) (*model.Lease, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Error != nil {
+		return nil, s.Error
+	}
+	if s.Leases == nil {
+		s.Leases = map[string]*model.Lease{}
+	}
+
+	now := time.Now()
+	lease, ok := s.Leases[name]
+	switch {
+	case ok && lease.Holder == holder:
+		lease.RenewedAt = now
+	case !ok || !now.Before(lease.ExpiresAt):
+		lease = &model.Lease{Name: name, Holder: holder, AcquiredAt: now, RenewedAt: now}
+		s.Leases[name] = lease
+	default:
+		return nil, repository.ErrLeaseHeld
+	}
+	lease.ExpiresAt = now.Add(ttl)
+
+	copied := *lease
+	return &copied, nil
+}
+
+func (s *LeaseStub) ReleaseLease(_ context.Context, name, holder string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if lease, ok := s.Leases[name]; ok && lease.Holder == holder {
+		delete(s.Leases, name)
+	}
+	return s.Error
+}
+
+func (s *LeaseStub) GetLease(_ context.Context, name string) (*model.Lease, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lease, ok := s.Leases[name]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	copied := *lease
+	return &copied, nil
+}
@@ -1,10 +1,12 @@
 package test
 
 import (
+	"context"
 	"fmt"
 	"github.com/gugabfigueiredo/star-wars-api/model"
 	"github.com/gugabfigueiredo/swapi"
 	"go.mongodb.org/mongo-driver/mongo"
+	"time"
 )
 
 type StringResponse string
@@ -16,58 +18,160 @@ type Stub struct {
 
 	SwapiPlanets []swapi.Planet
 	SwapiUpdates int
+	// SwapiFilms and SwapiPeople are found by their swapi url
+	SwapiFilms  map[string]swapi.Film
+	SwapiPeople map[string]swapi.Person
 
 	Channel chan bool
 
 	InsertResult mongo.InsertManyResult
 	UpdateResult  mongo.BulkWriteResult
+	Conflicts []model.FieldConflict
+	SyncReport model.SyncReport
 	DeleteResult mongo.DeleteResult
+	// Modified is returned by LastModified
+	Modified time.Time
 
 	CalledWith map[string]interface{}
 	RespBody interface{}
 
+	// Block makes repository calls wait for their context to be done, the context error is sent to Cancelled
+	Block bool
+	Cancelled chan error
+
 	Error error
 }
 
-func (s *Stub) GetPlanet(filter interface{}, m *model.Planet) error {
+func (s *Stub) wait(ctx context.Context) error {
+	if !s.Block {
+		return s.Error
+	}
+	<-ctx.Done()
+	if s.Cancelled != nil {
+		s.Cancelled <- ctx.Err()
+	}
+	return ctx.Err()
+}
+
+func (s *Stub) GetPlanet(ctx context.Context, filter interface{}, m *model.Planet) error {
 	s.CalledWith = map[string]interface{}{"filter": filter}
 	if s.Planet != nil {
 		m.Name = s.Planet.Name
 		m.Terrain = s.Planet.Terrain
 		m.Climate = s.Planet.Climate
 		m.Refs = s.Planet.Refs
+		m.UpdatedAt = s.Planet.UpdatedAt
+		m.URL = s.Planet.URL
+		m.Films = s.Planet.Films
+		m.Residents = s.Planet.Residents
 	}
-	return s.Error
+	return s.wait(ctx)
 }
 
-func (s *Stub) GetAllPlanets() ([]*model.Planet, error) {
-	return s.Planets, s.Error
+func (s *Stub) GetAllPlanets(ctx context.Context) ([]*model.Planet, error) {
+	return s.Planets, s.wait(ctx)
 }
 
-func (s *Stub) UpdateMovieRefs(planets []swapi.Planet) (*mongo.BulkWriteResult, error) {
-	s.CalledWith = map[string]interface{}{"planets": planets}
-	return &s.UpdateResult, s.Error
+// FindPlanets returns the Planets with one of the names or urls of query, or all of them when it lists neither,
+// up to its limit
+func (s *Stub) FindPlanets(ctx context.Context, query model.PlanetQuery) ([]*model.Planet, error) {
+	s.CalledWith = map[string]interface{}{"query": query}
+	var found []*model.Planet
+	for _, planet := range s.Planets {
+		if query.Limit > 0 && len(found) == query.Limit {
+			break
+		}
+		if (query.Names == nil && query.URLs == nil) || contains(query.Names, planet.Name) || contains(query.URLs, planet.URL) {
+			found = append(found, planet)
+		}
+	}
+	return found, s.wait(ctx)
 }
 
-func (s *Stub) InsertPlanets(planets []model.Planet) (*mongo.InsertManyResult, error) {
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Stub) UpdateMovieRefs(ctx context.Context, planets []swapi.Planet, policy model.MergePolicy) (*mongo.BulkWriteResult, []model.FieldConflict, error) {
+	s.CalledWith = map[string]interface{}{"planets": planets, "policy": policy}
+	return &s.UpdateResult, s.Conflicts, s.wait(ctx)
+}
+
+func (s *Stub) InsertPlanets(ctx context.Context, planets []model.Planet) (*mongo.InsertManyResult, error) {
 	s.CalledWith = map[string]interface{}{"planets": planets}
-	return &s.InsertResult, s.Error
+	return &s.InsertResult, s.wait(ctx)
 }
 
-func (s *Stub) UpdatePlanets(planet []model.Planet) (*mongo.BulkWriteResult, error) {
+func (s *Stub) UpdatePlanets(ctx context.Context, planet []model.Planet) (*mongo.BulkWriteResult, error) {
 	s.CalledWith = map[string]interface{}{"planets": planet}
-	return &s.UpdateResult, s.Error
+	return &s.UpdateResult, s.wait(ctx)
+}
+
+func (s *Stub) UpsertPlanets(ctx context.Context, planets []model.Planet) (*mongo.BulkWriteResult, error) {
+	s.CalledWith = map[string]interface{}{"planets": planets, "upsert": true}
+	return &s.UpdateResult, s.wait(ctx)
+}
+
+// StreamPlanets calls fn with Planets, then returns Error
+func (s *Stub) StreamPlanets(ctx context.Context, fn func(*model.Planet) error) error {
+	for _, planet := range s.Planets {
+		if err := fn(planet); err != nil {
+			return err
+		}
+	}
+	return s.wait(ctx)
 }
 
-func (s *Stub) DeletePlanets(planets []model.Planet) (*mongo.DeleteResult, error) {
+func (s *Stub) DeletePlanets(ctx context.Context, planets []model.Planet) (*mongo.DeleteResult, error) {
 	s.CalledWith = map[string]interface{}{"planets": planets}
-	return &s.DeleteResult, s.Error
+	return &s.DeleteResult, s.wait(ctx)
 }
 
-func (s *Stub) UpdatePlanetRefs() error {
-	return s.Error
+func (s *Stub) LastModified(ctx context.Context) (time.Time, error) {
+	return s.Modified, s.wait(ctx)
+}
+
+func (s *Stub) UpdatePlanetRefs(ctx context.Context) (*model.SyncReport, error) {
+	return &s.SyncReport, s.wait(ctx)
 }
 
 func AsString(i interface{}) string {
 	return fmt.Sprintf("%+v", i)
-}
\ No newline at end of file
+}
+// Ping makes the stub a database for readiness checks
+func (s *Stub) Ping(ctx context.Context) error {
+	return s.Error
+}
+
+// Migrate records that it was called in CalledWith
+func (s *Stub) Migrate(ctx context.Context) error {
+	s.CalledWith = map[string]interface{}{"migrate": true}
+	return s.wait(ctx)
+}
+
+// Films returns the SwapiFilms at urls
+func (s *Stub) Films(ctx context.Context, urls []string) (map[string]swapi.Film, error) {
+	films := map[string]swapi.Film{}
+	for _, url := range urls {
+		if film, ok := s.SwapiFilms[url]; ok {
+			films[url] = film
+		}
+	}
+	return films, s.wait(ctx)
+}
+
+// People returns the SwapiPeople at urls
+func (s *Stub) People(ctx context.Context, urls []string) (map[string]swapi.Person, error) {
+	people := map[string]swapi.Person{}
+	for _, url := range urls {
+		if person, ok := s.SwapiPeople[url]; ok {
+			people[url] = person
+		}
+	}
+	return people, s.wait(ctx)
+}
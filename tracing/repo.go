@@ -0,0 +1,97 @@
+package tracing
+
+import (
+	"context"
+	"github.com/gugabfigueiredo/star-wars-api/model"
+	"github.com/gugabfigueiredo/star-wars-api/repository"
+	"github.com/gugabfigueiredo/swapi"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+// Repo decorates an IRepo with a span per method, parent to the spans of the mongo commands it runs
+func Repo(repo repository.IRepo) repository.IRepo {
+	return &tracedRepo{IRepo: repo, tracer: otel.Tracer(instrumentation + "/repository")}
+}
+
+type tracedRepo struct {
+	repository.IRepo
+	tracer trace.Tracer
+}
+
+func (r *tracedRepo) start(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return r.tracer.Start(ctx, "repository."+method, trace.WithAttributes(attrs...))
+}
+
+func end(span trace.Span, err error) {
+	// a planet that is not found is an answer, not a failure
+	if err != nil && err != mongo.ErrNoDocuments {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (r *tracedRepo) GetPlanet(ctx context.Context, filter interface{}, planet *model.Planet) (err error) {
+	ctx, span := r.start(ctx, "GetPlanet")
+	defer func() { end(span, err) }()
+	return r.IRepo.GetPlanet(ctx, filter, planet)
+}
+
+func (r *tracedRepo) GetAllPlanets(ctx context.Context) (planets []*model.Planet, err error) {
+	ctx, span := r.start(ctx, "GetAllPlanets")
+	defer func() { end(span, err) }()
+	return r.IRepo.GetAllPlanets(ctx)
+}
+
+func (r *tracedRepo) FindPlanets(ctx context.Context, query model.PlanetQuery) (planets []*model.Planet, err error) {
+	ctx, span := r.start(ctx, "FindPlanets", attribute.Int("limit", query.Limit))
+	defer func() { end(span, err) }()
+	return r.IRepo.FindPlanets(ctx, query)
+}
+
+func (r *tracedRepo) InsertPlanets(ctx context.Context, planets []model.Planet) (res *mongo.InsertManyResult, err error) {
+	ctx, span := r.start(ctx, "InsertPlanets", attribute.Int("planets", len(planets)))
+	defer func() { end(span, err) }()
+	return r.IRepo.InsertPlanets(ctx, planets)
+}
+
+func (r *tracedRepo) UpdatePlanets(ctx context.Context, planets []model.Planet) (res *mongo.BulkWriteResult, err error) {
+	ctx, span := r.start(ctx, "UpdatePlanets", attribute.Int("planets", len(planets)))
+	defer func() { end(span, err) }()
+	return r.IRepo.UpdatePlanets(ctx, planets)
+}
+
+func (r *tracedRepo) UpsertPlanets(ctx context.Context, planets []model.Planet) (res *mongo.BulkWriteResult, err error) {
+	ctx, span := r.start(ctx, "UpsertPlanets", attribute.Int("planets", len(planets)))
+	defer func() { end(span, err) }()
+	return r.IRepo.UpsertPlanets(ctx, planets)
+}
+
+func (r *tracedRepo) StreamPlanets(ctx context.Context, fn func(*model.Planet) error) (err error) {
+	ctx, span := r.start(ctx, "StreamPlanets")
+	defer func() { end(span, err) }()
+	return r.IRepo.StreamPlanets(ctx, fn)
+}
+
+func (r *tracedRepo) UpdateMovieRefs(ctx context.Context, planets []swapi.Planet, policy model.MergePolicy) (res *mongo.BulkWriteResult, conflicts []model.FieldConflict, err error) {
+	ctx, span := r.start(ctx, "UpdateMovieRefs", attribute.Int("planets", len(planets)), attribute.String("policy", string(policy)))
+	defer func() { end(span, err) }()
+	return r.IRepo.UpdateMovieRefs(ctx, planets, policy)
+}
+
+func (r *tracedRepo) DeletePlanets(ctx context.Context, planets []model.Planet) (res *mongo.DeleteResult, err error) {
+	ctx, span := r.start(ctx, "DeletePlanets", attribute.Int("planets", len(planets)))
+	defer func() { end(span, err) }()
+	return r.IRepo.DeletePlanets(ctx, planets)
+}
+
+func (r *tracedRepo) LastModified(ctx context.Context) (modified time.Time, err error) {
+	ctx, span := r.start(ctx, "LastModified")
+	defer func() { end(span, err) }()
+	return r.IRepo.LastModified(ctx)
+}
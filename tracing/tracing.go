@@ -0,0 +1,126 @@
+// Package tracing sets up opentelemetry tracing for http requests, repository operations and swapi calls
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-chi/chi"
+	"github.com/gugabfigueiredo/star-wars-api/httpx"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"os"
+)
+
+const instrumentation = "github.com/gugabfigueiredo/star-wars-api"
+
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+)
+
+// Config - Configuration for tracing
+type Config struct {
+	// Exporter of spans: none, otlp or stdout. Trace context is propagated even with none.
+	Exporter string `default:"none"`
+	// Endpoint of the OTLP/HTTP collector as host:port
+	Endpoint string `default:"localhost:4318"`
+	// Insecure sends spans to the collector over plain http
+	Insecure bool `default:"true"`
+	// ServiceName is reported as service.name on every span
+	ServiceName string `default:"sw-api"`
+	// SampleRatio of traces started here, traces started upstream follow the caller's sampling decision
+	SampleRatio float64 `default:"1"`
+}
+
+type Tracing struct {
+	Provider *sdktrace.TracerProvider
+
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// New builds the exporter selected by config and installs the tracer provider and
+// W3C trace context propagator globally, so the mongo and http instrumentation pick them up
+func New(ctx context.Context, config *Config) (*Tracing, error) {
+	t := &Tracing{
+		propagator: propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}),
+	}
+	otel.SetTextMapPropagator(t.propagator)
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch config.Exporter {
+	case ExporterNone, "":
+	case ExporterOTLP:
+		options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(config.Endpoint)}
+		if config.Insecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, options...)
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", config.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if exporter != nil {
+		t.Provider = sdktrace.NewTracerProvider(
+			sdktrace.WithBatcher(exporter),
+			sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))),
+			sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+				semconv.ServiceNameKey.String(config.ServiceName))),
+		)
+		otel.SetTracerProvider(t.Provider)
+	}
+
+	t.tracer = otel.Tracer(instrumentation + "/tracing")
+	return t, nil
+}
+
+// Shutdown flushes spans that are still buffered
+func (t *Tracing) Shutdown(ctx context.Context) error {
+	if t.Provider == nil {
+		return nil
+	}
+	return t.Provider.Shutdown(ctx)
+}
+
+// Middleware starts a server span for every request, continuing a trace from the traceparent header.
+// The span is named after the matched chi route pattern once the request has been routed.
+func (t *Tracing) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := t.propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := t.tracer.Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.NetAttributesFromHTTPRequest("tcp", r)...),
+		)
+		defer span.End()
+
+		sw := httpx.NewStatusWriter(w)
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(ctx); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		span.SetName(r.Method + " " + route)
+		span.SetAttributes(semconv.HTTPServerAttributesFromHTTPRequest("", route, r)...)
+		span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(sw.Status)...)
+		// a 4xx is the client's fault, not a failure of this server span
+		if sw.Status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(sw.Status))
+		}
+	})
+}
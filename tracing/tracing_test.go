@@ -0,0 +1,130 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/chi"
+	"github.com/gugabfigueiredo/star-wars-api/model"
+	"github.com/gugabfigueiredo/star-wars-api/service"
+	"github.com/gugabfigueiredo/star-wars-api/test"
+	"github.com/gugabfigueiredo/star-wars-api/test/fakeswapi"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newRecorder() *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	return recorder
+}
+
+func TestNew(t *testing.T) {
+
+	_, err := New(context.Background(), &Config{Exporter: "zipkin"})
+	assert.Error(t, err)
+
+	tracing, err := New(context.Background(), &Config{Exporter: ExporterNone})
+	assert.NoError(t, err)
+	assert.Nil(t, tracing.Provider)
+	assert.NoError(t, tracing.Shutdown(context.Background()))
+}
+
+func TestTracing_Middleware(t *testing.T) {
+
+	recorder := newRecorder()
+	tracing := &Tracing{
+		tracer:     otel.Tracer("test"),
+		propagator: propagation.TraceContext{},
+	}
+
+	router := chi.NewRouter()
+	router.Use(tracing.Middleware)
+	router.Get("/planets/name/{name}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	})
+	router.Get("/planets/id/{planetID}", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/planets/name/Hoth", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/planets/id/1", nil))
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 2)
+
+	assert.Equal(t, "GET /planets/name/{name}", spans[0].Name())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", spans[0].Parent().SpanID().String())
+	assert.Equal(t, codes.Unset, spans[0].Status().Code)
+
+	assert.Equal(t, "GET /planets/id/{planetID}", spans[1].Name())
+	assert.False(t, spans[1].Parent().IsValid())
+	assert.Equal(t, codes.Error, spans[1].Status().Code)
+}
+
+func TestRepo(t *testing.T) {
+
+	recorder := newRecorder()
+
+	stub := &test.Stub{}
+	repo := Repo(stub)
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "request")
+
+	var planet model.Planet
+	stub.Error = mongo.ErrNoDocuments
+	repo.GetPlanet(ctx, nil, &planet)
+
+	stub.Error = errors.New("connection reset")
+	repo.DeletePlanets(ctx, []model.Planet{{Name: "Hoth"}})
+	parent.End()
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 3)
+
+	assert.Equal(t, "repository.GetPlanet", spans[0].Name())
+	assert.Equal(t, codes.Unset, spans[0].Status().Code)
+	assert.Equal(t, parent.SpanContext().SpanID(), spans[0].Parent().SpanID())
+
+	assert.Equal(t, "repository.DeletePlanets", spans[1].Name())
+	assert.Equal(t, codes.Error, spans[1].Status().Code)
+	assert.Equal(t, parent.SpanContext().SpanID(), spans[1].Parent().SpanID())
+}
+
+func TestSwapiClientSpans(t *testing.T) {
+
+	recorder := newRecorder()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	server := fakeswapi.New(fakeswapi.MustLoadFixture("planets.json"), fakeswapi.PageSize(5))
+	defer server.Close()
+
+	client := service.NewSwapiClient(&service.SwapiConfig{BaseURL: server.URL, Timeout: time.Second})
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "sync")
+	planets, err := client.AllPlanets(ctx)
+	parent.End()
+
+	assert.NoError(t, err)
+	assert.Len(t, planets, 13)
+
+	// one client span per page, all children of the caller span
+	var pages int
+	for _, span := range recorder.Ended() {
+		if span.Parent().SpanID() == parent.SpanContext().SpanID() {
+			pages++
+		}
+	}
+	assert.Equal(t, 3, pages)
+}